@@ -15,6 +15,7 @@ func wsHandler(conn *fastws.Conn) {
 	var err error
 	var fr = fastws.AcquireFrame()
 	conn.MaxPayloadSize = 65536
+	conn.Strict = true
 	var accp []byte // accumulated payload
 	for {
 		accp, err = conn.ReadFull(accp[:0], fr)
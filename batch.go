@@ -0,0 +1,102 @@
+package fastws
+
+// Message is a message to send using WriteBatch.
+type Message struct {
+	// Mode is the mode of the message.
+	Mode Mode
+	// Payload is the message data.
+	Payload []byte
+}
+
+// WriteBatch writes msgs flushing the connection once (unless the auto flush
+// is disabled, see SetAutoFlush), returning the number of bytes written
+// (frame headers included) as WriteMessage does.
+//
+// Writing many small messages at once saves a flush (and a write syscall)
+// per message, though the data is written every time the write buffer
+// gets full. The messages are written in order, without any other
+// data message in between. If writing fails the messages written
+// before the failing one may have been sent or not.
+func (conn *Conn) WriteBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	defer conn.closeIfPeerDead()
+
+	single := conn.isSingleWriter()
+	if !single {
+		conn.lockMessage()
+		defer conn.msgLck.Unlock()
+	}
+	conn.lockFrames(single)
+	defer conn.unlockFrames(single)
+	if conn.closed {
+		return 0, errClosed
+	}
+
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	n := 0
+	stop := conn.beginWrite(nil)
+	var err error
+	for i := 0; i < len(msgs) && err == nil; i++ {
+		fr.Reset()
+		fr.SetFin()
+		if msgs[i].Mode == ModeBinary {
+			fr.SetBinary()
+		} else {
+			fr.SetText()
+		}
+		conn.tap(DirectionOut, msgs[i].Mode, msgs[i].Payload)
+		fr.SetPayload(msgs[i].Payload)
+		if !conn.server {
+			conn.mask(fr)
+		}
+		storeMax(&conn.stats.maxFrameWritten, uint64(fr.PayloadLen()))
+		conn.logFrame(DirectionOut, fr.Code(), true, fr.IsMasked(), fr.Len())
+
+		var nn int64
+		nn, err = fr.WriteTo(conn.bf)
+		n += int(nn)
+		if err == nil {
+			conn.reportWrite(len(msgs[i].Payload), true)
+		}
+	}
+	if err == nil && !conn.manualFlush {
+		err = conn.bf.Flush()
+	}
+	conn.endWrite(stop, err)
+
+	return n, err
+}
+
+// SetAutoFlush sets whether the data frames are flushed after writing them.
+//
+// When disabled the frames are buffered until the write buffer gets full,
+// Flush is called or a control frame (PING, PONG or close) is written,
+// so many frames can be sent using a single write. The auto flush is enabled by default.
+func (conn *Conn) SetAutoFlush(auto bool) {
+	conn.lck.Lock()
+	conn.manualFlush = !auto
+	conn.lck.Unlock()
+}
+
+// Flush writes the frames buffered to the connection (see SetAutoFlush).
+func (conn *Conn) Flush() error {
+	defer conn.closeIfPeerDead()
+
+	single := conn.isSingleWriter()
+	conn.lockFrames(single)
+	defer conn.unlockFrames(single)
+	if conn.closed {
+		return errClosed
+	}
+
+	stop := conn.beginWrite(nil)
+	err := conn.bf.Flush()
+	conn.endWrite(stop, err)
+
+	return err
+}
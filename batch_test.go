@@ -0,0 +1,92 @@
+package fastws
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport counts the writes to the transport.
+type countingTransport struct {
+	net.Conn
+	writes int32
+}
+
+func (c *countingTransport) Write(b []byte) (int, error) {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func TestWriteBatch(t *testing.T) {
+	c1, c2 := net.Pipe()
+	ct := &countingTransport{Conn: c1}
+
+	server := acquireConn(ct)
+	server.server = true
+	client := acquireConn(c2)
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	msgs := []Message{
+		{ModeText, []byte("Hello")},
+		{ModeBinary, []byte{1, 2, 3}},
+		{ModeText, []byte("world")},
+	}
+	go func() {
+		if _, err := server.WriteBatch(msgs); err != nil {
+			panic(err)
+		}
+	}()
+
+	for _, msg := range msgs {
+		mode, b, err := client.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != msg.Mode || string(b) != string(msg.Payload) {
+			t.Fatalf("Unexpected message: %v %q <> %v %q", mode, b, msg.Mode, msg.Payload)
+		}
+	}
+	if n := atomic.LoadInt32(&ct.writes); n != 1 {
+		t.Fatalf("Unexpected writes: %d <> 1", n)
+	}
+}
+
+func TestManualFlush(t *testing.T) {
+	c1, c2 := net.Pipe()
+	ct := &countingTransport{Conn: c1}
+
+	server := acquireConn(ct)
+	server.server = true
+	client := acquireConn(c2)
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetAutoFlush(false)
+	for _, s := range []string{"Hello", "world"} {
+		if _, err := server.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&ct.writes); n != 0 {
+		t.Fatalf("Unexpected writes before flushing: %d", n)
+	}
+
+	go func() {
+		if err := server.Flush(); err != nil {
+			panic(err)
+		}
+	}()
+	for _, s := range []string{"Hello", "world"} {
+		_, b, err := client.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Fatalf("Unexpected message: %s <> %s", b, s)
+		}
+	}
+	if n := atomic.LoadInt32(&ct.writes); n != 1 {
+		t.Fatalf("Unexpected writes: %d <> 1", n)
+	}
+}
@@ -0,0 +1,158 @@
+package fastws
+
+import (
+	"sync/atomic"
+)
+
+// maxFrameHeaderSize is the size of the largest frame header (with mask key).
+const maxFrameHeaderSize = 14
+
+const (
+	// MinBufferSize is the smallest buffer size suggested by AdviseBuffers.
+	MinBufferSize = 4096
+	// MaxBufferSize is the biggest buffer size suggested by AdviseBuffers.
+	// The frames bigger than the buffers are read and written in many calls.
+	MaxBufferSize = 1 << 20
+)
+
+// BufferStats represents the high-water marks of the buffers of a connection.
+type BufferStats struct {
+	// ReadBufferSize is the size of the read buffer.
+	ReadBufferSize int
+	// MaxReadBuffered is the max number of bytes buffered and
+	// not consumed after reading a frame.
+	MaxReadBuffered int
+
+	// FrameQueueSize is the number of frames the readLoop can queue
+	// before waiting for the frames to be read.
+	FrameQueueSize int
+	// MaxFrameQueue is the max number of frames taken from the frame pool
+	// and queued by the readLoop, counting the frame waiting to be queued.
+	// It exceeds FrameQueueSize when the readLoop waited for the frames to be read.
+	MaxFrameQueue int
+
+	// MaxSendQueue is the max number of writers holding or waiting for the write lock.
+	MaxSendQueue int
+
+	// MaxFrameRead is the payload size of the biggest frame received.
+	MaxFrameRead uint64
+	// MaxFrameWritten is the payload size of the biggest frame sent.
+	MaxFrameWritten uint64
+	// MaxMessageRead is the size of the biggest message read using
+	// ReadMessage, ReadMessageContext or ReadFull.
+	MaxMessageRead uint64
+}
+
+// BufferStats returns the buffer high-water marks of conn.
+func (conn *Conn) BufferStats() BufferStats {
+	conn.cLck.RLock()
+	readSize := conn.bf.Reader.Size()
+	conn.cLck.RUnlock()
+
+	return BufferStats{
+		ReadBufferSize:  readSize,
+		MaxReadBuffered: int(atomic.LoadUint64(&conn.stats.maxReadBuffered)),
+		FrameQueueSize:  cap(conn.framer),
+		MaxFrameQueue:   int(atomic.LoadUint64(&conn.stats.maxFrameQueue)),
+		MaxSendQueue:    int(atomic.LoadUint64(&conn.stats.maxSendQueue)),
+		MaxFrameRead:    atomic.LoadUint64(&conn.stats.maxFrameRead),
+		MaxFrameWritten: atomic.LoadUint64(&conn.stats.maxFrameWritten),
+		MaxMessageRead:  atomic.LoadUint64(&conn.stats.maxMessageRead),
+	}
+}
+
+// BufferReport represents the buffer high-water marks of many connections
+// and the settings suggested for their traffic.
+type BufferReport struct {
+	// Conns is the number of connections.
+	Conns int
+	// Max holds the max of every high-water mark of the connections.
+	Max BufferStats
+
+	// FrameQueueFull is the number of connections whose frame queue got full,
+	// meaning the readLoop waited for the frames to be read.
+	FrameQueueFull int
+	// SendQueueContended is the number of connections written
+	// by many goroutines at once.
+	SendQueueContended int
+
+	// ReadBufferSize is the read buffer size suggested to read the biggest frames in one call.
+	ReadBufferSize int
+	// WriteBufferSize is the write buffer size suggested to write the biggest frames in one call.
+	WriteBufferSize int
+	// MaxPayloadSize is the suggested Conn.MaxPayloadSize,
+	// twice the biggest frame received.
+	MaxPayloadSize uint64
+	// MaxMessageSize is the suggested Conn.MaxMessageSize,
+	// twice the biggest message read.
+	MaxMessageSize uint64
+}
+
+// AdviseBuffers aggregates the buffer high-water marks of conns,
+// suggesting the buffer sizes and limits fitting their traffic.
+//
+// The buffer sizes are powers of two between MinBufferSize and MaxBufferSize.
+// The limits are 0 (not suggested) when no frame or message has been read.
+func AdviseBuffers(conns []*Conn) BufferReport {
+	var r BufferReport
+
+	for _, conn := range conns {
+		s := conn.BufferStats()
+		r.Conns++
+		if s.FrameQueueSize > 0 && s.MaxFrameQueue > s.FrameQueueSize {
+			r.FrameQueueFull++
+		}
+		if s.MaxSendQueue > 1 {
+			r.SendQueueContended++
+		}
+
+		r.Max.ReadBufferSize = maxInt(r.Max.ReadBufferSize, s.ReadBufferSize)
+		r.Max.MaxReadBuffered = maxInt(r.Max.MaxReadBuffered, s.MaxReadBuffered)
+		r.Max.FrameQueueSize = maxInt(r.Max.FrameQueueSize, s.FrameQueueSize)
+		r.Max.MaxFrameQueue = maxInt(r.Max.MaxFrameQueue, s.MaxFrameQueue)
+		r.Max.MaxSendQueue = maxInt(r.Max.MaxSendQueue, s.MaxSendQueue)
+		r.Max.MaxFrameRead = maxUint64(r.Max.MaxFrameRead, s.MaxFrameRead)
+		r.Max.MaxFrameWritten = maxUint64(r.Max.MaxFrameWritten, s.MaxFrameWritten)
+		r.Max.MaxMessageRead = maxUint64(r.Max.MaxMessageRead, s.MaxMessageRead)
+	}
+
+	r.ReadBufferSize = bufferSize(r.Max.MaxFrameRead + maxFrameHeaderSize)
+	r.WriteBufferSize = bufferSize(r.Max.MaxFrameWritten + maxFrameHeaderSize)
+	r.MaxPayloadSize = r.Max.MaxFrameRead * 2
+	r.MaxMessageSize = r.Max.MaxMessageRead * 2
+
+	return r
+}
+
+// bufferSize returns the power of two fitting n, clamped between MinBufferSize and MaxBufferSize.
+func bufferSize(n uint64) int {
+	size := MinBufferSize
+	for uint64(size) < n && size < MaxBufferSize {
+		size <<= 1
+	}
+	return size
+}
+
+// storeMax stores n in addr if it's greater than the value stored.
+func storeMax(addr *uint64, n uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if n <= old || atomic.CompareAndSwapUint64(addr, old, n) {
+			return
+		}
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,65 @@
+package fastws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBufferStats(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go writeFragments(client, "Hello", " ", strings.Repeat("a", 5000))
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 5006 {
+		t.Fatalf("Unexpected message size: %d <> 5006", len(b))
+	}
+
+	s := server.BufferStats()
+	if s.ReadBufferSize != 4096 || s.FrameQueueSize != 128 {
+		t.Fatalf("Unexpected buffer sizes: %d %d", s.ReadBufferSize, s.FrameQueueSize)
+	}
+	if s.MaxFrameRead != 5000 || s.MaxMessageRead != 5006 {
+		t.Fatalf("Unexpected high-water marks: %d %d", s.MaxFrameRead, s.MaxMessageRead)
+	}
+	if s.MaxFrameQueue < 1 {
+		t.Fatalf("Unexpected frame queue: %d", s.MaxFrameQueue)
+	}
+	if s := client.BufferStats(); s.MaxFrameWritten != 5000 || s.MaxSendQueue != 1 {
+		t.Fatalf("Unexpected write high-water marks: %d %d", s.MaxFrameWritten, s.MaxSendQueue)
+	}
+}
+
+func TestAdviseBuffers(t *testing.T) {
+	var conns []*Conn
+	for _, size := range []int{10, 20000} {
+		server, client := pipeConns()
+		defer client.mustClose(false)
+		defer server.mustClose(false)
+
+		go client.WriteMessage(ModeBinary, make([]byte, size))
+		if _, _, err := server.ReadMessage(nil); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, server)
+	}
+
+	r := AdviseBuffers(conns)
+	if r.Conns != 2 || r.Max.MaxFrameRead != 20000 || r.Max.MaxMessageRead != 20000 {
+		t.Fatalf("Unexpected report: %+v", r)
+	}
+	if r.ReadBufferSize != 32768 || r.WriteBufferSize != MinBufferSize {
+		t.Fatalf("Unexpected buffer sizes: %d %d", r.ReadBufferSize, r.WriteBufferSize)
+	}
+	if r.MaxPayloadSize != 40000 || r.MaxMessageSize != 40000 {
+		t.Fatalf("Unexpected limits: %d %d", r.MaxPayloadSize, r.MaxMessageSize)
+	}
+	if r.FrameQueueFull != 0 || r.SendQueueContended != 0 {
+		t.Fatalf("Unexpected queues: %d %d", r.FrameQueueFull, r.SendQueueContended)
+	}
+}
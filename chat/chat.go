@@ -0,0 +1,355 @@
+// Package chat implements chat rooms over fastws connections.
+//
+// The rooms keep track of the members (presence) and the last messages (history),
+// and allow moderators to kick members out.
+//
+// The messages are queued to the members using a fastws.Hub,
+// so a slow member doesn't delay the others: the members whose queue
+// gets full are closed (see fastws.Hub).
+//
+// The events are sent to the members as JSON encoded Message values.
+// A handler serving a member only needs a few lines:
+//
+//	var server chat.Server
+//
+//	func wsHandler(conn *fastws.Conn) {
+//		room := conn.UserValue("room").(string)
+//		name := conn.UserValue("name").(string)
+//		server.Serve(conn, room, name)
+//	}
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrr/fastws"
+)
+
+// DefaultHistorySize is the default number of messages kept per room.
+const DefaultHistorySize = 50
+
+var (
+	// ErrNameInUse is returned when joining a room using the name of other member.
+	ErrNameInUse = errors.New("chat: name already in use")
+	// ErrNotMember is returned when the member is not in the room.
+	ErrNotMember = errors.New("chat: not a member of the room")
+)
+
+// Event types.
+const (
+	EventMessage = "message"
+	EventJoin    = "join"
+	EventLeave   = "leave"
+	EventKick    = "kick"
+)
+
+// Message is an event sent to the members of a room.
+type Message struct {
+	Type string    `json:"type"`
+	Room string    `json:"room"`
+	From string    `json:"from"`
+	Text string    `json:"text,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Server manages the chat rooms.
+//
+// The rooms are created when the first member joins
+// and removed when the last one leaves.
+type Server struct {
+	// HistorySize is the max number of messages kept per room.
+	// The history is sent to the members when joining,
+	// so it should be lower than the QueueSize of Hub.
+	//
+	// By default HistorySize is DefaultHistorySize.
+	HistorySize int
+
+	// Hub queues the messages to the members, which join the Hub room
+	// of the same name.
+	Hub fastws.Hub
+
+	lck   sync.Mutex
+	rooms map[string]*roomState
+}
+
+type roomState struct {
+	members map[string]*fastws.Conn
+	history []Message
+}
+
+// Serve joins conn to the room as name and broadcasts the text messages
+// received until the connection is closed.
+//
+// The member leaves the room and conn is unregistered from Hub when Serve returns.
+func (s *Server) Serve(conn *fastws.Conn, room, name string) error {
+	err := s.Join(conn, room, name)
+	if err != nil {
+		return err
+	}
+	defer s.Hub.Unregister(conn)
+	defer s.leave(conn, room, name)
+
+	var b []byte
+	for {
+		var mode fastws.Mode
+		mode, b, err = conn.ReadMessage(b[:0])
+		if err != nil {
+			break
+		}
+		if mode == fastws.ModeText {
+			s.Send(room, name, string(b))
+		}
+	}
+
+	return err
+}
+
+// Join adds conn to the room as name, queueing the room history to conn.
+//
+// The other members are notified with an EventJoin message.
+func (s *Server) Join(conn *fastws.Conn, room, name string) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if s.rooms == nil {
+		s.rooms = make(map[string]*roomState)
+	}
+	r := s.rooms[room]
+	if r == nil {
+		r = newRoom()
+		s.rooms[room] = r
+	}
+	if _, ok := r.members[name]; ok {
+		return ErrNameInUse
+	}
+
+	// the history is queued before joining the Hub room,
+	// so it's written before the messages published later.
+	s.Hub.Register(conn)
+	for i := range r.history {
+		b, err := json.Marshal(&r.history[i])
+		if err == nil {
+			err = s.Hub.Send(conn, fastws.ModeText, b)
+		}
+		if err != nil {
+			if len(r.members) == 0 {
+				delete(s.rooms, room)
+			}
+			return err
+		}
+	}
+	r.members[name] = conn
+	s.Hub.Join(conn, room)
+
+	s.publish(&Message{
+		Type: EventJoin,
+		Room: room,
+		From: name,
+	})
+
+	return nil
+}
+
+// Leave removes name from the room, notifying the other members
+// with an EventLeave message.
+func (s *Server) Leave(room, name string) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	conn := s.member(room, name)
+	if conn == nil {
+		return ErrNotMember
+	}
+	s.removeAndPublish(conn, room, name)
+
+	return nil
+}
+
+// leave removes name from the room if it's still joined using conn.
+func (s *Server) leave(conn *fastws.Conn, room, name string) {
+	s.lck.Lock()
+	s.removeAndPublish(conn, room, name)
+	s.lck.Unlock()
+}
+
+// removeAndPublish removes name from the room if it's joined using conn,
+// notifying the other members with an EventLeave message.
+//
+// removeAndPublish must be called holding s.lck.
+func (s *Server) removeAndPublish(conn *fastws.Conn, room, name string) {
+	if s.remove(conn, room, name) {
+		s.publish(&Message{
+			Type: EventLeave,
+			Room: room,
+			From: name,
+		})
+	}
+}
+
+// Send sends text to the room members on behalf of from, adding it to the history.
+func (s *Server) Send(room, from, text string) {
+	s.lck.Lock()
+	s.publish(&Message{
+		Type: EventMessage,
+		Room: room,
+		From: from,
+		Text: text,
+	})
+	s.lck.Unlock()
+}
+
+// Kick removes name from the room, notifying all the members
+// with an EventKick message (reason as text).
+//
+// The connection of the member is closed with fastws.StatusViolation
+// (see fastws.Conn.CloseWithCode) in the background,
+// so Kick doesn't wait for the member to reply to the close frame.
+func (s *Server) Kick(room, name, reason string) error {
+	msg := &Message{
+		Type: EventKick,
+		Room: room,
+		From: name,
+		Text: reason,
+	}
+
+	s.lck.Lock()
+	conn := s.member(room, name)
+	if conn != nil {
+		s.remove(conn, room, name)
+		s.publish(msg)
+	}
+	s.lck.Unlock()
+
+	if conn == nil {
+		return ErrNotMember
+	}
+
+	go func() {
+		// the kicked member left the Hub room, so it gets the event directly.
+		writeMessage(conn, msg)
+
+		err := conn.CloseWithCode(fastws.StatusViolation, reason)
+		if errors.Is(err, fastws.ErrInvalidCloseReason) {
+			// the reason doesn't fit in the close frame, but it was already sent.
+			conn.CloseWithCode(fastws.StatusViolation, "")
+		}
+	}()
+
+	return nil
+}
+
+// Members returns the names of the members of the room.
+func (s *Server) Members(room string) []string {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	r := s.rooms[room]
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.members))
+	for name := range r.members {
+		names = append(names, name)
+	}
+	return names
+}
+
+// History returns the last messages sent to the room.
+func (s *Server) History(room string) []Message {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if r := s.rooms[room]; r != nil {
+		return append([]Message(nil), r.history...)
+	}
+	return nil
+}
+
+// Rooms returns the names of the rooms.
+func (s *Server) Rooms() []string {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	return names
+}
+
+func newRoom() *roomState {
+	return &roomState{
+		members: make(map[string]*fastws.Conn),
+	}
+}
+
+// member returns the connection of name in room, if any.
+//
+// member must be called holding s.lck.
+func (s *Server) member(room, name string) *fastws.Conn {
+	if r := s.rooms[room]; r != nil {
+		return r.members[name]
+	}
+	return nil
+}
+
+// remove removes name from room if it's joined using conn,
+// removing the room if it gets empty.
+//
+// remove must be called holding s.lck.
+func (s *Server) remove(conn *fastws.Conn, room, name string) bool {
+	r := s.rooms[room]
+	if r == nil || r.members[name] != conn {
+		return false
+	}
+	delete(r.members, name)
+	s.Hub.Leave(conn, room)
+	if len(r.members) == 0 {
+		delete(s.rooms, room)
+	}
+	return true
+}
+
+// publish queues msg to the members of msg.Room.
+// Publishing holding s.lck keeps the order of the history and the events,
+// and it doesn't block, as the messages are only queued.
+//
+// The messages of type EventMessage are added to the history.
+//
+// publish must be called holding s.lck.
+func (s *Server) publish(msg *Message) {
+	r := s.rooms[msg.Room]
+	if r == nil {
+		return
+	}
+	msg.Time = time.Now()
+	if msg.Type == EventMessage {
+		r.history = append(r.history, *msg)
+		size := s.HistorySize
+		if size <= 0 {
+			size = DefaultHistorySize
+		}
+		if n := len(r.history) - size; n > 0 {
+			r.history = append(r.history[:0], r.history[n:]...)
+		}
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	// the members too slow are removed from the Hub and closed,
+	// leaving the room when their Serve returns.
+	s.Hub.Publish(msg.Room, fastws.ModeText, b)
+}
+
+func writeMessage(conn *fastws.Conn, msg *Message) error {
+	b, err := json.Marshal(msg)
+	if err == nil {
+		_, err = conn.WriteMessage(fastws.ModeText, b)
+	}
+	return err
+}
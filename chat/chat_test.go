@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dgrr/fastws"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func configureServer(s *Server) (*fasthttputil.InmemoryListener, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	upgrade := fastws.Upgrade(func(conn *fastws.Conn) {
+		room := conn.UserValue("room").(string)
+		name := conn.UserValue("name").(string)
+		s.Serve(conn, room, name)
+	})
+	server := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetUserValue("room", string(ctx.QueryArgs().Peek("room")))
+			ctx.SetUserValue("name", string(ctx.QueryArgs().Peek("name")))
+			upgrade(ctx)
+		},
+	}
+	go server.Serve(ln)
+
+	return ln, func() {
+		ln.Close()
+	}
+}
+
+func join(t *testing.T, ln *fasthttputil.InmemoryListener, room, name string) *fastws.Conn {
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := fastws.Client(c, "http://localhost/?room="+room+"&name="+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readMessage(t *testing.T, conn *fastws.Conn) Message {
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	if err = json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func expectMessage(t *testing.T, conn *fastws.Conn, typ, from, text string) {
+	msg := readMessage(t, conn)
+	if msg.Type != typ || msg.From != from || msg.Text != text {
+		t.Fatalf("Unexpected message: %+v <> %s %s %s", msg, typ, from, text)
+	}
+}
+
+func TestChat(t *testing.T) {
+	var s Server
+	ln, stop := configureServer(&s)
+	defer stop()
+
+	alice := join(t, ln, "lobby", "alice")
+	expectMessage(t, alice, EventJoin, "alice", "")
+
+	alice.WriteString("Hello")
+	expectMessage(t, alice, EventMessage, "alice", "Hello")
+
+	// the history is sent when joining
+	bob := join(t, ln, "lobby", "bob")
+	expectMessage(t, bob, EventMessage, "alice", "Hello")
+	expectMessage(t, bob, EventJoin, "bob", "")
+	expectMessage(t, alice, EventJoin, "bob", "")
+
+	if n := len(s.Members("lobby")); n != 2 {
+		t.Fatalf("Unexpected members: %d <> 2", n)
+	}
+
+	bob.WriteString("Hi")
+	expectMessage(t, alice, EventMessage, "bob", "Hi")
+	expectMessage(t, bob, EventMessage, "bob", "Hi")
+
+	if h := s.History("lobby"); len(h) != 2 || h[1].Text != "Hi" {
+		t.Fatalf("Unexpected history: %+v", h)
+	}
+
+	bob.Close()
+	expectMessage(t, alice, EventLeave, "bob", "")
+
+	alice.Close()
+}
+
+func TestChatKick(t *testing.T) {
+	var s Server
+	ln, stop := configureServer(&s)
+	defer stop()
+
+	alice := join(t, ln, "lobby", "alice")
+	expectMessage(t, alice, EventJoin, "alice", "")
+	mallory := join(t, ln, "lobby", "mallory")
+	expectMessage(t, mallory, EventJoin, "mallory", "")
+	expectMessage(t, alice, EventJoin, "mallory", "")
+
+	if err := s.Kick("lobby", "mallory", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, alice, EventKick, "mallory", "spam")
+	expectMessage(t, mallory, EventKick, "mallory", "spam")
+
+	_, _, err := mallory.ReadMessage(nil)
+	var cerr *fastws.CloseError
+	if !errors.As(err, &cerr) || cerr.Status != fastws.StatusViolation {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := s.Kick("lobby", "mallory", ""); err != ErrNotMember {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrNotMember)
+	}
+	if m := s.Members("lobby"); len(m) != 1 || m[0] != "alice" {
+		t.Fatalf("Unexpected members: %v", m)
+	}
+
+	alice.Close()
+}
+
+func TestChatNameInUse(t *testing.T) {
+	var s Server
+	ln, stop := configureServer(&s)
+	defer stop()
+
+	alice := join(t, ln, "lobby", "alice")
+	expectMessage(t, alice, EventJoin, "alice", "")
+
+	other := join(t, ln, "lobby", "alice")
+	if _, _, err := other.ReadMessage(nil); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	alice.Close()
+}
@@ -6,7 +6,10 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -14,24 +17,37 @@ import (
 var (
 	// ErrCannotUpgrade shows up when an error ocurred when upgrading a connection.
 	ErrCannotUpgrade = errors.New("cannot upgrade connection")
+	// ErrInsecureRedirect is returned when dialing a wss:// URL redirecting to a ws:// one.
+	ErrInsecureRedirect = errors.New("cannot redirect from wss:// to ws://")
 )
 
 // Client returns Conn using existing connection.
 //
 // url must be complete URL format i.e. http://localhost:8080/ws
 func Client(c net.Conn, url string) (*Conn, error) {
-	return client(c, url, nil)
+	return client(c, url, nil, nil)
 }
 
 // ClientWithHeaders returns a Conn using existing connection and sending personalized headers.
 func ClientWithHeaders(c net.Conn, url string, req *fasthttp.Request) (*Conn, error) {
-	return client(c, url, req)
+	return client(c, url, req, nil)
 }
 
 // UpgradeAsClient will upgrade the connection as a client
 //
 // r can be nil.
+//
+// If the server doesn't upgrade the connection the error is an *UpgradeError.
 func UpgradeAsClient(c net.Conn, url string, r *fasthttp.Request) error {
+	_, _, err := upgradeAsClient(c, url, r, 0, false)
+	return err
+}
+
+// upgradeAsClient performs the client handshake and returns the reader
+// used to parse the response, as it might have buffered the first frames.
+// readSize is the size of the reader (the default size if <= 0).
+// If compress is true permessage-deflate is offered, returning whether the server accepted it.
+func upgradeAsClient(c net.Conn, url string, r *fasthttp.Request, readSize int, compress bool) (*bufio.Reader, bool, error) {
 	req := fasthttp.AcquireRequest()
 	res := fasthttp.AcquireResponse()
 	uri := fasthttp.AcquireURI()
@@ -59,11 +75,13 @@ func UpgradeAsClient(c net.Conn, url string, r *fasthttp.Request) error {
 	req.Header.AddBytesKV(upgradeString, websocketString)
 	req.Header.AddBytesKV(wsHeaderVersion, supportedVersions[0])
 	req.Header.AddBytesKV(wsHeaderKey, key)
-	// TODO: Add compression
+	if compress {
+		req.Header.SetBytesK(wsHeaderExtensions, deflateOffer)
+	}
 
 	req.SetRequestURIBytes(uri.FullURI())
 
-	br := bufio.NewReader(c)
+	br := newBufioReader(c, readSize)
 	bw := bufio.NewWriter(c)
 	req.Write(bw)
 	bw.Flush()
@@ -71,50 +89,316 @@ func UpgradeAsClient(c net.Conn, url string, r *fasthttp.Request) error {
 	if err == nil {
 		if res.StatusCode() != 101 ||
 			!equalsFold(res.Header.PeekBytes(upgradeString), websocketString) {
-			err = ErrCannotUpgrade
+			err = newUpgradeError(res)
 		}
 	}
+	if err == nil {
+		// the server must not accept extensions not offered.
+		compress, err = acceptDeflate(res.Header.PeekBytes(wsHeaderExtensions), compress)
+	}
 
-	return err
+	return br, compress, err
+}
+
+var (
+	retryAfterString = []byte("Retry-After")
+	locationString   = []byte("Location")
+)
+
+func newUpgradeError(res *fasthttp.Response) *UpgradeError {
+	return &UpgradeError{
+		StatusCode: res.StatusCode(),
+		RetryAfter: parseRetryAfter(res.Header.PeekBytes(retryAfterString), time.Now()),
+		Location:   string(res.Header.PeekBytes(locationString)),
+	}
 }
 
-func client(c net.Conn, url string, r *fasthttp.Request) (conn *Conn, err error) {
-	err = UpgradeAsClient(c, url, r)
+// parseRetryAfter parses the Retry-After header value b,
+// which can be a number of seconds or an HTTP date.
+func parseRetryAfter(b []byte, now time.Time) time.Duration {
+	if len(b) == 0 {
+		return 0
+	}
+	if secs, err := fasthttp.ParseUint(b); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if date, err := fasthttp.ParseHTTPDate(b); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// client upgrades c as client, applying cfg (if not nil) to the Conn.
+func client(c net.Conn, url string, r *fasthttp.Request, cfg *Config) (conn *Conn, err error) {
+	var readSize int
+	if cfg != nil {
+		readSize = cfg.ReadBufferSize
+	}
+	br, _, err := upgradeAsClient(c, url, r, readSize, false)
 	if err == nil {
-		conn = acquireConn(c)
-		conn.server = false
+		conn = newClientConn(c, br, cfg, false)
 	}
 
 	return conn, err
 }
 
+// newClientConn returns the client Conn over c reading from br, applying cfg (if not nil).
+// compress is whether permessage-deflate was negotiated.
+func newClientConn(c net.Conn, br *bufio.Reader, cfg *Config, compress bool) *Conn {
+	conn := acquireConnConfig(c, br, cfg)
+	conn.server = false
+	conn.compress = compress
+	return conn
+}
+
 // Dial establishes a websocket connection as client.
 //
 // url parameter must follow WebSocket URL format i.e. ws://host:port/path
 func Dial(url string) (*Conn, error) {
-	cnf := &tls.Config{
-		InsecureSkipVerify: false,
-		MinVersion:         tls.VersionTLS11,
-	}
-	return dial(url, cnf, nil)
+	d := Dialer{}
+	return d.Dial(url)
 }
 
 // DialTLS establishes a websocket connection as client with the
 // parsed tls.Config. The config will be used if the URL is wss:// like.
 func DialTLS(url string, cnf *tls.Config) (*Conn, error) {
-	return dial(url, cnf, nil)
+	d := Dialer{
+		TLSConfig: cnf,
+	}
+	return d.Dial(url)
 }
 
 // DialWithHeaders establishes a websocket connection as client sending a personalized request.
 func DialWithHeaders(url string, req *fasthttp.Request) (*Conn, error) {
-	cnf := &tls.Config{
-		InsecureSkipVerify: false,
-		MinVersion:         tls.VersionTLS11,
+	d := Dialer{}
+	return d.DialWithHeaders(url, req)
+}
+
+// Dialer establishes websocket connections as client.
+type Dialer struct {
+	// TLSConfig is the tls.Config used when the URL is wss:// like.
+	//
+	// If nil a default configuration is used.
+	TLSConfig *tls.Config
+
+	// Network is the network to dial: "tcp", "tcp4" (IPv4 only) or "tcp6" (IPv6 only).
+	//
+	// By default Network is "tcp".
+	Network string
+
+	// LocalAddr is the local address used to dial.
+	LocalAddr net.Addr
+
+	// Interface is the name of the network interface used to dial.
+	//
+	// The first address of the interface matching Network is used as local address.
+	// Interface is ignored if LocalAddr is set.
+	Interface string
+
+	// CloseTimeout, if not zero, is the Conn.CloseTimeout of the connections dialed.
+	// A negative CloseTimeout makes them close without waiting
+	// for the peer to finish the closing handshake.
+	// CloseTimeout takes precedence over Config.CloseTimeout.
+	CloseTimeout time.Duration
+
+	// ConnectTimeout, if not zero, bounds the time to establish
+	// the TCP connection, including the TLS handshake for the wss:// URLs.
+	ConnectTimeout time.Duration
+
+	// HandshakeTimeout, if not zero, bounds the time to send the upgrade request
+	// and read the response, so Dial doesn't block forever
+	// against a server accepting the connection but never responding.
+	// When it expires Dial returns a *TimeoutError.
+	HandshakeTimeout time.Duration
+
+	// Proxy, if not nil, returns the URL of the proxy used to connect
+	// to the websocket url, or an empty string to connect directly.
+	// The HTTP proxies (http://) are used with CONNECT requests
+	// and the SOCKS5 ones as RFC 1928 defines: socks5:// resolves the host
+	// locally sending its IP to the proxy, while socks5h:// sends the host name
+	// to be resolved by the proxy.
+	// The user and password of the proxy URL are used to authenticate.
+	//
+	// ProxyURL returns a Proxy using always the same proxy.
+	Proxy func(url string) (string, error)
+
+	// MaxRedirects is the max number of redirects (301, 302, 303, 307 and 308)
+	// followed when dialing. The redirects to http:// and https:// URLs
+	// are followed using ws:// and wss:// respectively.
+	//
+	// By default (0 or less) the redirects are not followed,
+	// returning an *UpgradeError holding the Location.
+	//
+	// The redirects from wss:// to ws:// fail with ErrInsecureRedirect.
+	// When redirected to another host the Authorization and Cookie headers
+	// and the ones in RedirectStripHeaders are not sent.
+	MaxRedirects int
+
+	// RedirectStripHeaders are the headers of the request holding credentials
+	// (besides Authorization and Cookie) not sent when redirected to another host.
+	RedirectStripHeaders []string
+
+	// Compress offers the permessage-deflate extension (RFC 7692),
+	// so the servers supporting it can compress the messages sent.
+	// The messages read by ReadMessage and the like, Read and WriteTo
+	// (io.Copy) are decompressed, but not the ones read by NextReader
+	// or NextFrame, whose first frame has the RSV1 bit set if compressed.
+	// The messages written are not compressed, as the RFC allows.
+	//
+	// The server is asked for server_no_context_takeover, failing to dial
+	// with ErrInvalidExtension if it doesn't accept it.
+	Compress bool
+
+	// Config holds the settings of the connections dialed.
+	Config Config
+}
+
+// Dial establishes a websocket connection as client.
+//
+// url parameter must follow WebSocket URL format i.e. ws://host:port/path
+func (d *Dialer) Dial(url string) (*Conn, error) {
+	return d.dial(url, nil)
+}
+
+// DialWithHeaders establishes a websocket connection as client sending a personalized request.
+func (d *Dialer) DialWithHeaders(url string, req *fasthttp.Request) (*Conn, error) {
+	return d.dial(url, req)
+}
+
+func (d *Dialer) network() (string, error) {
+	switch d.Network {
+	case "":
+		return "tcp", nil
+	case "tcp", "tcp4", "tcp6":
+		return d.Network, nil
 	}
-	return dial(url, cnf, req)
+	return "", fmt.Errorf("unsupported network: %s", d.Network)
 }
 
-func dial(url string, cnf *tls.Config, req *fasthttp.Request) (conn *Conn, err error) {
+func (d *Dialer) netDialer(network string) (*net.Dialer, error) {
+	nd := &net.Dialer{
+		LocalAddr: d.LocalAddr,
+		Timeout:   d.ConnectTimeout,
+	}
+	if nd.LocalAddr == nil && d.Interface != "" {
+		addr, err := interfaceAddr(d.Interface, network)
+		if err != nil {
+			return nil, err
+		}
+		nd.LocalAddr = addr
+	}
+	return nd, nil
+}
+
+// interfaceAddr returns the first address of the interface name matching network.
+func interfaceAddr(name, network string) (net.Addr, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		ipn, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		isIPv4 := ipn.IP.To4() != nil
+		if (network == "tcp4" && !isIPv4) || (network == "tcp6" && isIPv4) {
+			continue
+		}
+		return &net.TCPAddr{IP: ipn.IP}, nil
+	}
+
+	return nil, fmt.Errorf("interface %s has no %s address", name, network)
+}
+
+func (d *Dialer) dial(url string, req *fasthttp.Request) (*Conn, error) {
+	var stripped *fasthttp.Request
+	defer func() {
+		if stripped != nil {
+			fasthttp.ReleaseRequest(stripped)
+		}
+	}()
+
+	for redirects := 0; ; redirects++ {
+		conn, err := d.dialURL(url, req)
+
+		var uerr *UpgradeError
+		if redirects >= d.MaxRedirects || !errors.As(err, &uerr) ||
+			!isRedirect(uerr.StatusCode) || uerr.Location == "" {
+			return conn, err
+		}
+
+		next := redirectURL(url, uerr.Location)
+		scheme, host := urlHost(url)
+		nextScheme, nextHost := urlHost(next)
+		if scheme == "wss" && nextScheme != "wss" {
+			return nil, ErrInsecureRedirect
+		}
+		if req != nil && stripped == nil && !strings.EqualFold(host, nextHost) {
+			// the request passed must not be modified.
+			stripped = fasthttp.AcquireRequest()
+			req.CopyTo(stripped)
+			stripped.Header.Del("Authorization")
+			stripped.Header.Del("Cookie")
+			for _, h := range d.RedirectStripHeaders {
+				stripped.Header.Del(h)
+			}
+			req = stripped
+		}
+		url = next
+	}
+}
+
+// urlHost returns the scheme and the host of url.
+func urlHost(url string) (string, string) {
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+
+	uri.Update(url)
+	return string(uri.Scheme()), string(uri.Host())
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+		fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectURL returns the websocket URL location (absolute or relative to url) redirects to.
+func redirectURL(url, location string) string {
+	uri := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(uri)
+
+	uri.Update(url)
+	uri.Update(location)
+	switch string(uri.Scheme()) {
+	case "http":
+		uri.SetScheme("ws")
+	case "https":
+		uri.SetScheme("wss")
+	}
+
+	return uri.String()
+}
+
+func (d *Dialer) dialURL(url string, req *fasthttp.Request) (conn *Conn, err error) {
+	network, err := d.network()
+	if err != nil {
+		return nil, err
+	}
+	nd, err := d.netDialer(network)
+	if err != nil {
+		return nil, err
+	}
+
 	uri := fasthttp.AcquireURI()
 	defer fasthttp.ReleaseURI(uri)
 	uri.Update(url)
@@ -134,20 +418,60 @@ func dial(url string, cnf *tls.Config, req *fasthttp.Request) (conn *Conn, err e
 		addr = append(addr, port...)
 	}
 
-	var c net.Conn
+	var proxy string
+	if d.Proxy != nil {
+		proxy, err = d.Proxy(url)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		c   net.Conn
+		cnf *tls.Config
+	)
+	if scheme == "https" {
+		cnf = d.TLSConfig
+		if cnf == nil {
+			cnf = &tls.Config{
+				InsecureSkipVerify: false,
+				MinVersion:         tls.VersionTLS11,
+			}
+		}
+	}
 
-	if scheme == "http" {
-		c, err = net.Dial("tcp", b2s(addr))
-	} else {
-		c, err = tls.Dial("tcp", b2s(addr), cnf)
+	switch {
+	case proxy != "":
+		c, err = d.dialProxy(nd, network, proxy, string(addr), cnf)
+	case cnf == nil:
+		c, err = nd.Dial(network, b2s(addr))
+	default:
+		c, err = tls.DialWithDialer(nd, network, b2s(addr), cnf)
 	}
-	if err == nil {
-		conn, err = client(c, uri.String(), req)
-		if err != nil {
-			c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.HandshakeTimeout > 0 {
+		c.SetDeadline(time.Now().Add(d.HandshakeTimeout))
+	}
+	br, compress, err := upgradeAsClient(c, uri.String(), req, d.Config.ReadBufferSize, d.Compress)
+	if err != nil {
+		c.Close()
+		if terr, ok := err.(interface{ Timeout() bool }); ok && terr.Timeout() && d.HandshakeTimeout > 0 {
+			err = errHandshakeTimeout
 		}
+		return nil, err
 	}
-	return conn, err
+	if d.HandshakeTimeout > 0 {
+		c.SetDeadline(zeroTime)
+	}
+
+	conn = newClientConn(c, br, &d.Config, compress)
+	if d.CloseTimeout != 0 {
+		conn.CloseTimeout = d.CloseTimeout
+	}
+	return conn, nil
 }
 
 func makeRandKey(b []byte) []byte {
@@ -2,8 +2,11 @@ package fastws
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -29,7 +32,7 @@ func TestDial(t *testing.T) {
 			for {
 				_, b, err := conn.ReadMessage(nil)
 				if err != nil {
-					if err == EOF {
+					if errors.Is(err, EOF) {
 						break
 					}
 					panic(err)
@@ -153,7 +156,7 @@ func TestClientConcurrentWrite(t *testing.T) {
 			for {
 				_, b, err := conn.ReadMessage(nil)
 				if err != nil {
-					if err == EOF {
+					if errors.Is(err, EOF) {
 						break
 					}
 					t.Fatal(err)
@@ -198,7 +201,7 @@ func TestClientConcurrentWrite(t *testing.T) {
 			for msg := range ch {
 				_, err := conn.WriteString(msg)
 				if err != nil {
-					if err == EOF {
+					if errors.Is(err, EOF) {
 						break
 					}
 					panic(err)
@@ -245,7 +248,7 @@ func TestConnCloseWhileReading(t *testing.T) {
 				for {
 					_, _, err := conn.ReadMessage(nil)
 					if err != nil {
-						if err == EOF {
+						if errors.Is(err, EOF) {
 							break
 						}
 						panic(err)
@@ -291,3 +294,302 @@ func TestConnCloseWhileReading(t *testing.T) {
 		t.Fatal("timeout")
 	}
 }
+
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestDialerInterface(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			conn.WriteString("Hello")
+		}),
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	d := Dialer{
+		Network:   "tcp4",
+		Interface: loopbackInterface(t),
+	}
+	conn, err := d.Dial("ws://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr := conn.LocalAddr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() || addr.IP.To4() == nil {
+		t.Fatalf("Unexpected local address: %s", addr)
+	}
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s <> Hello", b)
+	}
+}
+
+func TestDialerNetwork(t *testing.T) {
+	d := Dialer{
+		Network: "udp",
+	}
+	if _, err := d.Dial("ws://localhost:8080/"); err == nil {
+		t.Fatal("Expected unsupported network error")
+	}
+
+	d = Dialer{
+		Network:   "tcp6",
+		Interface: "fastws-missing0",
+	}
+	if _, err := d.Dial("ws://localhost:8080/"); err == nil {
+		t.Fatal("Expected missing interface error")
+	}
+}
+
+func TestDialRetryAfter(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set("Retry-After", "30")
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = Client(c, "http://localhost:9843/")
+	if !errors.Is(err, ErrCannotUpgrade) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	d, ok := RetryAfter(err)
+	if !ok || d != time.Second*30 {
+		t.Fatalf("Unexpected retry after: %s <> 30s", d)
+	}
+	var uerr *UpgradeError
+	if !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		v string
+		d time.Duration
+	}{
+		{"", 0},
+		{"120", time.Minute * 2},
+		{"Wed, 01 Jan 2020 00:01:00 GMT", time.Minute},
+		{"Tue, 31 Dec 2019 00:00:00 GMT", 0},
+		{"soon", 0},
+	} {
+		if d := parseRetryAfter([]byte(tc.v), now); d != tc.d {
+			t.Fatalf("%s: %s <> %s", tc.v, d, tc.d)
+		}
+	}
+}
+
+func TestDialerHandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	// accepting the connections without responding.
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	d := Dialer{
+		ConnectTimeout:   time.Second,
+		HandshakeTimeout: time.Millisecond * 50,
+	}
+	_, err = d.Dial("ws://" + ln.Addr().String())
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("Expected timeout error: %v", err)
+	}
+}
+
+func TestDialerRedirects(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	upgrade := Upgrade(func(conn *Conn) {
+		conn.WriteString("Hello")
+	})
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/old" {
+				ctx.Redirect("/ws", fasthttp.StatusTemporaryRedirect)
+				return
+			}
+			upgrade(ctx)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	url := "ws://" + ln.Addr().String() + "/old"
+
+	var d Dialer
+	_, err = d.Dial(url)
+	var uerr *UpgradeError
+	if !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusTemporaryRedirect {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(uerr.Location, "/ws") {
+		t.Fatalf("Unexpected location: %s", uerr.Location)
+	}
+
+	d.MaxRedirects = -1
+	_, err = d.Dial(url)
+	if !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusTemporaryRedirect {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	d.MaxRedirects = 1
+	conn, err := d.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s <> Hello", b)
+	}
+}
+
+func TestDialerRedirectOtherHost(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	ln2, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		ln.Close()
+		t.Skip(err)
+	}
+	defer ln.Close()
+	defer ln2.Close()
+
+	headers := make(chan [4]string, 2)
+	upgrade := Upgrade(func(conn *Conn) {
+		conn.WriteString("Hello")
+	})
+	handler := func(ctx *fasthttp.RequestCtx) {
+		headers <- [4]string{
+			string(ctx.Request.Header.Peek("Authorization")),
+			string(ctx.Request.Header.Cookie("session")),
+			string(ctx.Request.Header.Peek("X-Token")),
+			string(ctx.Request.Header.Peek("X-Agent")),
+		}
+		if string(ctx.Path()) == "/old" {
+			ctx.Redirect("ws://"+ln2.Addr().String()+"/ws", fasthttp.StatusFound)
+			return
+		}
+		upgrade(ctx)
+	}
+	go fasthttp.Serve(ln, handler)
+	go fasthttp.Serve(ln2, handler)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.SetCookie("session", "secret")
+	req.Header.Set("X-Token", "secret")
+	req.Header.Set("X-Agent", "test")
+
+	d := Dialer{
+		MaxRedirects:         1,
+		RedirectStripHeaders: []string{"X-Token"},
+	}
+	conn, err := d.DialWithHeaders("ws://"+ln.Addr().String()+"/old", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if h := <-headers; h != [4]string{"Bearer secret", "secret", "secret", "test"} {
+		t.Fatalf("Unexpected headers: %q", h)
+	}
+	if h := <-headers; h != [4]string{"", "", "", "test"} {
+		t.Fatalf("Unexpected headers after the redirect: %q", h)
+	}
+	if string(req.Header.Peek("Authorization")) != "Bearer secret" {
+		t.Fatal("The request passed has been modified")
+	}
+}
+
+func TestDialerInsecureRedirect(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	ln = tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+	})
+	defer ln.Close()
+
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.Redirect("ws://"+ln.Addr().String()+"/ws", fasthttp.StatusFound)
+	})
+
+	d := Dialer{
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		MaxRedirects: 1,
+	}
+	_, err = d.Dial("wss://" + ln.Addr().String() + "/old")
+	if err != ErrInsecureRedirect {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrInsecureRedirect)
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	cases := [][3]string{
+		{"ws://localhost/old", "/new", "ws://localhost/new"},
+		{"wss://localhost/old", "https://example.com/ws", "wss://example.com/ws"},
+		{"ws://localhost/old", "http://example.com:8080/ws", "ws://example.com:8080/ws"},
+	}
+	for _, c := range cases {
+		if url := redirectURL(c[0], c[1]); url != c[2] {
+			t.Fatalf("Unexpected URL: %s <> %s", url, c[2])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package fastws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock provides the current time and the timers used by a Conn
+// for the read timeouts and deadlines, the keepalive and the close handshake.
+//
+// A Clock controlled by the tests makes the timeout logic deterministic,
+// without sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a Timer sending the time on its channel after d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, as time.Timer.Stop does.
+	Stop() bool
+}
+
+// RealClock is the Clock using the time package. It's the default Clock of Conn.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// clockHolder allows storing any Clock in an atomic.Value.
+type clockHolder struct {
+	Clock
+}
+
+// SetClock sets the Clock used by conn. If clock is nil RealClock is used.
+// The keepalive keeps using the Clock set when it was enabled.
+//
+// The write deadlines are applied by the underlying connection,
+// so they always use the real time.
+func (conn *Conn) SetClock(clock Clock) {
+	if clock == nil {
+		clock = RealClock
+	}
+	conn.clock.Store(clockHolder{clock})
+	atomic.StoreInt64(&conn.stats.lastRead, clock.Now().UnixNano())
+}
+
+func (conn *Conn) getClock() Clock {
+	return conn.clock.Load().(clockHolder).Clock
+}
+
+func (conn *Conn) now() time.Time {
+	return conn.getClock().Now()
+}
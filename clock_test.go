@@ -0,0 +1,156 @@
+package fastws
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when calling Advance.
+type fakeClock struct {
+	lck    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Now(),
+	}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lck.Lock()
+	defer fc.lck.Unlock()
+
+	return fc.now
+}
+
+func (fc *fakeClock) NewTimer(d time.Duration) Timer {
+	fc.lck.Lock()
+	defer fc.lck.Unlock()
+
+	t := &fakeTimer{
+		clock: fc,
+		when:  fc.now.Add(d),
+		c:     make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- fc.now
+	} else {
+		fc.timers = append(fc.timers, t)
+	}
+	return t
+}
+
+// Advance moves the time forward by d, firing the expired timers.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lck.Lock()
+	defer fc.lck.Unlock()
+
+	fc.now = fc.now.Add(d)
+
+	timers := fc.timers[:0]
+	for _, t := range fc.timers {
+		if t.when.After(fc.now) {
+			timers = append(timers, t)
+		} else {
+			t.c <- fc.now
+		}
+	}
+	fc.timers = timers
+}
+
+// waitTimers waits until n timers are waiting to fire.
+func (fc *fakeClock) waitTimers(t *testing.T, n int) {
+	for i := 0; i < 1000; i++ {
+		fc.lck.Lock()
+		waiting := len(fc.timers)
+		fc.lck.Unlock()
+
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d timers", n)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	fc := t.clock
+	fc.lck.Lock()
+	defer fc.lck.Unlock()
+
+	for i := range fc.timers {
+		if fc.timers[i] == t {
+			fc.timers = append(fc.timers[:i], fc.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestClockReadTimeout(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	clock := newFakeClock()
+	server.SetClock(clock)
+	server.ReadTimeout = time.Hour
+
+	errch := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage(nil)
+		errch <- err
+	}()
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-errch:
+		t.Fatalf("Unexpected error: %v", err)
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	clock.Advance(time.Hour)
+	if err := <-errch; err != errReadTimeout {
+		t.Fatalf("Unexpected error: %v <> %v", err, errReadTimeout)
+	}
+}
+
+func TestClockKeepAliveTimeout(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	clock := newFakeClock()
+	server.SetClock(clock)
+	server.ReadTimeout = 0
+
+	// nobody reads from client, so the PINGs are not answered.
+	server.EnableKeepAlive(time.Minute, time.Minute*2)
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute) // sends the PING
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute * 2) // the PONG times out
+
+	_, _, err := server.ReadMessage(nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err := server.readError(); err != ErrKeepAliveTimeout {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrKeepAliveTimeout)
+	}
+}
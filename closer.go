@@ -0,0 +1,149 @@
+package fastws
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCloseRate is the default number of close handshakes started per second by a Closer.
+const DefaultCloseRate = 1000
+
+// ErrCloseDeadlineExceeded is returned by Closer.Close when the deadline
+// expires before completing all the close handshakes.
+var ErrCloseDeadlineExceeded = errors.New("close deadline exceeded")
+
+// Closer closes many connections at once (shutdown, ban wave)
+// pacing the close handshakes, so the burst of close frames written
+// doesn't spike the latency of the surviving connections.
+type Closer struct {
+	// Rate is the max number of close handshakes started per second.
+	//
+	// By default Rate is DefaultCloseRate.
+	Rate int
+
+	// Deadline is the max time spent closing the connections.
+	// When it expires the remaining connections are closed without
+	// waiting for the close handshake.
+	//
+	// 0 means no deadline.
+	Deadline time.Duration
+
+	// Status is the close status sent to the peers.
+	Status StatusCode
+
+	// Reason is the close reason sent to the peers.
+	// It's checked as Conn.CloseWithCode does.
+	Reason string
+}
+
+// Close closes conns returning when all of them are closed.
+//
+// The close handshakes are started in order at Rate per second
+// and run concurrently. The connections already closed are skipped.
+// If the deadline expires ErrCloseDeadlineExceeded is returned
+// after closing the remaining connections.
+func (c *Closer) Close(conns []*Conn) error {
+	var reason []byte
+	if c.Reason != "" {
+		reason = []byte(c.Reason)
+	}
+	if err := checkCloseReason(reason); err != nil {
+		return err
+	}
+
+	rate := c.Rate
+	if rate <= 0 {
+		rate = DefaultCloseRate
+	}
+	interval := time.Second / time.Duration(rate)
+
+	var expire <-chan time.Time
+	if c.Deadline > 0 {
+		timer := time.NewTimer(c.Deadline)
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	var wg sync.WaitGroup
+	abort := make(chan struct{})
+
+	expired := false
+	start := time.Now()
+	i := 0
+loop:
+	for ; i < len(conns); i++ {
+		// sleeping is skipped when falling behind, so high rates are written in bursts.
+		if d := time.Until(start.Add(interval * time.Duration(i))); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-expire:
+				timer.Stop()
+				expired = true
+				break loop
+			}
+		}
+
+		wg.Add(1)
+		go c.close(conns[i], reason, abort, &wg)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if !expired {
+		select {
+		case <-done:
+		case <-expire:
+			expired = true
+		}
+	}
+
+	if !expired {
+		return nil
+	}
+
+	close(abort)
+	// the remaining connections are closed without handshake.
+	for _, conn := range conns[i:] {
+		conn.mustClose(false)
+	}
+	<-done
+
+	return ErrCloseDeadlineExceeded
+}
+
+// close closes conn waiting for the peer to reply to the close frame
+// unless abort is closed first.
+func (c *Closer) close(conn *Conn, reason []byte, abort <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if conn.isClosed() {
+		return
+	}
+
+	closed := make(chan struct{})
+	aborter := make(chan struct{})
+	go func() {
+		defer close(aborter)
+
+		select {
+		case <-abort:
+			// unblocks the writes and the wait of mustClose.
+			atomic.StoreUint32(&conn.closing, 1)
+			conn.getTransport().Close()
+		case <-closed:
+		}
+	}()
+
+	conn.sendClose(c.Status, reason)
+	conn.mustClose(true)
+	close(closed)
+	// conn can't be used once closed, as it might be released.
+	<-aborter
+}
@@ -0,0 +1,87 @@
+package fastws
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloserRate(t *testing.T) {
+	var conns []*Conn
+	for i := 0; i < 5; i++ {
+		server, client := pipeConns()
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(nil); err != nil {
+					return
+				}
+			}
+		}()
+		conns = append(conns, server)
+	}
+
+	c := &Closer{
+		Rate:   50,
+		Status: StatusGoAway,
+	}
+
+	start := time.Now()
+	if err := c.Close(conns); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Millisecond*80 {
+		t.Fatalf("The closes were not paced: %s", d)
+	}
+	for _, conn := range conns {
+		if !conn.isClosed() {
+			t.Fatal("The connection must be closed")
+		}
+	}
+}
+
+func TestCloserDeadline(t *testing.T) {
+	var (
+		conns []*Conn
+		peers []net.Conn
+	)
+	for i := 0; i < 3; i++ {
+		server, peer := blockedConn()
+		conns = append(conns, server)
+		peers = append(peers, peer)
+	}
+	defer func() {
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+
+	c := &Closer{
+		Rate:     10,
+		Deadline: time.Millisecond * 50,
+	}
+
+	// nobody reads the peers, so the handshakes never complete.
+	start := time.Now()
+	err := c.Close(conns)
+	if err != ErrCloseDeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrCloseDeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("The deadline was not applied: %s", d)
+	}
+	for _, conn := range conns {
+		if !conn.isClosed() {
+			t.Fatal("The connection must be closed")
+		}
+	}
+}
+
+func TestCloserInvalidReason(t *testing.T) {
+	c := &Closer{
+		Reason: string([]byte{0xff}),
+	}
+	if err := c.Close(nil); !errors.Is(err, ErrInvalidCloseReason) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrInvalidCloseReason)
+	}
+}
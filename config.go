@@ -0,0 +1,145 @@
+package fastws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Config holds the settings of the connections created by the Upgrader,
+// the NetUpgrader and the Dialer.
+//
+// The settings are applied when the connection is created,
+// before starting to read, so they apply to the first frame too.
+// The zero values keep the Conn defaults.
+//
+// Validate should be called when starting, so the misconfigurations
+// are not found when the connections fail.
+type Config struct {
+	// Mode is the Conn.Mode used by Write.
+	Mode Mode
+
+	// ReadTimeout is the Conn.ReadTimeout. A negative ReadTimeout disables it.
+	ReadTimeout time.Duration
+	// WriteTimeout is the Conn.WriteTimeout. A negative WriteTimeout disables it.
+	WriteTimeout time.Duration
+	// CloseTimeout is the Conn.CloseTimeout. A negative CloseTimeout
+	// closes the connections without waiting for the peer.
+	CloseTimeout time.Duration
+	// MaxWriteTimeouts is the Conn.MaxWriteTimeouts.
+	MaxWriteTimeouts int
+
+	// MaxPayloadSize is the Conn.MaxPayloadSize.
+	MaxPayloadSize uint64
+	// MaxMessageSize is the Conn.MaxMessageSize.
+	MaxMessageSize uint64
+
+	// ReadBufferSize is the size of the read buffer.
+	// By default the bufio package default size is used.
+	ReadBufferSize int
+	// WriteBufferSize is the size of the write buffer.
+	// By default the bufio package default size is used.
+	WriteBufferSize int
+}
+
+// apply sets the settings of cfg to conn. The buffers are created by reset.
+func (cfg *Config) apply(conn *Conn) {
+	conn.Mode = cfg.Mode
+	if cfg.ReadTimeout != 0 {
+		conn.ReadTimeout = positiveDuration(cfg.ReadTimeout)
+	}
+	if cfg.WriteTimeout != 0 {
+		conn.WriteTimeout = positiveDuration(cfg.WriteTimeout)
+	}
+	if cfg.CloseTimeout != 0 {
+		conn.CloseTimeout = cfg.CloseTimeout
+	}
+	if cfg.MaxWriteTimeouts > 0 {
+		conn.MaxWriteTimeouts = cfg.MaxWriteTimeouts
+	}
+	if cfg.MaxPayloadSize > 0 {
+		conn.MaxPayloadSize = cfg.MaxPayloadSize
+	}
+	if cfg.MaxMessageSize > 0 {
+		conn.MaxMessageSize = cfg.MaxMessageSize
+	}
+}
+
+// ErrInvalidConfig is matched by the *ConfigError returned by Config.Validate.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ConfigError is returned by Config.Validate when a setting
+// is not valid or contradicts other setting.
+type ConfigError struct {
+	// Field is the name of the setting.
+	Field string
+	// Reason describes why the setting is not valid.
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrInvalidConfig, e.Field, e.Reason)
+}
+
+// Unwrap returns ErrInvalidConfig.
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Validate checks the settings of cfg, returning a *ConfigError
+// describing the first setting not valid.
+//
+// The settings left to their defaults are checked using the default values,
+// i.e.: a MaxPayloadSize bigger than DefaultMessageSize is not valid
+// unless MaxMessageSize is set accordingly.
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.Mode != ModeText && cfg.Mode != ModeBinary:
+		return &ConfigError{"Mode", "must be ModeText or ModeBinary"}
+	case cfg.ReadBufferSize < 0:
+		return &ConfigError{"ReadBufferSize", "must not be negative"}
+	case cfg.WriteBufferSize < 0:
+		return &ConfigError{"WriteBufferSize", "must not be negative"}
+	case cfg.MaxWriteTimeouts < 0:
+		return &ConfigError{"MaxWriteTimeouts", "must not be negative"}
+	case cfg.MaxWriteTimeouts > 0 && cfg.WriteTimeout < 0:
+		return &ConfigError{"MaxWriteTimeouts", "requires a WriteTimeout"}
+	}
+
+	payload, message := cfg.MaxPayloadSize, cfg.MaxMessageSize
+	if payload == 0 {
+		payload = DefaultPayloadSize
+	}
+	if message == 0 {
+		message = DefaultMessageSize
+	}
+	if payload > message {
+		return &ConfigError{"MaxPayloadSize", fmt.Sprintf(
+			"(%d) must not be bigger than MaxMessageSize (%d)", payload, message)}
+	}
+
+	return nil
+}
+
+func positiveDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func newBufioReader(r io.Reader, size int) *bufio.Reader {
+	if size <= 0 {
+		return bufio.NewReader(r)
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+func newBufioWriter(w io.Writer, size int) *bufio.Writer {
+	if size <= 0 {
+		return bufio.NewWriter(w)
+	}
+	return bufio.NewWriterSize(w, size)
+}
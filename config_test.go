@@ -0,0 +1,127 @@
+package fastws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestUpgraderConfig(t *testing.T) {
+	errch := make(chan error, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Config: Config{
+			Mode:           ModeBinary,
+			ReadTimeout:    -1,
+			WriteTimeout:   time.Second,
+			MaxPayloadSize: 16,
+			ReadBufferSize: 8192,
+		},
+		Handler: func(conn *Conn) {
+			switch {
+			case conn.Mode != ModeBinary:
+				errch <- errors.New("unexpected mode")
+			case conn.ReadTimeout != 0 || conn.WriteTimeout != time.Second:
+				errch <- errors.New("unexpected timeouts")
+			case conn.BufferStats().ReadBufferSize != 8192:
+				errch <- errors.New("unexpected read buffer size")
+			default:
+				// the first frame is read using the limit set.
+				_, _, err := conn.ReadMessage(nil)
+				errch <- err
+			}
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+
+	conn.WriteMessage(ModeBinary, bytes.Repeat([]byte("a"), 32))
+
+	if err := <-errch; !errors.Is(err, ErrTooBig) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := []Config{
+		{},
+		{MaxPayloadSize: DefaultMessageSize},
+		{MaxPayloadSize: 1 << 30, MaxMessageSize: 1 << 30},
+		{WriteTimeout: time.Second, MaxWriteTimeouts: 3},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", cfg, err)
+		}
+	}
+
+	invalid := []struct {
+		cfg   Config
+		field string
+	}{
+		{Config{Mode: 2}, "Mode"},
+		{Config{ReadBufferSize: -1}, "ReadBufferSize"},
+		{Config{WriteBufferSize: -1}, "WriteBufferSize"},
+		{Config{WriteTimeout: -1, MaxWriteTimeouts: 3}, "MaxWriteTimeouts"},
+		{Config{MaxPayloadSize: 1024, MaxMessageSize: 512}, "MaxPayloadSize"},
+		{Config{MaxPayloadSize: DefaultMessageSize + 1}, "MaxPayloadSize"},
+	}
+	for _, e := range invalid {
+		err := e.cfg.Validate()
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) || !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("Unexpected error for %+v: %v", e.cfg, err)
+		}
+		if cerr.Field != e.field {
+			t.Fatalf("Unexpected field: %s <> %s", cerr.Field, e.field)
+		}
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Handler: func(conn *Conn) {
+			conn.ReadMessage(nil)
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Mode:           ModeBinary,
+		CloseTimeout:   -1,
+		ReadBufferSize: 16384,
+	}
+	conn, err := client(c, "http://localhost/", nil, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.Mode != ModeBinary || conn.CloseTimeout != -1 {
+		t.Fatalf("Unexpected settings: %v %s", conn.Mode, conn.CloseTimeout)
+	}
+	if size := conn.BufferStats().ReadBufferSize; size != 16384 {
+		t.Fatalf("Unexpected read buffer size: %d", size)
+	}
+}
@@ -2,13 +2,17 @@ package fastws
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 // Mode is the mode in which the bytes are sended.
@@ -27,6 +31,8 @@ var (
 	connPool sync.Pool
 )
 
+var _ net.Conn = (*Conn)(nil)
+
 var (
 	zeroTime        = time.Time{}
 	defaultDeadline = time.Second * 8
@@ -34,23 +40,134 @@ var (
 
 // Conn represents websocket connection handler.
 //
-// This handler is compatible with io.Reader, io.ReaderFrom, io.Writer, io.WriterTo
+// This handler implements net.Conn, so it can be used as a byte stream
+// (see Read and Write) for tunneling other protocols.
 type Conn struct {
-	c      net.Conn
+	// stats must be the first field to guarantee the 64-bit alignment of the atomic counters.
+	stats connStats
+	// readDeadline and writeDeadline are the deadlines (in Unix nanoseconds)
+	// set using SetReadDeadline and SetWriteDeadline. 0 means no deadline.
+	readDeadline  int64
+	writeDeadline int64
+
+	c      transport
 	bf     *bufio.ReadWriter
 	closed bool
-	wg     sync.WaitGroup
+	// closing is set (atomically) when the transport is being closed by conn.
+	closing uint32
+	wg      sync.WaitGroup
+
+	// readErr is the error which closed the connection while reading (if any).
+	readErr error
+	// loopErr is the error which terminated the readLoop,
+	// unless the connection was closed by conn.
+	loopErr error
 
 	framer chan *Frame
 	errch  chan error
 
+	// readDone is closed when the readLoop exits.
+	readDone chan struct{}
+	// closeDone is closed when the close of conn finishes,
+	// so the other callers of Close wait for it before conn can be released.
+	closeDone chan struct{}
+	// pausing is set (atomically) when the readLoop must pause
+	// after the next read error, signaling paused and waiting for resume.
+	pausing uint32
+	paused  chan struct{}
+	resume  chan struct{}
+
 	server   bool
 	compress bool
 
 	lck sync.Mutex
-
+	// cLck protects c and bf, which StartTLS replaces, from the users
+	// not holding lck (the writers hold lck, which StartTLS holds as well).
+	cLck sync.RWMutex
+	// msgLck is held while a data message is being written,
+	// so only control frames can be written in between its fragments.
+	msgLck sync.Mutex
+	// msgQueue and writeQueue order the writers waiting for msgLck and lck
+	// when OrderedWrites is set.
+	msgQueue   ticketLock
+	writeQueue ticketLock
+	// writers is the number of WriteFrame callers holding or waiting for lck.
+	writers int32
+	// writeTimeouts is the number of consecutive write timeouts,
+	// and peerDead is set when they reach MaxWriteTimeouts (both atomic).
+	writeTimeouts int32
+	peerDead      uint32
+
+	// singleWriter is set (atomically) using SetSingleWriter.
+	singleWriter uint32
+	// manualFlush is set when the data frames are not flushed after writing them.
+	manualFlush bool
+
+	// userLck protects the user values, which can be used concurrently.
+	userLck    sync.RWMutex
 	userValues map[string]interface{}
 
+	hints ClientHints
+	req   *Request
+
+	dedup *Deduplicator
+
+	maskSource func(b []byte)
+
+	pingHandler  func(payload []byte) error
+	pongHandler  func(payload []byte) error
+	closeHandler func(status StatusCode, reason []byte) error
+	// opcodeHandlers are the handlers of the reserved data opcodes (0x3-0x7).
+	opcodeHandlers [numReservedOpcodes]func(fr *Frame) error
+
+	// onClose is the hook set using OnClose, protected by lck.
+	onClose func(err error)
+	// terminated is set when the termination has been notified to onClose.
+	terminated bool
+	// closeResult is how the connection was closed, protected by lck.
+	closeResult CloseResult
+
+	// pingLck protects the pings waiting for their PONG.
+	pingLck sync.Mutex
+	pings   []*pendingPing
+	pingSeq uint64
+	// sentPings are the payloads of the PINGs sent waiting for the PONG.
+	sentPings [][]byte
+
+	keepAliveStop chan struct{}
+
+	// clock holds the Clock (as a clockHolder) used by conn.
+	clock atomic.Value
+	// metrics holds the Metrics (as a metricsHolder) of conn.
+	metrics atomic.Value
+	// writtenSize is the size of the message being written frame by frame,
+	// reported to the Metrics when finished. It's guarded by lck.
+	writtenSize int
+	// logger holds the Logger (as a loggerHolder) of conn.
+	logger atomic.Value
+
+	// tapLck protects the tap writer. tapDir is read atomically
+	// to skip the lock when not tapping.
+	tapLck sync.Mutex
+	tapDir uint32
+	tapW   io.Writer
+
+	// labelLck protects the labels read by the LabelCollector.
+	labelLck sync.Mutex
+	labels   []Label
+
+	// readers is the number of reads in progress.
+	readers int32
+	// readLck is held (read) by the reads in progress,
+	// so conn is not released until they return.
+	readLck sync.RWMutex
+	// poisoned is set when the frames of a message have been taken by many readers.
+	poisoned int32
+
+	// rb holds the remainder of the message being read by Read.
+	rb   []byte
+	rpos int
+
 	// Mode indicates Write default mode.
 	Mode Mode
 
@@ -60,77 +177,309 @@ type Conn struct {
 	// WriteTimeout ...
 	WriteTimeout time.Duration
 
+	// MaxWriteTimeouts is the number of consecutive write timeouts after which
+	// the peer is considered dead (not reading) and the connection is closed,
+	// so the writers waiting to write fail right away (with EOF) instead of
+	// waiting for the WriteTimeout every one. The error closing the connection
+	// is ErrPeerDead. The writes interrupted by a context are not counted.
+	//
+	// By default MaxWriteTimeouts is 0 (disabled).
+	MaxWriteTimeouts int
+
+	// CloseTimeout is the time Close waits for the peer to reply
+	// to the close frame, reading (and discarding) the frames received meanwhile.
+	// A negative CloseTimeout closes the connection right after sending
+	// the close frame, without waiting for the reply (fast close).
+	// See CloseResult.
+	//
+	// By default CloseTimeout is DefaultCloseTimeout.
+	CloseTimeout time.Duration
+
 	// MaxPayloadSize prevents huge memory allocation.
 	//
 	// By default MaxPayloadSize is DefaultPayloadSize.
 	MaxPayloadSize uint64
+
+	// MaxMessageSize limits the size of the messages once reassembled.
+	// 0 means no limit.
+	//
+	// By default MaxMessageSize is DefaultMessageSize.
+	MaxMessageSize uint64
+
+	// MaxFragments limits the number of frames a message can be fragmented into.
+	// 0 means no limit.
+	MaxFragments int
+
+	// Strict enables all the RFC 6455 checks on the received frames
+	// (see ValidateFrame): masking, reserved opcodes and RSV bits, control
+	// frame limits, close status codes and UTF-8 encoding of text messages
+	// and close reasons.
+	// The connection is closed with the corresponding status if any check fails.
+	// The UTF-8 encoding of the messages read using NextReader is not checked.
+	//
+	// Strict is disabled by default, as the checks cost some speed.
+	Strict bool
+
+	// UnsolicitedPong is the policy applied to the unsolicited PONGs.
+	//
+	// By default UnsolicitedPong is PongSurface.
+	UnsolicitedPong PongPolicy
+
+	// OrderedWrites makes the concurrent writers write in the order
+	// they called the write functions (strict FIFO).
+	//
+	// The writes always keep these guarantees:
+	//   - Frame atomicity: a frame is written whole, never interleaved with other frames.
+	//   - Message atomicity: no data frame is written in between the fragments
+	//     of a message written using NextWriter (control frames can be).
+	//   - Per-writer FIFO: the messages of a goroutine are sent in the order
+	//     they are written, as the write functions return once written.
+	//
+	// Between goroutines the messages are sent in the order the write locks
+	// are acquired, which is mostly FIFO: a writer arriving when the lock
+	// is released can take it before the writers waiting for it
+	// (see sync.Mutex). When OrderedWrites is set the locks are handed off
+	// in arrival order, at the cost of waking up the waiters on every handoff.
+	//
+	// OrderedWrites must not be changed while writing.
+	OrderedWrites bool
+
+	// Debug panics when misusing the connection instead of returning
+	// an error, i.e.: reading fragmented messages from many goroutines
+	// (see ErrConcurrentRead).
+	Debug bool
 }
 
 // UserValue returns the key associated value.
+//
+// The user values can be used concurrently.
 func (conn *Conn) UserValue(key string) interface{} {
-	return conn.userValues[key]
+	conn.userLck.RLock()
+	v := conn.userValues[key]
+	conn.userLck.RUnlock()
+
+	return v
+}
+
+// UserValueBytes returns the key associated value.
+func (conn *Conn) UserValueBytes(key []byte) interface{} {
+	conn.userLck.RLock()
+	v := conn.userValues[string(key)]
+	conn.userLck.RUnlock()
+
+	return v
 }
 
 // SetUserValue assigns a key to the given value
 func (conn *Conn) SetUserValue(key string, value interface{}) {
+	conn.userLck.Lock()
 	conn.userValues[key] = value
+	conn.userLck.Unlock()
+}
+
+// SetUserValueBytes assigns a key to the given value
+func (conn *Conn) SetUserValueBytes(key []byte, value interface{}) {
+	conn.userLck.Lock()
+	conn.userValues[string(key)] = value
+	conn.userLck.Unlock()
+}
+
+// DeleteUserValue removes the value associated to key.
+func (conn *Conn) DeleteUserValue(key string) {
+	conn.userLck.Lock()
+	delete(conn.userValues, key)
+	conn.userLck.Unlock()
+}
+
+// DeleteUserValueBytes removes the value associated to key.
+func (conn *Conn) DeleteUserValueBytes(key []byte) {
+	conn.userLck.Lock()
+	delete(conn.userValues, string(key))
+	conn.userLck.Unlock()
+}
+
+// VisitUserValues calls visitor for every user value, in no particular order.
+//
+// visitor must not set nor delete user values.
+func (conn *Conn) VisitUserValues(visitor func(key string, value interface{})) {
+	conn.userLck.RLock()
+	defer conn.userLck.RUnlock()
+
+	for k, v := range conn.userValues {
+		visitor(k, v)
+	}
 }
 
 // LocalAddr returns local address.
+//
+// LocalAddr returns nil if the underlying transport has no address.
 func (conn *Conn) LocalAddr() net.Addr {
-	return conn.c.LocalAddr()
+	if a, ok := conn.getTransport().(addresser); ok {
+		return a.LocalAddr()
+	}
+	return nil
 }
 
 // RemoteAddr returns peer remote address.
+//
+// RemoteAddr returns nil if the underlying transport has no address.
 func (conn *Conn) RemoteAddr() net.Addr {
-	return conn.c.RemoteAddr()
+	if a, ok := conn.getTransport().(addresser); ok {
+		return a.RemoteAddr()
+	}
+	return nil
+}
+
+// NetConn returns the underlying network connection, so the TLS state
+// can be read (after StartTLS it's a *tls.Conn) or the socket options tuned.
+//
+// Reading from or writing to the returned connection corrupts the framing,
+// as the frames are read by the readLoop and the writes are buffered.
+//
+// NetConn returns nil if the underlying transport is not a net.Conn.
+func (conn *Conn) NetConn() net.Conn {
+	c, _ := conn.getTransport().(net.Conn)
+	return c
 }
 
-func acquireConn(c net.Conn) (conn *Conn) {
+// getTransport returns the transport of conn, which StartTLS can replace.
+func (conn *Conn) getTransport() transport {
+	conn.cLck.RLock()
+	defer conn.cLck.RUnlock()
+
+	return conn.c
+}
+
+func acquireConn(c transport) (conn *Conn) {
+	return acquireConnReader(c, nil)
+}
+
+// acquireConnReader acquires a Conn reading from br, if not nil,
+// instead of creating a new reader from c.
+func acquireConnReader(c transport, br *bufio.Reader) (conn *Conn) {
+	return acquireConnConfig(c, br, nil)
+}
+
+// acquireConnConfig acquires a Conn as acquireConnReader does,
+// applying cfg (if not nil) before starting to read.
+func acquireConnConfig(c transport, br *bufio.Reader, cfg *Config) (conn *Conn) {
 	ci := connPool.Get()
 	if ci != nil {
 		conn = ci.(*Conn)
 	} else {
 		conn = &Conn{}
 	}
-	conn.Reset(c)
+	conn.reset(c, br, cfg)
+	if hooks := loadPoolHooks(); hooks != nil && hooks.AcquireConn != nil {
+		hooks.AcquireConn(conn)
+	}
 	return conn
 }
 
 func releaseConn(conn *Conn) {
+	// waits for the reads in progress, which return once conn is closed.
+	conn.readLck.Lock()
+	conn.readLck.Unlock()
+
+	if hooks := loadPoolHooks(); hooks != nil && hooks.ReleaseConn != nil {
+		hooks.ReleaseConn(conn)
+	}
 	connPool.Put(conn)
 }
 
 // DefaultPayloadSize defines the default payload size (when none was defined).
 const DefaultPayloadSize = 1 << 20
 
+// DefaultMessageSize defines the default max size of a reassembled message.
+const DefaultMessageSize = 1 << 24
+
 // Reset resets conn values setting c as default connection endpoint.
 func (conn *Conn) Reset(c net.Conn) {
+	conn.reset(c, nil, nil)
+}
+
+func (conn *Conn) reset(c transport, br *bufio.Reader, cfg *Config) {
 	conn.framer = make(chan *Frame, 128)
 	conn.errch = make(chan error, 128)
+	conn.readDone = make(chan struct{})
+	conn.closeDone = make(chan struct{})
+	conn.pausing = 0
+	conn.paused = make(chan struct{})
+	conn.resume = make(chan struct{})
 	conn.ReadTimeout = defaultDeadline
 	conn.WriteTimeout = defaultDeadline
+	conn.CloseTimeout = DefaultCloseTimeout
 	conn.MaxPayloadSize = DefaultPayloadSize
+	conn.MaxMessageSize = DefaultMessageSize
+	conn.MaxFragments = 0
+	conn.Strict = false
+	conn.UnsolicitedPong = PongSurface
 	conn.compress = false
 	conn.server = false
+	atomic.StoreUint32(&conn.singleWriter, 0)
+	conn.manualFlush = false
+	conn.tapDir = 0
+	conn.tapW = nil
+	conn.writers = 0
+	conn.writeTimeouts = 0
+	conn.peerDead = 0
+	conn.MaxWriteTimeouts = 0
+	conn.clock.Store(clockHolder{RealClock})
+	conn.metrics.Store(metricsHolder{})
+	conn.writtenSize = 0
+	conn.logger.Store(loggerHolder{})
+	conn.stats = connStats{
+		lastRead: time.Now().UnixNano(),
+	}
+	conn.keepAliveStop = nil
+	conn.readDeadline = 0
+	conn.writeDeadline = 0
+	conn.dedup = nil
+	conn.maskSource = nil
+	conn.pingHandler = nil
+	conn.pongHandler = nil
+	conn.closeHandler = nil
+	conn.opcodeHandlers = [numReservedOpcodes]func(fr *Frame) error{}
+	conn.onClose = nil
+	conn.terminated = false
+	conn.closeResult = CloseNotClosed
+	conn.pings = nil
+	conn.pingSeq = 0
+	conn.sentPings = conn.sentPings[:0]
+	conn.rb = conn.rb[:0]
+	conn.rpos = 0
+	atomic.StoreInt32(&conn.readers, 0)
+	atomic.StoreInt32(&conn.poisoned, 0)
+	conn.Debug = false
+	conn.OrderedWrites = false
 	conn.userValues = make(map[string]interface{})
+	conn.labels = conn.labels[:0]
+	conn.hints = ClientHints{}
+	conn.req = nil
 	conn.c = c
-	{
-		cr := c.(io.Reader)
-		br, ok := cr.(*bufio.Reader)
-		if !ok {
-			br = bufio.NewReader(c)
-		}
-		conn.bf = bufio.NewReadWriter(br, bufio.NewWriter(c))
+
+	var readSize, writeSize int
+	if cfg != nil {
+		cfg.apply(conn)
+		readSize, writeSize = cfg.ReadBufferSize, cfg.WriteBufferSize
 	}
+	if br == nil {
+		br = newBufioReader(c, readSize)
+	}
+	conn.bf = bufio.NewReadWriter(br, newBufioWriter(c, writeSize))
 	conn.closed = false
+	conn.closing = 0
+	conn.readErr = nil
+	conn.loopErr = nil
 	conn.wg.Add(1)
 	go conn.readLoop()
 }
 
 func (conn *Conn) readLoop() {
 	defer conn.wg.Done()
+	defer close(conn.readDone)
 	defer close(conn.framer)
+	defer conn.terminate()
 
 	for {
 		fr := AcquireFrame()
@@ -138,7 +487,19 @@ func (conn *Conn) readLoop() {
 
 		_, err := fr.ReadFrom(conn.bf)
 		if err != nil {
-			if err != EOF && !strings.Contains(err.Error(), "closed") {
+			if atomic.LoadUint32(&conn.pausing) == 1 {
+				ReleaseFrame(fr)
+				conn.paused <- struct{}{}
+				<-conn.resume
+				continue
+			}
+			if atomic.LoadUint32(&conn.closing) == 0 {
+				conn.lck.Lock()
+				conn.loopErr = err
+				conn.lck.Unlock()
+			}
+			// the errors of reading from a connection closed by us are expected.
+			if err != EOF && atomic.LoadUint32(&conn.closing) == 0 {
 				var (
 					ok   = true // it can only be false
 					errn error
@@ -158,60 +519,423 @@ func (conn *Conn) readLoop() {
 			ReleaseFrame(fr)
 			return
 		}
+		conn.logFrame(DirectionIn, fr.Code(), fr.IsFin(), fr.IsMasked(), fr.Len())
+		atomic.StoreInt64(&conn.stats.lastRead, conn.now().UnixNano())
+		storeMax(&conn.stats.maxReadBuffered, uint64(conn.bf.Reader.Buffered()))
+		storeMax(&conn.stats.maxFrameRead, uint64(fr.PayloadLen()))
+		if fr.IsPong() {
+			conn.pongReceived(fr)
+		}
+		// the frame queued counts even if the queue is full.
+		storeMax(&conn.stats.maxFrameQueue, uint64(len(conn.framer)+1))
 		conn.framer <- fr
 	}
 }
 
+type pendingPing struct {
+	payload []byte
+	done    chan struct{}
+}
+
+// Ping sends a PING with payload and waits for the matching PONG,
+// returning the round-trip time.
+//
+// If payload is nil a payload identifying the PING is generated.
+// The PONGs are matched by the readLoop, so Ping doesn't need
+// other goroutine reading messages. Though the frames must still be read,
+// otherwise the readLoop stops receiving once its queue is full.
+//
+// Ping returns ctx.Err() if ctx is done before receiving the PONG
+// and EOF if the connection gets closed.
+func (conn *Conn) Ping(ctx context.Context, payload []byte) (time.Duration, error) {
+	rtt, err := conn.ping(payload, ctx.Done(), nil)
+	if err == errInterrupted {
+		err = ctx.Err()
+	}
+	return rtt, err
+}
+
+// errPingTimeout is returned by ping when expire fires before receiving the PONG.
+var errPingTimeout = errors.New("ping timeout")
+
+// ping sends a PING as Ping does, returning errInterrupted if done is closed
+// and errPingTimeout if expire fires before receiving the PONG.
+func (conn *Conn) ping(payload []byte, done <-chan struct{}, expire <-chan time.Time) (time.Duration, error) {
+	p := &pendingPing{
+		done: make(chan struct{}),
+	}
+
+	conn.pingLck.Lock()
+	if payload == nil {
+		conn.pingSeq++
+		p.payload = strconv.AppendUint(p.payload, conn.pingSeq, 10)
+	} else {
+		p.payload = append(p.payload, payload...)
+	}
+	conn.pings = append(conn.pings, p)
+	conn.pingLck.Unlock()
+
+	start := conn.now()
+	err := conn.SendCode(CodePing, 0, p.payload)
+	if err == nil {
+		select {
+		case <-p.done:
+			return conn.now().Sub(start), nil
+		case <-done:
+			err = errInterrupted
+		case <-expire:
+			err = errPingTimeout
+		case <-conn.readDone:
+			err = errClosed
+		}
+	}
+	conn.removePing(p)
+
+	return 0, err
+}
+
+// pongReceived notifies the first ping waiting for the payload of fr.
+func (conn *Conn) pongReceived(fr *Frame) {
+	payload := fr.Payload()
+	if fr.IsMasked() {
+		payload = append([]byte(nil), payload...)
+		mask(fr.MaskKey(), payload)
+	}
+
+	conn.pingLck.Lock()
+	for _, p := range conn.pings {
+		if bytes.Equal(p.payload, payload) {
+			conn.removePingLocked(p)
+			close(p.done)
+			break
+		}
+	}
+	conn.pingLck.Unlock()
+}
+
+func (conn *Conn) removePing(p *pendingPing) {
+	conn.pingLck.Lock()
+	conn.removePingLocked(p)
+	conn.pingLck.Unlock()
+}
+
+func (conn *Conn) removePingLocked(p *pendingPing) {
+	for i := range conn.pings {
+		if conn.pings[i] == p {
+			conn.pings = append(conn.pings[:i], conn.pings[i+1:]...)
+			break
+		}
+	}
+}
+
 // WriteFrame writes fr to the connection endpoint.
+//
+// Concurrent callers are serialized by the connection write lock.
+// Under contention the lock is handed off to the waiters in FIFO order
+// (see sync.Mutex starvation mode), so no writer waits indefinitely.
+// The strict FIFO order can be enabled using OrderedWrites.
+// The lock wait times are reported by Stats.
+//
+// Data frames wait for the message being written by a NextWriter (if any)
+// to be finished, while control frames can be written in between its fragments.
+//
+// Control frames with a payload bigger than 125 bytes are not sent.
 func (conn *Conn) WriteFrame(fr *Frame) (int, error) {
-	conn.lck.Lock()
+	return conn.writeFrameDone(nil, fr)
+}
+
+// writeFrameDone writes fr as WriteFrame does, interrupting the write if done is closed.
+func (conn *Conn) writeFrameDone(done <-chan struct{}, fr *Frame) (int, error) {
+	if fr.IsControl() || conn.isSingleWriter() {
+		return conn.sendFrame(done, fr)
+	}
+
+	conn.lockMessage()
+	nn, err := conn.sendFrame(done, fr)
+	conn.msgLck.Unlock()
+
+	return nn, err
+}
+
+// sendFrame writes fr without waiting for the message in flight.
+func (conn *Conn) sendFrame(done <-chan struct{}, fr *Frame) (int, error) {
+	if fr.controlTooBig() {
+		return 0, errControlTooBig
+	}
+	defer conn.closeIfPeerDead()
+
+	single := conn.isSingleWriter()
+	conn.lockFrames(single)
+	nn, err := conn.writeFrame(done, fr)
+	conn.unlockFrames(single)
+
+	return nn, err
+}
+
+// writeFrame writes fr to the connection. If done is closed while writing
+// the write is interrupted setting a past write deadline.
+func (conn *Conn) writeFrame(done <-chan struct{}, fr *Frame) (int, error) {
 	if conn.closed {
-		conn.lck.Unlock()
-		return 0, EOF
+		return 0, errClosed
 	}
 	// TODO: Compress
 
 	fr.SetPayloadSize(conn.MaxPayloadSize)
+	storeMax(&conn.stats.maxFrameWritten, uint64(fr.PayloadLen()))
 
-	if conn.WriteTimeout > 0 {
-		conn.c.SetWriteDeadline(time.Now().Add(conn.WriteTimeout))
-	}
+	conn.logFrame(DirectionOut, fr.Code(), fr.IsFin(), fr.IsMasked(), fr.Len())
 
+	stop := conn.beginWrite(done)
 	nn, err := fr.WriteTo(conn.bf)
-	if err == nil {
+	if err == nil && (!conn.manualFlush || fr.IsControl()) {
 		err = conn.bf.Flush()
 	}
+	conn.endWrite(stop, err)
+
+	if err == nil && !fr.IsControl() {
+		conn.reportWrite(int(fr.PayloadLen()), fr.IsFin())
+	}
+
+	return int(nn), err
+}
+
+// beginWrite sets the write deadline, returning the function
+// stopping the watcher interrupting the write when done is closed (if any).
+func (conn *Conn) beginWrite(done <-chan struct{}) (stop func()) {
+	deadline, _ := nearest(time.Now(), conn.WriteTimeout, atomic.LoadInt64(&conn.writeDeadline))
+	if !deadline.IsZero() {
+		conn.c.SetWriteDeadline(deadline)
+	}
+	if done != nil {
+		stop = conn.interruptWrite(done)
+	}
+	return stop
+}
+
+// endWrite resets the write deadline set by beginWrite,
+// counting the write timeouts of the writes not interrupted (see MaxWriteTimeouts).
+func (conn *Conn) endWrite(stop func(), err error) {
+	if stop != nil {
+		// the deadline must not be reset before the watcher exits.
+		stop()
+	} else {
+		conn.countWriteTimeout(err)
+	}
 	conn.c.SetWriteDeadline(zeroTime)
+}
+
+// lockWrite acquires the write lock measuring the time spent waiting for it.
+//
+// The time is only measured when other writer holds or waits for the lock.
+func (conn *Conn) lockWrite() {
+	n := atomic.AddInt32(&conn.writers, 1)
+	storeMax(&conn.stats.maxSendQueue, uint64(n))
+	if n > 1 {
+		start := time.Now()
+		conn.lockOrdered(&conn.writeQueue, &conn.lck)
+		atomic.AddUint64(&conn.stats.writeLockContended, 1)
+		atomic.AddInt64(&conn.stats.writeLockWait, int64(time.Since(start)))
+	} else {
+		conn.lockOrdered(&conn.writeQueue, &conn.lck)
+	}
+	atomic.AddUint64(&conn.stats.writeLocks, 1)
+}
+
+func (conn *Conn) unlockWrite() {
 	conn.lck.Unlock()
+	atomic.AddInt32(&conn.writers, -1)
+}
 
-	return int(nn), err
+// lockFrames acquires the write lock as lockWrite does, though the single
+// writers (see SetSingleWriter) skip the ordering and the accounting.
+func (conn *Conn) lockFrames(single bool) {
+	if single {
+		conn.lck.Lock()
+	} else {
+		conn.lockWrite()
+	}
+}
+
+// unlockFrames releases the write lock acquired using lockFrames.
+func (conn *Conn) unlockFrames(single bool) {
+	if single {
+		conn.lck.Unlock()
+	} else {
+		conn.unlockWrite()
+	}
+}
+
+// SetMaskSource sets the function filling the mask keys
+// of the frames sent by the client.
+//
+// By default the keys are read from crypto/rand. High-throughput clients
+// can use a cheaper source and tests a fixed key to get deterministic frames.
+// Setting nil restores the default source.
+func (conn *Conn) SetMaskSource(read func(key []byte)) {
+	conn.maskSource = read
+}
+
+// SetPingHandler sets the function called when a PING is received
+// while reading a message.
+//
+// The handler replaces the default one, which replies with a PONG,
+// so the handler must send the PONG (using SendCode(CodePong, 0, payload))
+// to keep the peer happy. payload must not be retained after returning.
+// If the handler returns an error the connection is closed and
+// the error is returned by the read function.
+//
+// Setting nil restores the default handler.
+func (conn *Conn) SetPingHandler(handler func(payload []byte) error) {
+	conn.pingHandler = handler
+}
+
+// SetPongHandler sets the function called when a PONG is received
+// while reading a message. By default the PONGs are ignored.
+// The unsolicited PONGs are passed depending on conn.UnsolicitedPong.
+//
+// payload must not be retained after returning.
+// If the handler returns an error the connection is closed and
+// the error is returned by the read function.
+func (conn *Conn) SetPongHandler(handler func(payload []byte) error) {
+	conn.pongHandler = handler
+}
+
+// SetCloseHandler sets the function called when a close frame is received
+// while reading a message.
+//
+// The handler replaces the default one, which echoes the close frame,
+// so the handler must send the reply (using SendCode(CodeClose, status, reason))
+// if required. The connection is closed after the handler returns.
+// reason must not be retained after returning.
+//
+// The read function returns the error returned by the handler,
+// or a *CloseError if the handler returned nil.
+func (conn *Conn) SetCloseHandler(handler func(status StatusCode, reason []byte) error) {
+	conn.closeHandler = handler
+}
+
+// numReservedOpcodes is the number of reserved data opcodes (0x3-0x7).
+const numReservedOpcodes = 5
+
+// ErrOpcodeNotReserved is returned by SetOpcodeHandler when the opcode
+// is not a reserved data opcode (0x3-0x7).
+var ErrOpcodeNotReserved = errors.New("only the reserved data opcodes (0x3-0x7) can have handlers")
+
+var errOpcodeMustNotBeFragmented = newError(ErrProtocol, "frames of custom opcodes must not be fragmented")
+
+// SetOpcodeHandler sets the function called when a frame with the reserved
+// data opcode code (0x3-0x7) is received while reading a message,
+// so experimental features negotiated by the peers can use their own frames.
+//
+// The frames are passed unmasked to handler and must not be retained
+// after returning. They can be sent between the fragments of a message,
+// but they must not be fragmented themselves. In Strict mode the opcodes with
+// handlers are allowed, though their mask is still checked.
+// If the handler returns an error the connection is closed and
+// the error is returned by the read function.
+//
+// The frames read using ReadFrame or NextFrame are not handled.
+// Setting nil removes the handler, so the opcode is reserved again.
+func (conn *Conn) SetOpcodeHandler(code Code, handler func(fr *Frame) error) error {
+	if code < 0x3 || code > 0x7 {
+		return ErrOpcodeNotReserved
+	}
+	conn.opcodeHandlers[code-0x3] = handler
+	return nil
+}
+
+// opcodeHandler returns the handler of code (if any).
+func (conn *Conn) opcodeHandler(code Code) func(fr *Frame) error {
+	if code < 0x3 || code > 0x7 {
+		return nil
+	}
+	return conn.opcodeHandlers[code-0x3]
+}
+
+// validateFrame checks fr as ValidateFrame does,
+// allowing the opcodes with handlers.
+func (conn *Conn) validateFrame(fr *Frame) error {
+	if conn.opcodeHandler(fr.Code()) != nil {
+		return fr.validateMask(conn.server)
+	}
+	return ValidateFrame(fr, conn.server, conn.compress)
+}
+
+// mask masks fr using the connection mask source.
+func (conn *Conn) mask(fr *Frame) {
+	if conn.maskSource == nil {
+		fr.Mask()
+	} else {
+		fr.maskWith(conn.maskSource)
+	}
 }
 
 // ReadFrame fills fr with the next connection frame.
 func (conn *Conn) ReadFrame(fr *Frame) (nn int, err error) {
+	conn.beginRead()
+	defer conn.endRead()
+
+	return conn.readFrame(nil, fr)
+}
+
+// readFrame fills fr as ReadFrame does, returning errInterrupted if done is closed.
+func (conn *Conn) readFrame(done <-chan struct{}, fr *Frame) (nn int, err error) {
+	fr2, err := conn.recvFrame(done)
+	if err == nil {
+		fr2.CopyTo(fr)
+		nn = fr.PayloadLen()
+		ReleaseFrame(fr2)
+	}
+
+	return
+}
+
+// recvFrame returns the next frame read by the readLoop.
+// errInterrupted is returned if done is closed before receiving the frame.
+//
+// The frame must be released using ReleaseFrame.
+func (conn *Conn) recvFrame(done <-chan struct{}) (fr *Frame, err error) {
 	var expire <-chan time.Time
-	if conn.ReadTimeout > 0 {
-		timer := time.NewTimer(conn.ReadTimeout)
-		expire = timer.C
+	clock := conn.getClock()
+	now := clock.Now()
+	t, deadline := nearest(now, conn.ReadTimeout, atomic.LoadInt64(&conn.readDeadline))
+
+	// the frames already queued are returned without arming a timer,
+	// so reading a busy connection doesn't allocate.
+	if done == nil && (t.IsZero() || t.After(now)) {
+		select {
+		case fr, ok := <-conn.framer:
+			if ok {
+				return fr, nil
+			}
+		default:
+		}
+	}
+
+	if !t.IsZero() {
+		timer := clock.NewTimer(t.Sub(now))
+		expire = timer.C()
 		defer timer.Stop()
 	}
 
 	var ok bool
 	select {
-	case fr2, ok := <-conn.framer:
+	case fr, ok = <-conn.framer:
 		if !ok {
-			err = EOF
-		} else {
-			fr2.CopyTo(fr)
-			nn = fr.PayloadLen()
-			ReleaseFrame(fr2)
+			err = errClosed
 		}
 	case err, ok = <-conn.errch:
 		if !ok {
-			err = EOF
+			err = errClosed
 		}
 	case <-expire:
-		err = errors.New("i/o timeout")
+		if deadline {
+			err = errDeadlineExceeded
+		} else {
+			err = errReadTimeout
+		}
+	case <-done:
+		err = errInterrupted
 	}
 
 	return
@@ -223,13 +947,118 @@ func (conn *Conn) WriteString(b string) (int, error) {
 }
 
 // Write writes b using conn.Mode as default.
+//
+// Unlike WriteMessage, Write returns the number of bytes of b written
+// (not counting the frame header) as io.Writer requires.
 func (conn *Conn) Write(b []byte) (int, error) {
-	return conn.write(conn.Mode, b)
+	n, err := conn.write(nil, conn.Mode, b)
+	n -= frameHeaderSize(len(b), !conn.server)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// frameHeaderSize returns the size of the header of a frame of size bytes.
+func frameHeaderSize(size int, masked bool) int {
+	n := 2
+	switch {
+	case size > 65535:
+		n += 8
+	case size > 125:
+		n += 2
+	}
+	if masked {
+		n += 4
+	}
+	return n
+}
+
+// Read reads the payload of the messages received into b.
+//
+// The messages are read as a stream, so the data of a message
+// bigger than b is returned by the next calls to Read.
+// The message boundaries and modes are not preserved,
+// use ReadMessage or NextReader when they matter.
+// Do not mix Read with the other read functions, as the remainder
+// of the message being read by Read would be skipped.
+//
+// Read returns io.EOF when the connection is closed normally.
+func (conn *Conn) Read(b []byte) (int, error) {
+	conn.beginRead()
+	defer conn.endRead()
+
+	for conn.rpos == len(conn.rb) {
+		var err error
+		_, conn.rb, err = conn.read(nil, conn.rb[:0])
+		conn.rpos = 0
+		if err != nil {
+			conn.rb = conn.rb[:0]
+			return 0, readEOF(err)
+		}
+	}
+
+	n := copy(b, conn.rb[conn.rpos:])
+	conn.rpos += n
+
+	return n, nil
+}
+
+// readEOF converts the errors of normal closures to io.EOF.
+func readEOF(err error) error {
+	var cerr *CloseError
+	if errors.As(err, &cerr) {
+		switch cerr.Status {
+		case 0, StatusNone, StatusGoAway:
+			return EOF
+		}
+		return err
+	}
+	if errors.Is(err, EOF) {
+		return EOF
+	}
+	return err
 }
 
 // WriteMessage writes b to conn using mode.
 func (conn *Conn) WriteMessage(mode Mode, b []byte) (int, error) {
-	return conn.write(mode, b)
+	return conn.write(nil, mode, b)
+}
+
+// WriteMessageNoCopy writes b to conn using mode as WriteMessage does,
+// without copying b into the frame.
+//
+// WriteMessageNoCopy takes the ownership of b until returning, so b must not
+// be used meanwhile. On client connections b is masked in place while
+// writing and restored before returning.
+func (conn *Conn) WriteMessageNoCopy(mode Mode, b []byte) (int, error) {
+	conn.tap(DirectionOut, mode, b)
+
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	fr.SetFin()
+	if mode == ModeBinary {
+		fr.SetBinary()
+	} else {
+		fr.SetText()
+	}
+
+	// the frame payload is restored so the pool doesn't keep b.
+	payload := fr.b
+	fr.b = b
+	if !conn.server {
+		conn.mask(fr)
+	}
+
+	n, err := conn.writeFrameDone(nil, fr)
+
+	if fr.IsMasked() {
+		mask(fr.MaskKey(), b)
+	}
+	fr.b = payload
+
+	return n, err
 }
 
 // ReadMessage reads next message from conn and returns the mode, b and/or error.
@@ -237,8 +1066,14 @@ func (conn *Conn) WriteMessage(mode Mode, b []byte) (int, error) {
 // b is used to avoid extra allocations and can be nil.
 //
 // This function responds automatically to PING and PONG messages.
+// The duplicated messages are dropped if a Deduplicator has been set.
+//
+// When the peer closes the connection the error is a *CloseError.
 func (conn *Conn) ReadMessage(b []byte) (Mode, []byte, error) {
-	return conn.read(b)
+	conn.beginRead()
+	defer conn.endRead()
+
+	return conn.read(nil, b)
 }
 
 // SendCodeString writes code, status and message to conn as SendCode does.
@@ -250,7 +1085,21 @@ func (conn *Conn) SendCodeString(code Code, status StatusCode, b string) error {
 //
 // status is used by CodeClose to report any close status (as HTTP responses). Can be 0.
 // b can be nil.
+//
+// The payload of control codes (status included) must not exceed 125 bytes.
+// The reason of CodeClose must be UTF-8 encoded, otherwise the error
+// matches ErrInvalidCloseReason.
 func (conn *Conn) SendCode(code Code, status StatusCode, b []byte) error {
+	if code == CodeClose {
+		if err := checkCloseReason(b); err != nil {
+			return err
+		}
+	}
+
+	if code == CodePing {
+		conn.pingSent(b)
+	}
+
 	fr := AcquireFrame()
 	fr.SetFin()
 	fr.SetCode(code)
@@ -261,7 +1110,7 @@ func (conn *Conn) SendCode(code Code, status StatusCode, b []byte) error {
 		fr.Write(b)
 	}
 	if !conn.server && !fr.IsMasked() {
-		fr.Mask()
+		conn.mask(fr)
 	}
 	_, err := conn.WriteFrame(fr)
 	ReleaseFrame(fr)
@@ -295,22 +1144,44 @@ func (conn *Conn) checkRequirements(fr *Frame, betweenContinuation bool) (c bool
 		if !isFin && !betweenContinuation {
 			err = errControlMustNotBeFragmented
 		} else {
-			err = conn.SendCode(CodePong, 0, fr.Payload())
+			if conn.pingHandler != nil {
+				err = conn.pingHandler(fr.Payload())
+			} else {
+				err = conn.SendCode(CodePong, 0, fr.Payload())
+			}
+			c = true
+		}
+	case conn.opcodeHandler(fr.Code()) != nil:
+		if !isFin {
+			err = errOpcodeMustNotBeFragmented
+		} else {
+			err = conn.opcodeHandler(fr.Code())(fr)
 			c = true
 		}
 	case fr.IsPong():
 		if !isFin && !betweenContinuation {
 			err = errControlMustNotBeFragmented
 		} else {
+			err = conn.handlePong(fr.Payload())
 			c = true
 		}
 	case fr.IsClose():
 		if !isFin && !betweenContinuation {
 			err = errControlMustNotBeFragmented
 		} else {
-			err = conn.ReplyClose(fr)
-			if err == nil {
-				err = EOF
+			cerr := &CloseError{
+				Status: fr.Status(),
+				Reason: string(fr.Payload()),
+			}
+			conn.setCloseResult(ClosePeer)
+			if conn.closeHandler != nil {
+				err = conn.closeHandler(fr.Status(), fr.Payload())
+				conn.mustClose(false)
+			} else {
+				err = conn.ReplyClose(fr)
+			}
+			if err == nil || errors.Is(err, EOF) {
+				err = cerr
 			}
 		}
 		c = false
@@ -319,7 +1190,7 @@ func (conn *Conn) checkRequirements(fr *Frame, betweenContinuation bool) (c bool
 	return
 }
 
-func (conn *Conn) write(mode Mode, b []byte) (int, error) {
+func (conn *Conn) write(done <-chan struct{}, mode Mode, b []byte) (int, error) {
 	fr := AcquireFrame()
 	defer ReleaseFrame(fr)
 
@@ -330,53 +1201,124 @@ func (conn *Conn) write(mode Mode, b []byte) (int, error) {
 		fr.SetText()
 	}
 
+	conn.tap(DirectionOut, mode, b)
+
 	fr.SetPayload(b)
 	if !conn.server {
-		fr.Mask()
+		conn.mask(fr)
 	}
 
-	return conn.WriteFrame(fr)
+	return conn.writeFrameDone(done, fr)
 }
 
-func (conn *Conn) read(b []byte) (Mode, []byte, error) {
-	var err error
-	fr := AcquireFrame()
+// read reads the next message not duplicated appending it to b.
+// The read is interrupted when done is closed.
+func (conn *Conn) read(done <-chan struct{}, b []byte) (mode Mode, _ []byte, err error) {
+	n := len(b)
+	for {
+		mode, b, err = conn.readMessage(done, b)
+		if err != nil || conn.dedup == nil || !conn.dedup.IsDuplicate(mode, b[n:]) {
+			break
+		}
+		b = b[:n]
+	}
+
+	return mode, b, err
+}
+
+// tinyMessageSize is the max payload size of the messages read using the fast path.
+const tinyMessageSize = 1 << 10
+
+// readMessage reads the next message appending it to b.
+//
+// Unfragmented messages of up to tinyMessageSize bytes are appended
+// straight from the frame read, skipping the checks done by ReadFull
+// when they cannot fail.
+func (conn *Conn) readMessage(done <-chan struct{}, b []byte) (Mode, []byte, error) {
+	fr, err := conn.recvFrame(done)
+	if isInterruption(err) {
+		return ModeText, b, err
+	}
+	if err != nil {
+		return ModeText, b, conn.closeOnReadError(err)
+	}
 	defer ReleaseFrame(fr)
 
-	b, err = conn.ReadFull(b, fr)
+	if conn.isTiny(fr) {
+		if fr.IsMasked() {
+			fr.Unmask()
+		}
+		storeMax(&conn.stats.maxMessageRead, uint64(fr.PayloadLen()))
+		conn.tap(DirectionIn, fr.Mode(), fr.Payload())
+		return fr.Mode(), append(b, fr.Payload()...), nil
+	}
 
+	b, err = conn.readFull(done, b, fr, true)
 	return fr.Mode(), b, err
 }
 
+// isTiny returns whether fr is a message that can be read using the fast path.
+func (conn *Conn) isTiny(fr *Frame) bool {
+	return fr.PayloadLen() <= tinyMessageSize && conn.isWhole(fr)
+}
+
+// isWhole returns whether fr is an unfragmented message
+// which can be used without the checks done by ReadFull.
+func (conn *Conn) isWhole(fr *Frame) bool {
+	size := fr.PayloadLen()
+	code := fr.Code()
+	return fr.IsFin() && (code == CodeText || code == CodeBinary) &&
+		!fr.HasRSV1() && !conn.Strict && (conn.server || !fr.IsMasked()) &&
+		(conn.MaxMessageSize == 0 || uint64(size) <= conn.MaxMessageSize)
+}
+
 // ReadFull will read the parsed frame fully and writing the payload into b.
 //
+// When the message is fragmented fr has the header of the last frame
+// with the code of the first one, so fr.Mode() returns the message mode.
+//
 // This function responds automatically to PING and PONG messages.
 func (conn *Conn) ReadFull(b []byte, fr *Frame) ([]byte, error) {
-	var c bool
+	conn.beginRead()
+	defer conn.endRead()
+
+	return conn.readFull(nil, b, fr, false)
+}
+
+// readFull reads the message into b as ReadFull does.
+//
+// If pending is true fr already holds the first frame of the message.
+func (conn *Conn) readFull(done <-chan struct{}, b []byte, fr *Frame, pending bool) ([]byte, error) {
 	var err error
+	var code Code
+	var size uint64
 	betweenContinue := false
+	compressed := false
+	start := len(b)
 
-	for {
-		fr.Reset()
-
-		_, err = conn.ReadFrame(fr)
+	for fragments := 1; ; fragments++ {
+		err = conn.nextDataFrame(done, fr, betweenContinue, pending)
+		pending = false
 		if err != nil {
 			break
 		}
-		if fr.IsMasked() {
-			fr.Unmask()
+		if !betweenContinue {
+			code = fr.Code()
+			compressed = fr.HasRSV1()
+			if conn.Strict && fr.IsContinuation() {
+				err = errUnexpectedContinuation
+				break
+			}
 		}
 
-		c, err = conn.checkRequirements(fr, betweenContinue)
+		size += uint64(fr.PayloadLen())
+		err = conn.checkMessageLimits(size, fragments)
 		if err != nil {
 			break
 		}
-		if c {
-			continue
-		}
 
 		if betweenContinue && !fr.IsFin() && !fr.IsContinuation() && !fr.IsControl() {
-			err = fmt.Errorf("%s. Got %d", errFrameBetweenContinuation, fr.Code())
+			err = fmt.Errorf("%w. Got %d", errFrameBetweenContinuation, fr.Code())
 			break
 		}
 
@@ -391,28 +1333,143 @@ func (conn *Conn) ReadFull(b []byte, fr *Frame) ([]byte, error) {
 		// fragmented
 		betweenContinue = true
 	}
-	if err != nil {
-		var nErr error
-		switch err {
-		case errLenTooBig:
-			nErr = conn.sendClose(StatusTooBig, nil)
-		case errStatusLen:
-			nErr = conn.sendClose(StatusNotConsistent, nil)
-		case errControlMustNotBeFragmented, errFrameBetweenContinuation:
-			nErr = conn.sendClose(StatusProtocolError, nil)
+	if err == nil && compressed {
+		b, err = inflate(b, start, conn.MaxMessageSize)
+		size = uint64(len(b) - start)
+	}
+	if err == nil && conn.Strict && code == CodeText && !utf8.Valid(b[start:]) {
+		err = errInvalidUTF8
+	}
+	switch {
+	case isInterruption(err) && !betweenContinue:
+		// nothing has been read yet, so the connection can be read again.
+	case err != nil:
+		err = conn.closeOnReadError(err)
+	case betweenContinue:
+		fr.SetCode(code)
+	}
+	if err == nil {
+		storeMax(&conn.stats.maxMessageRead, size)
+		conn.tap(DirectionIn, fr.Mode(), b[start:])
+	}
+
+	return b, err
+}
+
+// checkMessageLimits checks the size and the number of fragments
+// of the message being read against the connection limits.
+func (conn *Conn) checkMessageLimits(size uint64, fragments int) error {
+	if conn.MaxMessageSize > 0 && size > conn.MaxMessageSize {
+		return errMessageTooBig
+	}
+	if conn.MaxFragments > 0 && fragments > conn.MaxFragments {
+		return errTooManyFragments
+	}
+	return nil
+}
+
+// nextDataFrame reads the next data frame into fr, handling the control frames
+// received in between.
+//
+// If pending is true the frame already in fr is handled before reading.
+func (conn *Conn) nextDataFrame(done <-chan struct{}, fr *Frame, betweenContinue, pending bool) error {
+	var (
+		c   bool
+		err error
+	)
+	for ; ; pending = false {
+		if !pending {
+			fr.Reset()
+
+			_, err = conn.readFrame(done, fr)
+			if err != nil {
+				return err
+			}
 		}
-		if nErr != nil {
-			err = fmt.Errorf("error closing connection due to %s: %s", err, nErr)
+		if conn.Strict {
+			if err = conn.validateFrame(fr); err != nil {
+				return err
+			}
+		}
+		if fr.IsMasked() {
+			fr.Unmask()
+		}
+
+		c, err = conn.checkRequirements(fr, betweenContinue)
+		if err != nil {
+			return err
+		}
+		if !c {
+			if fr.IsClose() {
+				return nil
+			}
+			return conn.checkConcurrentRead(fr, betweenContinue)
 		}
-		conn.mustClose(err == nil)
 	}
+}
 
-	return b, err
+// closeOnReadError closes the connection notifying the peer
+// the reason (if any) of the reading error err.
+func (conn *Conn) closeOnReadError(err error) error {
+	if err == errDeadlineExceeded {
+		// the partial message is lost, so the connection can't be read again.
+		err = errDeadlineClosed
+	}
+
+	var nErr error
+	switch {
+	case errors.Is(err, ErrTooBig):
+		nErr = conn.sendClose(StatusTooBig, nil)
+	case err == ErrConcurrentRead:
+		nErr = conn.sendClose(StatusUnexpected, nil)
+	case errors.Is(err, ErrInvalidData):
+		nErr = conn.sendClose(StatusNotConsistent, nil)
+	case errors.Is(err, ErrProtocol):
+		nErr = conn.sendClose(StatusProtocolError, nil)
+	}
+	if nErr != nil {
+		err = fmt.Errorf("error closing connection due to %w: %s", err, nErr)
+	}
+	conn.logf(LogWarn, "closing connection: %v", err)
+	conn.setReadError(err)
+	conn.mustClose(false)
+
+	return err
+}
+
+// closeWithError closes the connection due to err sending status to the peer.
+//
+// err is reported as the error which closed the connection.
+func (conn *Conn) closeWithError(err error, status StatusCode) {
+	conn.setReadError(err)
+	conn.sendClose(status, nil)
+	conn.mustClose(false)
+}
+
+func (conn *Conn) setReadError(err error) {
+	conn.lck.Lock()
+	if conn.readErr == nil {
+		conn.readErr = err
+	}
+	conn.lck.Unlock()
+}
+
+// readError returns the error which closed the connection while reading.
+func (conn *Conn) readError() error {
+	conn.lck.Lock()
+	err := conn.readErr
+	conn.lck.Unlock()
+
+	return err
 }
 
 var (
-	errControlMustNotBeFragmented = errors.New("control frames must not be fragmented")
-	errFrameBetweenContinuation   = errors.New("received frame between continuation frames")
+	errControlMustNotBeFragmented = newError(ErrProtocol, "control frames must not be fragmented")
+	errFrameBetweenContinuation   = newError(ErrProtocol, "received frame between continuation frames")
+	errMessageTooBig              = newError(ErrTooBig, "message size is bigger than expected")
+	errTooManyFragments           = newError(ErrTooBig, "message has more fragments than expected")
+	errUnexpectedContinuation     = newError(ErrProtocol, "received continuation frame without a message in progress")
+	errInvalidUTF8                = newError(ErrInvalidData, "invalid UTF-8 encoded text")
 )
 
 func (conn *Conn) sendClose(status StatusCode, b []byte) (err error) {
@@ -428,7 +1485,7 @@ func (conn *Conn) sendClose(status StatusCode, b []byte) (err error) {
 		fr.SetPayload(b)
 	}
 	if !conn.server {
-		fr.Mask()
+		conn.mask(fr)
 	}
 
 	if conn.WriteTimeout == 0 {
@@ -463,60 +1520,262 @@ func (conn *Conn) Close() error {
 }
 
 // CloseString sends b as close reason and closes the descriptor.
+// b is checked as CloseWithCode does.
 //
 // When connection is handled by server the connection is closed automatically.
 func (conn *Conn) CloseString(b string) error {
-	conn.lck.Lock()
-	if conn.closed {
-		conn.lck.Unlock()
-		return EOF
+	return conn.CloseWithCode(StatusNone, b)
+}
+
+// CloseWithCode sends status and reason to the peer and closes the descriptor.
+//
+// reason can be empty. If reason is longer than MaxCloseReasonSize bytes
+// or is not UTF-8 encoded the connection is not closed and
+// the error returned matches ErrInvalidCloseReason.
+//
+// If conn is already being closed (i.e. by the keepalive or a Closer)
+// CloseWithCode waits for the close to finish and returns an error
+// matching EOF and ErrConnectionClosed.
+func (conn *Conn) CloseWithCode(status StatusCode, reason string) error {
+	if conn.isClosed() {
+		// waits for the close in progress (if any).
+		<-conn.closeDone
+		return errClosed
 	}
-	conn.lck.Unlock()
 
 	var bb []byte
-	if b != "" {
-		bb = s2b(b)
+	if reason != "" {
+		bb = s2b(reason)
+	}
+	if err := checkCloseReason(bb); err != nil {
+		return err
 	}
-	conn.sendClose(StatusNone, bb)
+	conn.sendClose(status, bb)
 
 	return conn.mustClose(true)
 }
 
+// DefaultCloseTimeout is the default time Close waits for the peer
+// to reply to the close frame (see Conn.CloseTimeout).
+const DefaultCloseTimeout = time.Second * 5
+
+// CloseResult represents how a connection was closed.
+type CloseResult uint8
+
+const (
+	// CloseNotClosed means the connection hasn't been closed yet.
+	CloseNotClosed CloseResult = iota
+	// CloseHandshake means the close frame sent was replied by the peer,
+	// completing the closing handshake.
+	CloseHandshake
+	// ClosePeer means the peer started the closing handshake,
+	// and its close frame was replied.
+	ClosePeer
+	// CloseTimedOut means the peer didn't reply to the close frame
+	// within the CloseTimeout (or before the Upgrader shut down).
+	CloseTimedOut
+	// CloseFast means the connection was closed without waiting for the reply
+	// of the peer, either because CloseTimeout is negative
+	// or because the connection was closed due to an error.
+	CloseFast
+)
+
+func (r CloseResult) String() string {
+	switch r {
+	case CloseNotClosed:
+		return "not closed"
+	case CloseHandshake:
+		return "handshake"
+	case ClosePeer:
+		return "closed by peer"
+	case CloseTimedOut:
+		return "timed out"
+	case CloseFast:
+		return "fast close"
+	}
+	return "unknown"
+}
+
+// CloseResult returns how conn was closed, so the clients can tell
+// whether the closing handshake was completed.
+func (conn *Conn) CloseResult() CloseResult {
+	conn.lck.Lock()
+	defer conn.lck.Unlock()
+
+	return conn.closeResult
+}
+
+// setCloseResult sets the result unless it was set before.
+func (conn *Conn) setCloseResult(r CloseResult) {
+	conn.lck.Lock()
+	if conn.closeResult == CloseNotClosed {
+		conn.closeResult = r
+	}
+	conn.lck.Unlock()
+}
+
+// IsClosed returns whether conn has been closed,
+// either by conn or by the peer (the connection can't be read anymore).
+func (conn *Conn) IsClosed() bool {
+	if conn.isClosed() {
+		return true
+	}
+	select {
+	case <-conn.readDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err returns the error which terminated the connection,
+// or nil if the connection is alive.
+//
+// The error is the one which closed the connection while reading
+// (a *CloseError if the peer closed it, as returned by the read functions),
+// otherwise the error which stopped the reads from the connection
+// (io.EOF if the peer closed the transport). If the connection
+// was closed by conn without errors Err returns io.EOF.
+func (conn *Conn) Err() error {
+	conn.lck.Lock()
+	defer conn.lck.Unlock()
+
+	return conn.err()
+}
+
+// err returns the error returned by Err. It must be called holding conn.lck.
+func (conn *Conn) err() error {
+	switch {
+	case conn.readErr != nil:
+		return conn.readErr
+	case conn.loopErr != nil:
+		return conn.loopErr
+	case conn.closed:
+		return EOF
+	}
+	return nil
+}
+
+func (conn *Conn) isClosed() bool {
+	conn.lck.Lock()
+	closed := conn.closed
+	conn.lck.Unlock()
+
+	return closed
+}
+
 func (conn *Conn) mustClose(wait bool) error {
+	_, err := conn.closeWait(wait, nil)
+	return err
+}
+
+// closeWait closes conn as mustClose does, reporting whether
+// the peer replied with a close frame when waiting for it.
+// The wait ends when abort is closed.
+func (conn *Conn) closeWait(wait bool, abort <-chan struct{}) (replied bool, err error) {
 	conn.lck.Lock()
 	if conn.closed {
 		conn.lck.Unlock()
-		return EOF
+		// waits for the close in progress (if any).
+		<-conn.closeDone
+		return false, errClosed
 	}
+	defer close(conn.closeDone)
 	conn.closed = true
+	atomic.StoreUint32(&conn.closing, 1)
 	conn.lck.Unlock()
 
+	conn.cLck.RLock()
 	conn.bf.Flush()
+	conn.cLck.RUnlock()
 	close(conn.errch)
 
-	if wait {
+	result := CloseFast
+	if wait && conn.CloseTimeout >= 0 {
+		result = CloseTimedOut
+
 		var fr *Frame
-		expire := time.After(time.Second * 5)
+		timeout := conn.CloseTimeout
+		if timeout == 0 {
+			timeout = DefaultCloseTimeout
+		}
+		timer := conn.getClock().NewTimer(timeout)
+		defer timer.Stop()
+		expire := timer.C()
 	loop:
 		for {
 			var ok bool
 			select {
 			case fr, ok = <-conn.framer:
 				if !ok || fr.IsClose() { // read until the close frame
+					replied = ok
+					if replied {
+						result = CloseHandshake
+					} else {
+						// the readLoop stopped (peer gone).
+						result = CloseFast
+					}
 					break loop
 				}
 				ReleaseFrame(fr)
 			case <-expire:
 				break loop
+			case <-abort:
+				// unblocks the readLoop, as the hijacked connections
+				// are not closed until the handler returns.
+				conn.getTransport().SetReadDeadline(time.Now())
+				break loop
 			}
 		}
 		if fr != nil {
 			ReleaseFrame(fr)
 		}
 	}
+	conn.setCloseResult(result)
 
-	err := conn.c.Close()
+	err = conn.getTransport().Close()
 	conn.wg.Wait() // should return immediately after closing
+	conn.terminate()
 
-	return err
+	return replied, err
+}
+
+// OnClose sets f to be called once when conn terminates, either because
+// the peer closed it, an error stopped the reads or conn was closed.
+// err is the error returned by Err when the connection terminated.
+//
+// f is called in its own goroutine. If conn already terminated
+// f is called right away. Calling OnClose again replaces the previous hook
+// (which isn't called) and a nil f removes it.
+func (conn *Conn) OnClose(f func(err error)) {
+	conn.lck.Lock()
+	conn.onClose = f
+	terminated := conn.terminated
+	err := conn.err()
+	conn.lck.Unlock()
+
+	if terminated && f != nil {
+		go f(err)
+	}
+}
+
+// terminate calls the OnClose hook unless it's been called already.
+func (conn *Conn) terminate() {
+	conn.lck.Lock()
+	if conn.terminated {
+		conn.lck.Unlock()
+		return
+	}
+	conn.terminated = true
+	f := conn.onClose
+	err := conn.err()
+	conn.lck.Unlock()
+
+	if m := conn.getMetrics(); m != nil {
+		m.OnClose(conn, err)
+	}
+
+	if f != nil {
+		go f(err)
+	}
 }
@@ -2,8 +2,16 @@ package fastws
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -69,7 +77,7 @@ func openConn(t *testing.T, ln *fasthttputil.InmemoryListener) *Conn {
 		t.Fatal(err)
 	}
 
-	conn := acquireConn(c)
+	conn := acquireConnReader(c, br)
 	return conn
 }
 
@@ -156,7 +164,7 @@ func handleConcurrentRead(conn *Conn) (err error) {
 	for {
 		_, b, err = conn.ReadMessage(b[:0])
 		if err != nil {
-			if err == EOF {
+			if errors.Is(err, EOF) {
 				err = nil
 			}
 			return err
@@ -220,6 +228,99 @@ func TestReadConcurrently(t *testing.T) {
 	ln.Close()
 }
 
+func TestReadFragmentedConcurrently(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	errch := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for {
+				_, _, err := server.ReadMessage(nil)
+				if err != nil {
+					errch <- err
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < 1000; i++ {
+			writeFragments(client, "Hello", " ", "world", "!!")
+		}
+	}()
+
+	detected := false
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errch:
+			detected = detected || errors.Is(err, ErrConcurrentRead)
+		case <-time.After(time.Second * 5):
+			t.Fatal("The concurrent read was not detected")
+		}
+	}
+	if !detected {
+		t.Fatalf("Expected %v", ErrConcurrentRead)
+	}
+	if err := server.readError(); !errors.Is(err, ErrConcurrentRead) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrConcurrentRead)
+	}
+}
+
+func TestReleaseConnWaitsReads(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	returned := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage(nil)
+		returned <- err
+	}()
+	// waits for the read to be in progress.
+	for atomic.LoadInt32(&server.readers) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	server.mustClose(false)
+	releaseConn(server)
+
+	if atomic.LoadInt32(&server.readers) != 0 {
+		t.Fatal("Connection released while reading")
+	}
+	if err := <-returned; err == nil {
+		t.Fatal("Expected an error reading a closed connection")
+	}
+}
+
+func TestCloseWaitsCloseInProgress(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	go server.CloseWithCode(StatusGoAway, "")
+	go func() {
+		// delays the reply to the close frame.
+		time.Sleep(time.Millisecond * 50)
+		client.ReadMessage(nil)
+	}()
+	for !server.isClosed() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := server.Close(); !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrConnectionClosed)
+	}
+	select {
+	case <-server.closeDone:
+	default:
+		t.Fatal("Close returned before the close in progress finished")
+	}
+	if res := server.CloseResult(); res != CloseHandshake {
+		t.Fatalf("Unexpected close result: %v <> %v", res, CloseHandshake)
+	}
+}
+
 func TestCloseWhileReading(t *testing.T) {
 	ln := fasthttputil.NewInmemoryListener()
 	s := fasthttp.Server{
@@ -227,7 +328,7 @@ func TestCloseWhileReading(t *testing.T) {
 			go func() {
 				_, _, err := conn.ReadMessage(nil)
 				if err != nil {
-					if err == EOF {
+					if errors.Is(err, EOF) {
 						return
 					}
 					panic(err)
@@ -299,3 +400,1146 @@ func TestUserValue(t *testing.T) {
 		t.Fatal("timeout")
 	}
 }
+
+func TestNextWriter(t *testing.T) {
+	text := bytes.Repeat([]byte("fastws"), DefaultWriterFrameSize/2)
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			m, b, err := conn.ReadMessage(nil)
+			if err != nil {
+				panic(err)
+			}
+			if m != ModeText {
+				panic("Unexpected code: Not ModeText")
+			}
+			if !bytes.Equal(b, text) {
+				panic(fmt.Sprintf("Unexpected message length: %d <> %d", len(b), len(text)))
+			}
+			conn.WriteString("Hello")
+		}),
+	}
+	go s.Serve(ln)
+
+	conn := openConn(t, ln)
+
+	w, err := conn.NextWriter(ModeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(text); i += 1000 {
+		n := i + 1000
+		if n > len(text) {
+			n = len(text)
+		}
+		if _, err = w.Write(text[i:n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(text); err != errWriterClosed {
+		t.Fatalf("Unexpected error: %v <> %v", err, errWriterClosed)
+	}
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s<>Hello", b)
+	}
+
+	conn.Close()
+	s.Shutdown()
+	ln.Close()
+}
+
+func TestConnReadFromWriteTo(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	data := bytes.Repeat([]byte("fastws"), DefaultWriterFrameSize)
+	errReader := errors.New("reader failed")
+
+	go func() {
+		// hide bytes.Reader.WriteTo so io.Copy uses client.ReadFrom.
+		n, err := io.Copy(client, struct{ io.Reader }{bytes.NewReader(data)})
+		if err != nil || n != int64(len(data)) {
+			panic(fmt.Sprintf("Unexpected copy: %d %v", n, err))
+		}
+		_, err = client.ReadFrom(io.MultiReader(strings.NewReader("Hello"), errorReader{errReader}))
+		if err != errReader {
+			panic(fmt.Sprintf("Unexpected error: %v <> %v", err, errReader))
+		}
+		client.Close()
+	}()
+
+	var bf bytes.Buffer
+	n, err := io.Copy(&bf, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)+5) || !bytes.Equal(bf.Bytes(), append(data, "Hello"...)) {
+		t.Fatalf("Unexpected data: %d bytes <> %d bytes", n, len(data)+5)
+	}
+}
+
+func TestConnReadFromInteractive(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go client.ReadFrom(pr)
+
+	pw.Write([]byte("Hello"))
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s <> Hello", b)
+	}
+
+	// the other writers are not blocked while waiting for the reader.
+	go client.WriteString("World")
+	_, b, err = server.ReadMessage(b[:0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "World" {
+		t.Fatalf("Unexpected message: %s <> World", b)
+	}
+}
+
+// errorReader is a reader always failing with err.
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(b []byte) (int, error) { return 0, r.err }
+
+// pipeTransport hides the net.Conn methods not needed by the transport.
+type pipeTransport struct {
+	c net.Conn
+}
+
+func (p *pipeTransport) Read(b []byte) (int, error)         { return p.c.Read(b) }
+func (p *pipeTransport) Write(b []byte) (int, error)        { return p.c.Write(b) }
+func (p *pipeTransport) Close() error                       { return p.c.Close() }
+func (p *pipeTransport) SetDeadline(t time.Time) error      { return p.c.SetDeadline(t) }
+func (p *pipeTransport) SetReadDeadline(t time.Time) error  { return p.c.SetReadDeadline(t) }
+func (p *pipeTransport) SetWriteDeadline(t time.Time) error { return p.c.SetWriteDeadline(t) }
+
+// pipeConns returns a server and a client connected through an in-memory pipe.
+func pipeConns() (server, client *Conn) {
+	c1, c2 := net.Pipe()
+
+	server = acquireConn(c1)
+	server.server = true
+	client = acquireConn(c2)
+
+	return server, client
+}
+
+func TestConnTransport(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	server := acquireConn(&pipeTransport{c1})
+	server.server = true
+	client := acquireConn(&pipeTransport{c2})
+
+	if server.LocalAddr() != nil || client.RemoteAddr() != nil {
+		t.Fatal("Unexpected address in a transport without addresses")
+	}
+	if server.NetConn() != nil {
+		t.Fatal("Unexpected net.Conn in a transport not being a net.Conn")
+	}
+
+	go func() {
+		_, b, err := server.ReadMessage(nil)
+		if err != nil {
+			panic(err)
+		}
+		server.Write(b)
+		// replies to the client's close
+		server.ReadMessage(nil)
+	}()
+
+	_, err := client.WriteString("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s<>Hello", b)
+	}
+
+	client.Close()
+}
+
+func TestUserValues(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			for j := 0; j < 100; j++ {
+				server.SetUserValue(key, j)
+				server.UserValue(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	server.SetUserValueBytes([]byte("bytes"), "value")
+	if v := server.UserValue("bytes"); v != "value" {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+	if v := server.UserValueBytes([]byte("key0")); v != 99 {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+
+	server.DeleteUserValue("key1")
+	server.DeleteUserValueBytes([]byte("key2"))
+
+	keys := make(map[string]bool)
+	server.VisitUserValues(func(key string, value interface{}) {
+		keys[key] = true
+	})
+	if len(keys) != 3 || !keys["key0"] || !keys["key3"] || !keys["bytes"] {
+		t.Fatalf("Unexpected keys: %v", keys)
+	}
+}
+
+func TestNetConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	server := acquireConn(c1)
+	server.server = true
+	client := acquireConn(c2)
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	if server.NetConn() != c1 || client.NetConn() != c2 {
+		t.Fatal("Unexpected net.Conn")
+	}
+}
+
+func TestNextReader(t *testing.T) {
+	text := bytes.Repeat([]byte("fastws"), DefaultWriterFrameSize/2)
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			m, r, err := conn.NextReader()
+			if err != nil {
+				panic(err)
+			}
+			if m != ModeBinary {
+				panic("Unexpected code: Not ModeBinary")
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				panic(err)
+			}
+			if !bytes.Equal(b, text) {
+				panic(fmt.Sprintf("Unexpected message length: %d <> %d", len(b), len(text)))
+			}
+			conn.WriteString("Hello")
+		}),
+	}
+	go s.Serve(ln)
+
+	conn := openConn(t, ln)
+
+	w, err := conn.NextWriter(ModeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, r, err := conn.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s<>Hello", b)
+	}
+
+	conn.Close()
+	s.Shutdown()
+	ln.Close()
+}
+
+func TestSendCodeControlTooBig(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	conn := acquireConn(c1)
+	defer conn.mustClose(false)
+
+	err := conn.SendCode(CodePing, 0, make([]byte, 126))
+	if err != errControlTooBig {
+		t.Fatalf("Unexpected error: %v <> %v", err, errControlTooBig)
+	}
+	err = conn.SendCode(CodeClose, StatusNone, make([]byte, 124))
+	if !errors.Is(err, ErrInvalidCloseReason) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrInvalidCloseReason)
+	}
+}
+
+func TestCloseWithCode(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			conn.CloseWithCode(StatusGoAway, "Restarting")
+		}),
+	}
+	go s.Serve(ln)
+
+	conn := openConn(t, ln)
+
+	fr, err := conn.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fr.IsClose() {
+		t.Fatal("Unexpected frame: no close")
+	}
+	if fr.Status() != StatusGoAway {
+		t.Fatalf("Status unexpected: %d <> %d", fr.Status(), StatusGoAway)
+	}
+	if p := fr.Payload(); string(p) != "Restarting" {
+		t.Fatalf("Unexpected payload: %s <> Restarting", p)
+	}
+	err = conn.SendCode(CodeClose, fr.Status(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ReleaseFrame(fr)
+
+	conn.mustClose(false)
+	s.Shutdown()
+	ln.Close()
+}
+
+func TestReadMessageCloseError(t *testing.T) {
+	server, client := pipeConns()
+
+	go client.CloseWithCode(StatusViolation, "Policy")
+
+	_, _, err := server.ReadMessage(nil)
+	if !errors.Is(err, EOF) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var cerr *CloseError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+	if cerr.Status != StatusViolation || cerr.Reason != "Policy" {
+		t.Fatalf("Unexpected close: %d %s <> %d Policy", cerr.Status, cerr.Reason, StatusViolation)
+	}
+}
+
+func TestReadMessageMaskedClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	server := acquireConn(c1)
+	server.server = true
+	// the status and the reason are validated once unmasked.
+	server.Strict = true
+
+	go func() {
+		c2.Write(maskedClose(StatusNone, "bye"))
+		// reads the reply.
+		io.Copy(ioutil.Discard, c2)
+	}()
+
+	_, _, err := server.ReadMessage(nil)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cerr.Status != StatusNone || cerr.Reason != "bye" {
+		t.Fatalf("Unexpected close: %d %q <> %d bye", cerr.Status, cerr.Reason, StatusNone)
+	}
+}
+
+func TestNextWriterInterleavedControl(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	w, err := client.NextWriter(ModeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fills a frame to send the first fragment
+	_, err = w.Write(make([]byte, DefaultWriterFrameSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.WriteString("Other")
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	err = client.SendCode(CodePing, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	expected := []Code{CodeText, CodePing, CodeContinuation, CodeText}
+	for _, code := range expected {
+		fr, err := server.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fr.Code() != code {
+			t.Fatalf("Unexpected code: %d <> %d", fr.Code(), code)
+		}
+		ReleaseFrame(fr)
+	}
+}
+
+func TestReadMessageFragmentedMode(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	text := make([]byte, DefaultWriterFrameSize*2)
+	go func() {
+		w, _ := client.NextWriter(ModeBinary)
+		w.Write(text)
+		w.Close()
+	}()
+
+	m, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != ModeBinary {
+		t.Fatal("Unexpected code: Not ModeBinary")
+	}
+	if len(b) != len(text) {
+		t.Fatalf("Unexpected message length: %d <> %d", len(b), len(text))
+	}
+}
+
+func writeFragments(conn *Conn, fragments ...string) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	for i, p := range fragments {
+		fr.Reset()
+		if i == 0 {
+			fr.SetText()
+		} else {
+			fr.SetContinuation()
+		}
+		if i == len(fragments)-1 {
+			fr.SetFin()
+		}
+		fr.SetPayload([]byte(p))
+		fr.Mask()
+		conn.WriteFrame(fr)
+	}
+}
+
+func TestReadMessageLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(conn *Conn)
+		err   error
+	}{
+		{"fragments", func(conn *Conn) { conn.MaxFragments = 2 }, errTooManyFragments},
+		{"size", func(conn *Conn) { conn.MaxMessageSize = 10 }, errMessageTooBig},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := pipeConns()
+			defer client.mustClose(false)
+			defer server.mustClose(false)
+			tc.setup(server)
+
+			go writeFragments(client, "Hello", " world", "!!")
+
+			_, _, err := server.ReadMessage(nil)
+			if err != tc.err {
+				t.Fatalf("Unexpected error: %v <> %v", err, tc.err)
+			}
+			if !errors.Is(err, ErrTooBig) {
+				t.Fatalf("%v must match %v", err, ErrTooBig)
+			}
+
+			fr, err := client.NextFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fr.IsClose() || fr.Status() != StatusTooBig {
+				t.Fatalf("Unexpected frame: %d %d", fr.Code(), fr.Status())
+			}
+			ReleaseFrame(fr)
+		})
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		kind error
+	}{
+		{errLenTooBig, ErrTooBig},
+		{errInvalidUTF8, ErrInvalidData},
+		{errStatusLen, ErrInvalidData},
+		{ErrUnsolicitedPong, ErrProtocol},
+		{fmt.Errorf("%w: %d", errReservedOpcode, 3), ErrProtocol},
+		{&CloseError{Status: StatusGoAway}, ErrConnectionClosed},
+		{&CloseError{Status: StatusGoAway}, EOF},
+	} {
+		if !errors.Is(tc.err, tc.kind) {
+			t.Fatalf("%v must match %v", tc.err, tc.kind)
+		}
+	}
+	if errors.Is(errInvalidUTF8, ErrProtocol) {
+		t.Fatalf("%v must not match %v", errInvalidUTF8, ErrProtocol)
+	}
+}
+
+func TestErrorsAfterClose(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	go client.ReadMessage(nil)
+	server.mustClose(false)
+
+	_, werr := server.WriteString("Hello")
+	_, nerr := server.NextWriter(ModeText)
+	_, _, rerr := server.ReadMessage(nil)
+	for _, err := range []error{werr, nerr, rerr, server.Close()} {
+		if !errors.Is(err, ErrConnectionClosed) || !errors.Is(err, EOF) {
+			t.Fatalf("%v must match %v and %v", err, ErrConnectionClosed, EOF)
+		}
+	}
+}
+
+func TestReadMessageStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		write  func(conn *Conn)
+		status StatusCode
+	}{
+		{"utf8", func(conn *Conn) {
+			conn.WriteString("\xff\xfe")
+		}, StatusNotConsistent},
+		{"rsv", func(conn *Conn) {
+			fr := AcquireFrame()
+			fr.SetFin()
+			fr.SetText()
+			fr.SetRSV1()
+			fr.SetPayload([]byte("Hello"))
+			fr.Mask()
+			conn.WriteFrame(fr)
+			ReleaseFrame(fr)
+		}, StatusProtocolError},
+		{"continuation", func(conn *Conn) {
+			fr := AcquireFrame()
+			fr.SetFin()
+			fr.SetContinuation()
+			fr.SetPayload([]byte("Hello"))
+			fr.Mask()
+			conn.WriteFrame(fr)
+			ReleaseFrame(fr)
+		}, StatusProtocolError},
+		{"close status", func(conn *Conn) {
+			conn.SendCode(CodeClose, 1005, nil)
+		}, StatusProtocolError},
+		{"close status 0", func(conn *Conn) {
+			conn.c.Write(maskedClose(0, ""))
+		}, StatusProtocolError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := pipeConns()
+			defer client.mustClose(false)
+			defer server.mustClose(false)
+			server.Strict = true
+
+			go tc.write(client)
+
+			_, _, err := server.ReadMessage(nil)
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+
+			fr, err := client.NextFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fr.IsClose() || fr.Status() != tc.status {
+				t.Fatalf("Unexpected frame: %d %d <> %d", fr.Code(), fr.Status(), tc.status)
+			}
+			ReleaseFrame(fr)
+		})
+	}
+}
+
+func TestReadMessageStrictFragmentedUTF8(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+	server.Strict = true
+
+	// the multi-byte characters are split between the fragments.
+	go writeFragments(client, "Hell\xc3", "\xb6 w\xc3\xb6", "rld")
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hellö wörld" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+}
+
+func TestSetMaskSource(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	key := []byte("abcd")
+	client.SetMaskSource(func(b []byte) {
+		copy(b, key)
+	})
+
+	go client.WriteString("Hello")
+
+	fr, err := server.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseFrame(fr)
+
+	if !bytes.Equal(fr.MaskKey(), key) {
+		t.Fatalf("Unexpected mask key: %v <> %v", fr.MaskKey(), key)
+	}
+	fr.Unmask()
+	if string(fr.Payload()) != "Hello" {
+		t.Fatalf("Unexpected payload: %s", fr.Payload())
+	}
+}
+
+func TestReadMessageTiny(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	big := strings.Repeat("a", tinyMessageSize+1)
+	go func() {
+		client.WriteMessage(ModeBinary, []byte("tiny"))
+		client.WriteString(big)
+		client.WriteString("tiny again")
+	}()
+
+	for _, expected := range []struct {
+		mode Mode
+		msg  string
+	}{
+		{ModeBinary, "tiny"},
+		{ModeText, big},
+		{ModeText, "tiny again"},
+	} {
+		mode, b, err := server.ReadMessage([]byte("prefix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != expected.mode || string(b) != "prefix"+expected.msg {
+			t.Fatalf("Unexpected message: %d %.20s <> %d %.20s", mode, b, expected.mode, expected.msg)
+		}
+	}
+
+	// the limits are checked for the tiny messages too.
+	server.MaxMessageSize = 2
+	go client.WriteString("tiny")
+
+	_, _, err := server.ReadMessage(nil)
+	if err != errMessageTooBig {
+		t.Fatalf("Unexpected error: %v <> %v", err, errMessageTooBig)
+	}
+}
+
+func TestSetPingHandler(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var pings []string
+	server.SetPingHandler(func(payload []byte) error {
+		pings = append(pings, string(payload))
+		return server.SendCode(CodePong, 0, []byte("custom"))
+	})
+
+	go func() {
+		client.SendCode(CodePing, 0, []byte("ping"))
+		client.WriteString("Hello")
+	}()
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+	if len(pings) != 1 || pings[0] != "ping" {
+		t.Fatalf("Unexpected pings: %v", pings)
+	}
+
+	fr, err := client.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseFrame(fr)
+	if !fr.IsPong() || string(fr.Payload()) != "custom" {
+		t.Fatalf("Unexpected frame: %d %s", fr.Code(), fr.Payload())
+	}
+}
+
+func TestCloseInvalidReason(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	err := server.CloseWithCode(StatusNone, strings.Repeat("a", MaxCloseReasonSize+1))
+	if !errors.Is(err, ErrInvalidCloseReason) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = server.SendCode(CodeClose, StatusNone, []byte("\xff"))
+	if !errors.Is(err, ErrInvalidCloseReason) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the connection is still open
+	go server.WriteString("Hello")
+
+	_, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+}
+
+func TestSetPongHandler(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	errPong := errors.New("unexpected pong")
+	var pongs []string
+	server.SetPongHandler(func(payload []byte) error {
+		if string(payload) == "bad" {
+			return errPong
+		}
+		pongs = append(pongs, string(payload))
+		return nil
+	})
+
+	go func() {
+		client.SendCode(CodePong, 0, []byte("pong"))
+		client.WriteString("Hello")
+		client.SendCode(CodePong, 0, []byte("bad"))
+	}()
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+	if len(pongs) != 1 || pongs[0] != "pong" {
+		t.Fatalf("Unexpected pongs: %v", pongs)
+	}
+
+	_, _, err = server.ReadMessage(nil)
+	if err != errPong {
+		t.Fatalf("Unexpected error: %v <> %v", err, errPong)
+	}
+}
+
+func TestSetCloseHandler(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var status StatusCode
+	var reason string
+	server.SetCloseHandler(func(s StatusCode, b []byte) error {
+		status, reason = s, string(b)
+		return server.SendCode(CodeClose, StatusGoAway, []byte("Restarting"))
+	})
+
+	go client.SendCode(CodeClose, StatusNone, []byte("Bye"))
+
+	_, _, err := server.ReadMessage(nil)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) || cerr.Status != StatusNone || cerr.Reason != "Bye" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status != StatusNone || reason != "Bye" {
+		t.Fatalf("Unexpected close: %d %s", status, reason)
+	}
+
+	fr, err := client.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseFrame(fr)
+	if !fr.IsClose() || fr.Status() != StatusGoAway || string(fr.Payload()) != "Restarting" {
+		t.Fatalf("Unexpected frame: %d %d %s", fr.Code(), fr.Status(), fr.Payload())
+	}
+}
+
+func TestPing(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	// nobody reads from server, so the PING is not answered.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	_, err := client.Ping(ctx, nil)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.DeadlineExceeded)
+	}
+
+	go server.ReadMessage(nil)
+
+	for _, payload := range [][]byte{nil, []byte("payload")} {
+		rtt, err := client.Ping(context.Background(), payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtt <= 0 {
+			t.Fatalf("Unexpected RTT: %s", rtt)
+		}
+	}
+	if len(client.pings) != 0 {
+		t.Fatalf("Unexpected pending pings: %d", len(client.pings))
+	}
+}
+
+func TestConnRead(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go func() {
+		client.WriteString("Hello ")
+		client.WriteString("")
+		client.WriteString("world\nBye\n")
+		client.SendCode(CodeClose, StatusNone, nil)
+	}()
+
+	// small buffer to read the messages in many calls.
+	b := make([]byte, 3)
+	var msg []byte
+	for {
+		n, err := server.Read(b)
+		msg = append(msg, b[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatal("Read returned no data")
+		}
+	}
+	if string(msg) != "Hello world\nBye\n" {
+		t.Fatalf("Unexpected data: %q", msg)
+	}
+}
+
+func TestWriteMessageNoCopy(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	b := []byte("Hello world")
+	written := make(chan struct{})
+	go func() {
+		if _, err := client.WriteMessageNoCopy(ModeBinary, b); err != nil {
+			panic(err)
+		}
+		close(written)
+		server.WriteMessageNoCopy(ModeText, []byte("Bye"))
+	}()
+
+	mode, msg, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != ModeBinary || string(msg) != "Hello world" {
+		t.Fatalf("Unexpected message: %v %q", mode, msg)
+	}
+	<-written
+	if string(b) != "Hello world" {
+		t.Fatalf("The buffer was not restored: %q", b)
+	}
+
+	_, msg, err = client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "Bye" {
+		t.Fatalf("Unexpected message: %q <> Bye", msg)
+	}
+}
+
+func TestConnTunnel(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var c net.Conn = server
+
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err := c.Read(make([]byte, 8))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	data := strings.Repeat("Hello world\n", 1000)
+	go func(c net.Conn) {
+		io.Copy(c, strings.NewReader(data))
+		c.Close()
+	}(client)
+
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Fatalf("Unexpected data: %d bytes <> %d bytes", len(b), len(data))
+	}
+}
+
+func TestConnReadAbnormalClosure(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go client.SendCode(CodeClose, StatusProtocolError, nil)
+
+	_, err := ioutil.ReadAll(server)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) || cerr.Status != StatusProtocolError {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnIsClosedErr(t *testing.T) {
+	server, client := pipeConns()
+	defer server.mustClose(false)
+
+	if server.IsClosed() || server.Err() != nil {
+		t.Fatalf("Unexpected state of an open connection: %v %v", server.IsClosed(), server.Err())
+	}
+
+	// the transport is closed without close handshake.
+	client.mustClose(false)
+	<-server.readDone
+
+	if !server.IsClosed() {
+		t.Fatal("Expected closed connection")
+	}
+	if err := server.Err(); err != io.EOF {
+		t.Fatalf("Unexpected error: %v <> %v", err, io.EOF)
+	}
+}
+
+func TestConnErrCloseError(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go client.SendCode(CodeClose, StatusProtocolError, nil)
+
+	if _, _, err := server.ReadMessage(nil); err == nil {
+		t.Fatal("Expected error")
+	}
+	if !server.IsClosed() {
+		t.Fatal("Expected closed connection")
+	}
+	var cerr *CloseError
+	if err := server.Err(); !errors.As(err, &cerr) || cerr.Status != StatusProtocolError {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnOnClose(t *testing.T) {
+	server, client := pipeConns()
+
+	closed := make(chan error, 2)
+	server.OnClose(func(err error) {
+		closed <- err
+	})
+	client.OnClose(func(err error) {
+		closed <- err
+	})
+
+	go client.SendCode(CodeClose, StatusGoAway, nil)
+	_, _, err := server.ReadMessage(nil)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.mustClose(false)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-closed:
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnClose not called")
+		}
+	}
+
+	// the hook is called once.
+	server.Close()
+	select {
+	case err := <-closed:
+		t.Fatalf("Unexpected call: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// set after terminating.
+	server.OnClose(func(err error) {
+		closed <- err
+	})
+	if err := <-closed; !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnCloseResult(t *testing.T) {
+	// the peer replies to the close frame.
+	server, client := pipeConns()
+	if r := client.CloseResult(); r != CloseNotClosed {
+		t.Fatalf("Unexpected result: %s", r)
+	}
+	go server.ReadMessage(nil)
+	client.Close()
+	if r := client.CloseResult(); r != CloseHandshake {
+		t.Fatalf("Unexpected result: %s <> %s", r, CloseHandshake)
+	}
+	if r := server.CloseResult(); r != ClosePeer {
+		t.Fatalf("Unexpected result: %s <> %s", r, ClosePeer)
+	}
+
+	// nobody replies.
+	server, client = pipeConns()
+	client.CloseTimeout = time.Millisecond * 50
+	start := time.Now()
+	client.Close()
+	if r := client.CloseResult(); r != CloseTimedOut {
+		t.Fatalf("Unexpected result: %s <> %s", r, CloseTimedOut)
+	}
+	if time.Since(start) < client.CloseTimeout {
+		t.Fatal("Closed before the timeout")
+	}
+	server.mustClose(false)
+
+	// without waiting for the reply.
+	server, client = pipeConns()
+	client.CloseTimeout = -1
+	start = time.Now()
+	client.Close()
+	if r := client.CloseResult(); r != CloseFast {
+		t.Fatalf("Unexpected result: %s <> %s", r, CloseFast)
+	}
+	if d := time.Since(start); d > time.Millisecond*50 {
+		t.Fatalf("Unexpected close duration: %s", d)
+	}
+	server.mustClose(false)
+}
+
+func TestSetOpcodeHandler(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	if err := server.SetOpcodeHandler(CodeText, nil); err != ErrOpcodeNotReserved {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrOpcodeNotReserved)
+	}
+
+	server.Strict = true
+	var custom []string
+	err := server.SetOpcodeHandler(0x5, func(fr *Frame) error {
+		custom = append(custom, string(fr.Payload()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		fr := AcquireFrame()
+		defer ReleaseFrame(fr)
+
+		fr.SetFin()
+		fr.SetCode(0x5)
+		fr.SetPayload([]byte("seq=1"))
+		fr.Mask()
+		client.WriteFrame(fr)
+
+		client.WriteString("Hello")
+	}()
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %q <> %q", b, "Hello")
+	}
+	if len(custom) != 1 || custom[0] != "seq=1" {
+		t.Fatalf("Unexpected custom frames: %q", custom)
+	}
+}
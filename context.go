@@ -0,0 +1,79 @@
+package fastws
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errInterrupted is returned when the done channel of a read or write is closed.
+var errInterrupted = errors.New("i/o interrupted")
+
+// isInterruption reports whether err interrupted a read leaving the connection usable.
+func isInterruption(err error) bool {
+	return err == errDeadlineExceeded || err == errInterrupted
+}
+
+// ReadMessageContext reads the next message as ReadMessage does,
+// returning ctx.Err() if ctx is done before the message is received.
+//
+// As with the read deadline, the connection is left open when ctx is done
+// while waiting for the first frame of a message, otherwise the partial
+// message is lost and the connection is closed.
+func (conn *Conn) ReadMessageContext(ctx context.Context, b []byte) (Mode, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return ModeText, b, err
+	}
+
+	conn.beginRead()
+	defer conn.endRead()
+
+	mode, b, err := conn.read(ctx.Done(), b)
+	if err == errInterrupted {
+		err = ctx.Err()
+	}
+
+	return mode, b, err
+}
+
+// WriteMessageContext writes b using mode as WriteMessage does,
+// returning ctx.Err() if ctx is done before the message is written.
+//
+// Waiting for other writers to finish is not interrupted.
+// A write interrupted while in progress can leave a partial frame,
+// so the connection must be closed.
+func (conn *Conn) WriteMessageContext(ctx context.Context, mode Mode, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := conn.write(ctx.Done(), mode, b)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	return n, err
+}
+
+// interruptWrite sets a past write deadline on the underlying connection
+// when done is closed. The returned function stops the watcher,
+// waiting for it to exit.
+func (conn *Conn) interruptWrite(done <-chan struct{}) func() {
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+
+		select {
+		case <-done:
+			conn.getTransport().SetWriteDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-exited
+	}
+}
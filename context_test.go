@@ -0,0 +1,64 @@
+package fastws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadMessageContext(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	_, _, err := server.ReadMessageContext(ctx, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.DeadlineExceeded)
+	}
+	if server.isClosed() {
+		t.Fatal("The connection must not be closed")
+	}
+
+	// a context already done fails immediately.
+	_, _, err = server.ReadMessageContext(ctx, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.DeadlineExceeded)
+	}
+
+	go client.WriteString("Hello")
+
+	_, b, err := server.ReadMessageContext(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+}
+
+func TestWriteMessageContext(t *testing.T) {
+	server, peer := blockedConn()
+	defer peer.Close()
+	defer server.mustClose(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*10, cancel)
+
+	// nobody reads peer, so the write blocks until ctx is cancelled.
+	start := time.Now()
+	_, err := server.WriteMessageContext(ctx, ModeText, []byte("Hello"))
+	if err != context.Canceled {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("The write was not interrupted: %s", d)
+	}
+
+	_, err = server.WriteMessageContext(ctx, ModeText, []byte("Hello"))
+	if err != context.Canceled {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,134 @@
+package fastws
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutError is returned when a read times out, either by
+// ReadTimeout or by the read deadline, and when the Dialer.HandshakeTimeout expires.
+//
+// TimeoutError implements net.Error, so the timeouts can be detected
+// checking the Timeout method.
+type TimeoutError struct {
+	msg string
+	// temporary is set if the connection is left open.
+	temporary bool
+}
+
+func (e *TimeoutError) Error() string {
+	return e.msg
+}
+
+// Timeout returns true.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary returns whether the connection is still usable, which is only
+// when the read deadline expires while waiting for the first frame of a message.
+// The timeouts closing the connection (ReadTimeout, the read deadline expiring
+// in the middle of a message and the handshake timeout) are not temporary.
+func (e *TimeoutError) Temporary() bool {
+	return e.temporary
+}
+
+var (
+	// errDeadlineExceeded is returned when the read deadline expires.
+	errDeadlineExceeded = &TimeoutError{"i/o deadline exceeded", true}
+	// errDeadlineClosed is returned when the read deadline expires
+	// in the middle of a message, closing the connection.
+	errDeadlineClosed = &TimeoutError{"i/o deadline exceeded", false}
+	// errReadTimeout is returned when ReadTimeout expires.
+	errReadTimeout = &TimeoutError{"i/o timeout", false}
+	// errHandshakeTimeout is returned when Dialer.HandshakeTimeout expires.
+	errHandshakeTimeout = &TimeoutError{"handshake timeout", false}
+)
+
+// SetDeadline sets the read and write deadlines as
+// SetReadDeadline and SetWriteDeadline do.
+func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.SetReadDeadline(t)
+	return conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for the read functions.
+// A zero value for t means the reads will not time out by the deadline,
+// though ReadTimeout still applies.
+//
+// The deadline is checked while waiting for the frames received by the readLoop,
+// so the connection keeps reading (and replying to the control frames) when
+// it expires. When waiting for the first frame of a message the connection is
+// left open as net.Conn does, otherwise the partial message is lost and
+// the connection is closed.
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	atomic.StoreInt64(&conn.readDeadline, deadlineNanos(t))
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for the write functions.
+// A zero value for t means the writes will not time out by the deadline,
+// though WriteTimeout still applies.
+//
+// The deadline is applied to the underlying connection while writing,
+// so after a write timing out the connection must be closed.
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	atomic.StoreInt64(&conn.writeDeadline, deadlineNanos(t))
+	return nil
+}
+
+func deadlineNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// nearest returns the nearest time between now+timeout and the deadline d (in Unix nanoseconds).
+// The zero time is returned when there's no timeout nor deadline.
+func nearest(now time.Time, timeout time.Duration, d int64) (t time.Time, deadline bool) {
+	if timeout > 0 {
+		t = now.Add(timeout)
+	}
+	if d != 0 {
+		if dt := time.Unix(0, d); t.IsZero() || dt.Before(t) {
+			t, deadline = dt, true
+		}
+	}
+	return t, deadline
+}
+
+// ErrPeerDead is the error which closes the connection when
+// MaxWriteTimeouts consecutive writes time out.
+var ErrPeerDead = errors.New("peer is dead: consecutive write timeouts")
+
+// countWriteTimeout counts the consecutive write timeouts,
+// marking the peer as dead when they reach MaxWriteTimeouts.
+func (conn *Conn) countWriteTimeout(err error) {
+	if conn.MaxWriteTimeouts <= 0 {
+		return
+	}
+
+	var nerr net.Error
+	if err == nil || !errors.As(err, &nerr) || !nerr.Timeout() {
+		atomic.StoreInt32(&conn.writeTimeouts, 0)
+		return
+	}
+	if int(atomic.AddInt32(&conn.writeTimeouts, 1)) >= conn.MaxWriteTimeouts {
+		atomic.CompareAndSwapUint32(&conn.peerDead, 0, 1)
+	}
+}
+
+// closeIfPeerDead closes the connection once the peer has been marked as dead.
+// It must be called without holding the write lock.
+func (conn *Conn) closeIfPeerDead() {
+	if atomic.LoadUint32(&conn.peerDead) != 1 ||
+		!atomic.CompareAndSwapUint32(&conn.peerDead, 1, 2) {
+		return
+	}
+	// the peer isn't reading, so the close frame is not sent.
+	conn.setReadError(ErrPeerDead)
+	conn.mustClose(false)
+}
@@ -0,0 +1,159 @@
+package fastws
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetReadDeadline(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+
+	_, _, err := server.ReadMessage(nil)
+	if err != errDeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, errDeadlineExceeded)
+	}
+	if server.isClosed() {
+		t.Fatal("The connection must not be closed")
+	}
+
+	// an expired deadline fails immediately.
+	_, _, err = server.ReadMessage(nil)
+	if err != errDeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, errDeadlineExceeded)
+	}
+
+	server.SetReadDeadline(time.Time{})
+	go client.WriteString("Hello")
+
+	_, b, err := server.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s", b)
+	}
+}
+
+func TestSetWriteDeadline(t *testing.T) {
+	server, peer := blockedConn()
+	defer peer.Close()
+	defer server.mustClose(false)
+
+	// nobody reads peer, so the write blocks until the deadline.
+	server.SetWriteDeadline(time.Now().Add(time.Millisecond * 10))
+
+	start := time.Now()
+	_, err := server.WriteString("Hello")
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("The deadline was not applied: %s", d)
+	}
+}
+
+func TestReadTimeoutError(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.ReadTimeout = time.Millisecond * 10
+
+	_, _, err := server.ReadMessage(nil)
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() || nerr.Temporary() {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	server, client = pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetReadDeadline(time.Now())
+
+	_, _, err = server.ReadMessage(nil)
+	if !errors.As(err, &nerr) || !nerr.Timeout() || !nerr.Temporary() {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestReadDeadlinePartialMessage(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	// the message is never finished.
+	go func() {
+		fr := AcquireFrame()
+		fr.SetText()
+		fr.SetPayload([]byte("Hel"))
+		client.WriteFrame(fr)
+		ReleaseFrame(fr)
+		client.ReadMessage(nil)
+	}()
+
+	server.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+
+	_, _, err := server.ReadMessage(nil)
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() || nerr.Temporary() {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !server.isClosed() {
+		t.Fatal("The connection must be closed")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	now := time.Now()
+	d := now.Add(time.Second)
+
+	for _, tc := range []struct {
+		timeout  time.Duration
+		d        int64
+		t        time.Time
+		deadline bool
+	}{
+		{0, 0, time.Time{}, false},
+		{time.Minute, 0, now.Add(time.Minute), false},
+		{0, d.UnixNano(), d, true},
+		{time.Minute, d.UnixNano(), d, true},
+		{time.Millisecond, d.UnixNano(), now.Add(time.Millisecond), false},
+	} {
+		tt, deadline := nearest(now, tc.timeout, tc.d)
+		if !tt.Equal(tc.t) || deadline != tc.deadline {
+			t.Fatalf("Unexpected time: %s %v <> %s %v", tt, deadline, tc.t, tc.deadline)
+		}
+	}
+}
+
+func TestMaxWriteTimeouts(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close() // never read, so the writes time out.
+
+	conn := acquireConn(c1)
+	conn.server = true
+	conn.WriteTimeout = time.Millisecond * 20
+	conn.MaxWriteTimeouts = 2
+
+	for i := 0; i < 2; i++ {
+		_, err := conn.WriteString("Hello")
+		var nerr net.Error
+		if !errors.As(err, &nerr) || !nerr.Timeout() {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if _, err := conn.WriteString("Hello"); !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrConnectionClosed)
+	}
+	if err := conn.Err(); err != ErrPeerDead {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrPeerDead)
+	}
+}
@@ -0,0 +1,94 @@
+package fastws
+
+import (
+	"container/list"
+	"sync"
+)
+
+// IDExtractor returns the ID of the message b received using mode.
+//
+// If IDExtractor returns nil the message is never considered a duplicate.
+type IDExtractor func(mode Mode, b []byte) []byte
+
+// Deduplicator drops the messages whose ID has already been seen
+// within a window of the last IDs received.
+//
+// A Deduplicator can be shared by many connections, i.e. to drop the
+// messages replayed by a peer after a reconnection.
+type Deduplicator struct {
+	lck  sync.Mutex
+	id   IDExtractor
+	size int
+	ll   *list.List
+	ids  map[string]*list.Element
+}
+
+// NewDeduplicator creates a Deduplicator remembering the last size IDs
+// returned by id.
+func NewDeduplicator(size int, id IDExtractor) *Deduplicator {
+	if size <= 0 {
+		size = 1
+	}
+	return &Deduplicator{
+		id:   id,
+		size: size,
+		ll:   list.New(),
+		ids:  make(map[string]*list.Element, size),
+	}
+}
+
+// IsDuplicate returns whether the message b has been seen before,
+// recording it otherwise.
+func (d *Deduplicator) IsDuplicate(mode Mode, b []byte) bool {
+	id := d.id(mode, b)
+	if id == nil {
+		return false
+	}
+	return d.Seen(id)
+}
+
+// Seen returns whether id is inside the window, recording it otherwise.
+//
+// The least recently seen ID is evicted when the window is full.
+func (d *Deduplicator) Seen(id []byte) bool {
+	d.lck.Lock()
+	defer d.lck.Unlock()
+
+	if e, ok := d.ids[b2s(id)]; ok {
+		d.ll.MoveToFront(e)
+		return true
+	}
+
+	if d.ll.Len() >= d.size {
+		e := d.ll.Back()
+		delete(d.ids, e.Value.(string))
+		d.ll.Remove(e)
+	}
+	sid := string(id)
+	d.ids[sid] = d.ll.PushFront(sid)
+
+	return false
+}
+
+// Len returns the number of IDs inside the window.
+func (d *Deduplicator) Len() int {
+	d.lck.Lock()
+	n := d.ll.Len()
+	d.lck.Unlock()
+	return n
+}
+
+// Reset forgets all the IDs seen.
+func (d *Deduplicator) Reset() {
+	d.lck.Lock()
+	d.ll.Init()
+	d.ids = make(map[string]*list.Element, d.size)
+	d.lck.Unlock()
+}
+
+// SetDeduplicator makes ReadMessage drop the duplicated messages detected by d.
+//
+// d can be nil to disable deduplication.
+func (conn *Conn) SetDeduplicator(d *Deduplicator) {
+	conn.dedup = d
+}
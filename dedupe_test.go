@@ -0,0 +1,63 @@
+package fastws
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d := NewDeduplicator(2, nil)
+
+	if d.Seen([]byte("a")) || d.Seen([]byte("b")) {
+		t.Fatal("Unexpected duplicate")
+	}
+	if !d.Seen([]byte("a")) {
+		t.Fatal("a must be a duplicate")
+	}
+	// b is the least recently seen
+	if d.Seen([]byte("c")) {
+		t.Fatal("Unexpected duplicate")
+	}
+	if d.Len() != 2 {
+		t.Fatalf("Unexpected window length: %d <> 2", d.Len())
+	}
+	if d.Seen([]byte("b")) {
+		t.Fatal("b must have been evicted")
+	}
+
+	d.Reset()
+	if d.Len() != 0 || d.Seen([]byte("a")) {
+		t.Fatal("a must have been forgotten")
+	}
+}
+
+func TestConnDeduplicator(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetDeduplicator(NewDeduplicator(16, func(mode Mode, b []byte) []byte {
+		if n := bytes.IndexByte(b, ':'); n > 0 {
+			return b[:n]
+		}
+		return nil
+	}))
+
+	go func() {
+		for _, msg := range []string{"1:a", "1:a", "2:b", "no id", "no id", "1:a", "3:c"} {
+			client.WriteString(msg)
+		}
+	}()
+
+	var b []byte
+	var err error
+	for _, expected := range []string{"1:a", "2:b", "no id", "no id", "3:c"} {
+		_, b, err = server.ReadMessage(b[:0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Unexpected message: %s <> %s", b, expected)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package fastws
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// deflateOffer is the permessage-deflate offer (RFC 7692) sent by the clients.
+//
+// The server is asked not to keep the compression context between messages,
+// so every message can be decompressed on its own.
+// The client doesn't compress, so its parameters are not offered.
+const deflateOffer = "permessage-deflate; server_no_context_takeover"
+
+// acceptDeflate parses the extensions accepted by the server in response
+// to deflateOffer, returning whether the messages can be compressed.
+// offered is whether deflateOffer was sent.
+func acceptDeflate(header []byte, offered bool) (bool, error) {
+	exts, err := ParseExtensions(string(header))
+	if err != nil || len(exts) == 0 {
+		return false, err
+	}
+	if !offered || len(exts) > 1 || exts[0].Name != "permessage-deflate" {
+		return false, fmt.Errorf("%w: not offered %q", ErrInvalidExtension, header)
+	}
+
+	noContext := false
+	for _, param := range exts[0].Params {
+		switch param.Name {
+		case "server_no_context_takeover":
+			noContext = true
+		case "client_no_context_takeover":
+			// the client doesn't compress.
+		case "server_max_window_bits":
+			// any window is decompressed.
+			if bits, err := strconv.Atoi(param.Value); err != nil || bits < 8 || bits > 15 {
+				return false, fmt.Errorf("%w: server_max_window_bits %q", ErrInvalidExtension, param.Value)
+			}
+		default:
+			return false, fmt.Errorf("%w: permessage-deflate parameter %q", ErrInvalidExtension, param.Name)
+		}
+	}
+	if !noContext {
+		return false, fmt.Errorf("%w: server_no_context_takeover not accepted", ErrInvalidExtension)
+	}
+
+	return true, nil
+}
+
+// deflateTail is appended to the compressed messages, which have the tail
+// of the final flush removed (RFC 7692 section 7.2.2), followed by
+// an empty final block so the decompressor ends the stream.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var (
+	flateReaderPool   sync.Pool
+	errInvalidDeflate = newError(ErrInvalidData, "invalid compressed message")
+)
+
+// inflate decompresses the message b[start:] replacing it in b.
+// If max is greater than zero the message decompressed can't be bigger.
+func inflate(b []byte, start int, max uint64) ([]byte, error) {
+	src := append(bytePool.Get().([]byte)[:0], b[start:]...)
+	src = append(src, deflateTail...)
+	defer bytePool.Put(src)
+
+	br := bytes.NewReader(src)
+	fr, _ := flateReaderPool.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(br)
+	} else {
+		fr.(flate.Resetter).Reset(br, nil)
+	}
+	defer flateReaderPool.Put(fr)
+
+	b = b[:start]
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := fr.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if max > 0 && uint64(len(b)-start) > max {
+			return b, errMessageTooBig
+		}
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return b, fmt.Errorf("%w: %s", errInvalidDeflate, err)
+		}
+	}
+}
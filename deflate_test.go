@@ -0,0 +1,180 @@
+package fastws
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// deflate compresses b as a permessage-deflate message.
+func deflate(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(b)
+	fw.Flush()
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4])
+}
+
+func writeCompressed(conn *Conn, mode Mode, p []byte) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	fr.SetFin()
+	fr.SetRSV1()
+	if mode == ModeBinary {
+		fr.SetBinary()
+	} else {
+		fr.SetText()
+	}
+	fr.SetPayload(p)
+	conn.WriteFrame(fr)
+}
+
+func TestReadCompressed(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+	client.compress = true
+
+	msg := bytes.Repeat([]byte("Hello world "), 100)
+	go func() {
+		writeCompressed(server, ModeText, deflate(t, msg))
+		server.WriteString("uncompressed")
+	}()
+
+	mode, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != ModeText || !bytes.Equal(b, msg) {
+		t.Fatalf("Unexpected message: %v %q", mode, b)
+	}
+
+	_, b, err = client.ReadMessage(b[:0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "uncompressed" {
+		t.Fatalf("Unexpected message: %s <> uncompressed", b)
+	}
+}
+
+func TestCopyCompressed(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+	client.compress = true
+
+	msg := bytes.Repeat([]byte("Hello world "), 100)
+	go func() {
+		writeCompressed(server, ModeText, deflate(t, msg))
+		server.WriteString("uncompressed")
+		server.Close()
+	}()
+
+	var bf bytes.Buffer
+	n, err := io.Copy(&bf, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(msg, "uncompressed"...)
+	if n != int64(len(expected)) || !bytes.Equal(bf.Bytes(), expected) {
+		t.Fatalf("Unexpected payload (%d): %q", n, bf.Bytes())
+	}
+}
+
+func TestReadCompressedTooBig(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+	client.compress = true
+	client.MaxMessageSize = 64
+
+	go func() {
+		writeCompressed(server, ModeBinary, deflate(t, make([]byte, 1024)))
+		server.ReadMessage(nil)
+	}()
+
+	_, _, err := client.ReadMessage(nil)
+	if !errors.Is(err, ErrTooBig) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestAcceptDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		header   string
+		offered  bool
+		compress bool
+		valid    bool
+	}{
+		{"", false, false, true},
+		{"", true, false, true},
+		{"permessage-deflate; server_no_context_takeover", true, true, true},
+		{"permessage-deflate; server_no_context_takeover; server_max_window_bits=10; client_no_context_takeover", true, true, true},
+		{"permessage-deflate; server_no_context_takeover", false, false, false},
+		{"permessage-deflate", true, false, false},
+		{"permessage-deflate; server_no_context_takeover; client_max_window_bits=10", true, false, false},
+		{"permessage-deflate; server_no_context_takeover; server_max_window_bits=16", true, false, false},
+		{"x-custom", true, false, false},
+	} {
+		compress, err := acceptDeflate([]byte(tc.header), tc.offered)
+		if compress != tc.compress || (err == nil) != tc.valid {
+			t.Fatalf("Unexpected result for %q: %v %v", tc.header, compress, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidExtension) {
+			t.Fatalf("Unexpected error for %q: %v", tc.header, err)
+		}
+	}
+}
+
+func TestDialerCompress(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	msg := bytes.Repeat([]byte("Hello world "), 100)
+	upgrade := Upgrade(func(conn *Conn) {
+		writeCompressed(conn, ModeText, deflate(t, msg))
+		conn.ReadMessage(nil)
+	})
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			offer := string(ctx.Request.Header.PeekBytes(wsHeaderExtensions))
+			if offer != deflateOffer {
+				ctx.Error("unexpected offer", fasthttp.StatusBadRequest)
+				return
+			}
+			ctx.Response.Header.SetBytesK(wsHeaderExtensions, offer)
+			upgrade(ctx)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	d := Dialer{
+		Compress: true,
+	}
+	conn, err := d.Dial("ws://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg) {
+		t.Fatalf("Unexpected message: %q", b)
+	}
+}
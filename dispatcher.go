@@ -0,0 +1,212 @@
+package fastws
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// DefaultDispatchQueueSize is the default number of messages
+// a Dispatcher keeps pending before blocking the readers.
+const DefaultDispatchQueueSize = 1024
+
+// ErrDispatcherStopped is returned when dispatching into a stopped Dispatcher.
+var ErrDispatcherStopped = errors.New("dispatcher is stopped")
+
+// Dispatcher calls OnMessage for the messages read from the connections
+// using a bounded pool of goroutines.
+//
+// Reading and handling are decoupled, so one slow call to OnMessage
+// doesn't stall the read loop of the connection (the control frames
+// keep being processed). When too many messages are pending
+// the readers block until the workers catch up.
+//
+// If Ordered is true, the messages of a connection are handled one at a time
+// in the order they were received. The messages of different connections
+// are always handled concurrently.
+type Dispatcher struct {
+	// Workers is the number of goroutines calling OnMessage.
+	//
+	// By default Workers is runtime.NumCPU().
+	Workers int
+
+	// QueueSize is the max number of pending messages.
+	//
+	// By default QueueSize is DefaultDispatchQueueSize.
+	QueueSize int
+
+	// Ordered handles the messages of a connection in order.
+	Ordered bool
+
+	// OnMessage is called for every message.
+	//
+	// b is owned by the handler, so it can be retained after returning.
+	OnMessage func(conn *Conn, mode Mode, b []byte)
+
+	lck     sync.Mutex
+	cond    *sync.Cond
+	queues  map[*Conn]*dispatchQueue
+	ready   []*dispatchQueue
+	pending int
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type dispatchQueue struct {
+	conn      *Conn
+	msgs      []schedMessage
+	scheduled bool
+}
+
+// Start starts the Dispatcher workers.
+func (d *Dispatcher) Start() {
+	d.lck.Lock()
+	defer d.lck.Unlock()
+
+	if d.cond != nil {
+		return
+	}
+	d.cond = sync.NewCond(&d.lck)
+	d.queues = make(map[*Conn]*dispatchQueue)
+	d.stopped = false
+
+	n := d.Workers
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	d.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go d.worker()
+	}
+}
+
+// Stop stops the Dispatcher waiting for the running handlers to return.
+// Pending messages are discarded.
+func (d *Dispatcher) Stop() {
+	d.lck.Lock()
+	if d.cond == nil || d.stopped {
+		d.lck.Unlock()
+		return
+	}
+	d.stopped = true
+	d.queues = nil
+	d.ready = nil
+	d.pending = 0
+	d.cond.Broadcast()
+	d.lck.Unlock()
+
+	d.wg.Wait()
+
+	d.lck.Lock()
+	d.cond = nil
+	d.lck.Unlock()
+}
+
+// Dispatch queues the message b read from conn to be handled by OnMessage.
+//
+// Dispatch blocks while the queue is full. It returns ErrDispatcherStopped
+// if d is not started or has been stopped.
+func (d *Dispatcher) Dispatch(conn *Conn, mode Mode, b []byte) error {
+	d.lck.Lock()
+	defer d.lck.Unlock()
+
+	max := d.QueueSize
+	if max <= 0 {
+		max = DefaultDispatchQueueSize
+	}
+	for d.cond != nil && !d.stopped && d.pending >= max {
+		d.cond.Wait()
+	}
+	if d.cond == nil || d.stopped {
+		return ErrDispatcherStopped
+	}
+
+	msg := schedMessage{mode: mode, b: b}
+	d.pending++
+
+	if !d.Ordered {
+		d.ready = append(d.ready, &dispatchQueue{
+			conn: conn,
+			msgs: []schedMessage{msg},
+		})
+		d.cond.Broadcast()
+		return nil
+	}
+
+	q := d.queues[conn]
+	if q == nil {
+		q = &dispatchQueue{conn: conn}
+		d.queues[conn] = q
+	}
+	q.msgs = append(q.msgs, msg)
+
+	if !q.scheduled {
+		q.scheduled = true
+		d.ready = append(d.ready, q)
+		d.cond.Broadcast()
+	}
+
+	return nil
+}
+
+// Serve reads the messages of conn dispatching them
+// until an error happens reading or the Dispatcher is stopped.
+func (d *Dispatcher) Serve(conn *Conn) error {
+	for {
+		mode, b, err := conn.ReadMessage(nil)
+		if err != nil {
+			return err
+		}
+		if err = d.Dispatch(conn, mode, b); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+
+	d.lck.Lock()
+	for {
+		for len(d.ready) == 0 && !d.stopped {
+			d.cond.Wait()
+		}
+		if d.stopped {
+			break
+		}
+
+		q := d.ready[0]
+		d.ready[0] = nil
+		d.ready = d.ready[1:]
+
+		msg := q.msgs[0]
+		q.msgs[0] = schedMessage{}
+		q.msgs = q.msgs[1:]
+		d.pending--
+		// wake up the readers waiting for room.
+		d.cond.Broadcast()
+
+		d.lck.Unlock()
+		if d.OnMessage != nil {
+			d.OnMessage(q.conn, msg.mode, msg.b)
+		}
+		d.lck.Lock()
+
+		if d.stopped {
+			break
+		}
+		if !d.Ordered {
+			continue
+		}
+
+		// the next message of the connection goes to the end of the line.
+		if len(q.msgs) > 0 {
+			d.ready = append(d.ready, q)
+			d.cond.Broadcast()
+		} else {
+			q.scheduled = false
+			delete(d.queues, q.conn)
+		}
+	}
+	d.lck.Unlock()
+}
@@ -0,0 +1,117 @@
+package fastws
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherSlowHandler(t *testing.T) {
+	slowServer, slowClient := pipeConns()
+	fastServer, fastClient := pipeConns()
+	defer slowClient.mustClose(false)
+	defer fastClient.mustClose(false)
+
+	release := make(chan struct{})
+	handled := make(chan string, 10)
+	d := &Dispatcher{
+		Workers: 2,
+		Ordered: true,
+		OnMessage: func(conn *Conn, mode Mode, b []byte) {
+			if conn == slowServer {
+				<-release
+			}
+			handled <- string(b)
+		},
+	}
+	d.Start()
+	defer d.Stop()
+
+	go d.Serve(slowServer)
+	go d.Serve(fastServer)
+
+	for i := 0; i < 3; i++ {
+		if _, err := slowClient.WriteString("slow" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fastClient.WriteString("fast"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-handled:
+		if s != "fast" {
+			t.Fatalf("Unexpected message: %s <> fast", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The slow handler stalled the other connection")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		s := <-handled
+		if expected := "slow" + strconv.Itoa(i); s != expected {
+			t.Fatalf("Unexpected message: %s <> %s", s, expected)
+		}
+	}
+
+	slowServer.mustClose(false)
+	fastServer.mustClose(false)
+}
+
+func TestDispatcherUnordered(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	d := &Dispatcher{
+		Workers: 4,
+		OnMessage: func(conn *Conn, mode Mode, b []byte) {
+			// every handler waits for the others,
+			// so they must run concurrently.
+			wg.Done()
+			wg.Wait()
+		},
+	}
+	d.Start()
+	defer d.Stop()
+
+	go d.Serve(server)
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.WriteString("msg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The messages were not handled concurrently")
+	}
+
+	server.mustClose(false)
+}
+
+func TestDispatcherStopped(t *testing.T) {
+	d := &Dispatcher{
+		OnMessage: func(conn *Conn, mode Mode, b []byte) {},
+	}
+	if err := d.Dispatch(nil, ModeText, nil); err != ErrDispatcherStopped {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrDispatcherStopped)
+	}
+
+	d.Start()
+	d.Stop()
+	if err := d.Dispatch(nil, ModeText, nil); err != ErrDispatcherStopped {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrDispatcherStopped)
+	}
+}
@@ -0,0 +1,111 @@
+package fastws
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// DuplicatePolicy decides what the Upgrader does when a client identity
+// upgrading a connection already has active connections (see Upgrader.Identity).
+type DuplicatePolicy int
+
+const (
+	// AllowDuplicates allows any number of connections per identity.
+	AllowDuplicates DuplicatePolicy = iota
+	// RejectDuplicates refuses the new connections of the identities having
+	// Upgrader.MaxIdentityConns active connections with 409 (Conflict).
+	RejectDuplicates
+	// CloseDuplicates accepts the new connections, closing the oldest
+	// connections of the identity (StatusViolation) so no more than
+	// Upgrader.MaxIdentityConns remain open.
+	CloseDuplicates
+)
+
+// duplicateReason is the close reason of the connections closed by CloseDuplicates.
+const duplicateReason = "duplicate connection"
+
+// identity returns the identity of the client upgrading ctx.
+func (upgr *Upgrader) identity(ctx *fasthttp.RequestCtx) string {
+	switch {
+	case upgr.DuplicatePolicy == AllowDuplicates:
+		return ""
+	case upgr.Identity != nil:
+		return upgr.Identity(ctx)
+	case upgr.Sessions != nil && upgr.Sessions.Identity != nil:
+		return upgr.Sessions.Identity(ctx)
+	}
+	return ""
+}
+
+func (upgr *Upgrader) maxIdentityConns() int {
+	if upgr.MaxIdentityConns <= 0 {
+		return 1
+	}
+	return upgr.MaxIdentityConns
+}
+
+// registry returns the Registry holding the connections of upgr,
+// which is upgr.Registry or, if nil, the one of upgr if the identities are checked.
+func (upgr *Upgrader) registry() *Registry {
+	switch {
+	case upgr.Registry != nil:
+		return upgr.Registry
+	case upgr.DuplicatePolicy != AllowDuplicates:
+		return &upgr.identities
+	}
+	return nil
+}
+
+// isDuplicate returns whether a new connection of identity must be rejected.
+func (upgr *Upgrader) isDuplicate(identity string) bool {
+	if identity == "" || upgr.DuplicatePolicy != RejectDuplicates {
+		return false
+	}
+	return upgr.registry().identityLen(identity) >= upgr.maxIdentityConns()
+}
+
+// register adds uc to the Registry, returning false if it must be rejected.
+// The connections exceeding the limit because of CloseDuplicates
+// are returned to be closed.
+//
+// upgr.lck must be held.
+func (upgr *Upgrader) register(uc *upgradedConn, identity string) (bool, []*upgradedConn) {
+	r := upgr.registry()
+	if r == nil {
+		return true, nil
+	}
+	if identity == "" {
+		r.Add(uc.conn)
+		return true, nil
+	}
+
+	var evicted []*upgradedConn
+	ok := r.add(uc.conn, func(conns []*Conn) bool {
+		n := len(conns) + 1 - upgr.maxIdentityConns()
+		if n > 0 && upgr.DuplicatePolicy == RejectDuplicates {
+			// upgraded meanwhile by another request.
+			return false
+		}
+
+		for _, conn := range conns {
+			if n <= 0 {
+				break
+			}
+			// the connections of other upgraders sharing the Registry are not closed.
+			old := upgr.conns[conn]
+			if old != nil && !old.closing {
+				old.closing = true
+				evicted = append(evicted, old)
+				n--
+			}
+		}
+		return true
+	})
+
+	return ok, evicted
+}
+
+// closeDuplicate closes the connection evicted by a newer connection of its identity.
+func closeDuplicate(uc *upgradedConn) {
+	defer close(uc.done)
+	uc.conn.CloseWithCode(StatusViolation, duplicateReason)
+}
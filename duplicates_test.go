@@ -0,0 +1,92 @@
+package fastws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func serveDuplicates(t *testing.T, upgr *Upgrader) (*fasthttputil.InmemoryListener, chan *Conn) {
+	connected := make(chan *Conn, 4)
+
+	upgr.Identity = func(ctx *fasthttp.RequestCtx) string {
+		return "user"
+	}
+	upgr.OnConnect = func(conn *Conn) {
+		connected <- conn
+	}
+	upgr.Handler = func(conn *Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(nil); err != nil {
+				return
+			}
+		}
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+
+	return ln, connected
+}
+
+func TestUpgraderRejectDuplicates(t *testing.T) {
+	var r Registry
+	upgr := Upgrader{
+		DuplicatePolicy:  RejectDuplicates,
+		MaxIdentityConns: 2,
+		Registry:         &r,
+	}
+	ln, connected := serveDuplicates(t, &upgr)
+	defer ln.Close()
+
+	for i := 0; i < 2; i++ {
+		conn := openConn(t, ln)
+		defer conn.Close()
+		<-connected
+	}
+	if conns := collect(func(f func(*Conn) bool) { r.Identity("user", f) }); len(conns) != 2 {
+		t.Fatalf("Unexpected connections: %d <> 2", len(conns))
+	}
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = Client(c, "http://localhost/")
+	var uerr *UpgradeError
+	if !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusConflict {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUpgraderCloseDuplicates(t *testing.T) {
+	upgr := Upgrader{
+		DuplicatePolicy: CloseDuplicates,
+	}
+	ln, connected := serveDuplicates(t, &upgr)
+	defer ln.Close()
+
+	old := openConn(t, ln)
+	defer old.Close()
+	<-connected
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+	<-connected
+
+	_, _, err := old.ReadMessage(nil)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cerr.Status != StatusViolation || cerr.Reason != duplicateReason {
+		t.Fatalf("Unexpected close: %d %q", cerr.Status, cerr.Reason)
+	}
+}
@@ -0,0 +1,153 @@
+package fastws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrConnectionClosed is matched by the *CloseError returned
+	// when the peer closes the connection and by the errors returned
+	// by the functions called after the connection has been closed,
+	// which match EOF too.
+	ErrConnectionClosed = errors.New("connection closed")
+
+	// ErrTooBig is matched by the errors of the frames and messages exceeding
+	// the limits of the connection, which is closed with StatusTooBig.
+	ErrTooBig = errors.New("too big")
+
+	// ErrProtocol is matched by the errors of the frames violating the protocol.
+	// The connection is closed with StatusProtocolError.
+	ErrProtocol = errors.New("protocol error")
+
+	// ErrInvalidData is matched by the errors of the payloads not consistent
+	// with the message type (i.e.: text not UTF-8 encoded).
+	// The connection is closed with StatusNotConsistent.
+	ErrInvalidData = errors.New("invalid data")
+)
+
+// closedError is the error returned by the functions called
+// after the connection has been closed.
+// It matches EOF and ErrConnectionClosed using errors.Is.
+type closedError struct{}
+
+var errClosed error = closedError{}
+
+func (closedError) Error() string {
+	return EOF.Error()
+}
+
+// Is returns whether target is EOF or ErrConnectionClosed.
+func (closedError) Is(target error) bool {
+	return target == EOF || target == ErrConnectionClosed
+}
+
+// kindError is an error matching its kind using errors.Is.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func newError(kind error, msg string) error {
+	return &kindError{
+		kind: kind,
+		msg:  msg,
+	}
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// MaxCloseReasonSize is the max length of a close reason,
+// as the status and the reason must fit in a control frame.
+const MaxCloseReasonSize = maxControlPayload - 2
+
+// ErrInvalidCloseReason is returned when sending a close reason longer than
+// MaxCloseReasonSize bytes or not UTF-8 encoded. Nothing is sent in that case.
+var ErrInvalidCloseReason = errors.New("invalid close reason")
+
+// checkCloseReason checks b can be sent as a close reason.
+func checkCloseReason(b []byte) error {
+	if len(b) > MaxCloseReasonSize {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidCloseReason, MaxCloseReasonSize)
+	}
+	if !utf8.Valid(b) {
+		return fmt.Errorf("%w: not UTF-8 encoded", ErrInvalidCloseReason)
+	}
+	return nil
+}
+
+// CloseError is returned when the peer closes the connection.
+//
+// CloseError matches EOF and ErrConnectionClosed using errors.Is, so the closures
+// can be detected with errors.Is(err, EOF) and inspected with errors.As.
+type CloseError struct {
+	// Status is the status code sent by the peer.
+	Status StatusCode
+	// Reason is the close reason sent by the peer. Can be empty.
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("connection closed by peer: %d (%s)", e.Status, e.Status)
+	}
+	return fmt.Sprintf("connection closed by peer: %d (%s): %s", e.Status, e.Status, e.Reason)
+}
+
+// Is returns whether target is EOF or ErrConnectionClosed.
+func (e *CloseError) Is(target error) bool {
+	return target == EOF || target == ErrConnectionClosed
+}
+
+// UpgradeError is returned when the server doesn't upgrade the connection.
+//
+// UpgradeError matches ErrCannotUpgrade using errors.Is.
+type UpgradeError struct {
+	// StatusCode is the status code of the server response.
+	StatusCode int
+	// RetryAfter is the time the server asked to wait before retrying
+	// using the Retry-After header. 0 if the header wasn't present.
+	RetryAfter time.Duration
+	// Location is the Location header of the redirect responses.
+	Location string
+}
+
+func (e *UpgradeError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: status code %d (retry after %s)", ErrCannotUpgrade, e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s: status code %d", ErrCannotUpgrade, e.StatusCode)
+}
+
+// Is returns whether target is ErrCannotUpgrade.
+func (e *UpgradeError) Is(target error) bool {
+	return target == ErrCannotUpgrade
+}
+
+// RetryAfter returns the time the server asked to wait before dialing again
+// if err is an UpgradeError with a Retry-After header.
+func RetryAfter(err error) (time.Duration, bool) {
+	var uerr *UpgradeError
+	if errors.As(err, &uerr) && uerr.RetryAfter > 0 {
+		return uerr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// ErrConcurrentRead is returned when many goroutines reading concurrently
+// from the same connection take the frames of a fragmented message.
+//
+// The messages can be read concurrently as long as they are not fragmented,
+// as the frames are received by the readLoop and taken by the readers
+// one by one. The frames of a fragmented message taken by different readers
+// corrupt the messages, so the connection is closed with StatusUnexpected.
+// The detection is best-effort: a reader might still get a corrupted message.
+var ErrConcurrentRead = errors.New("fragmented message read concurrently")
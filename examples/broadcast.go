@@ -4,10 +4,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
-	"sync"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
@@ -15,52 +15,32 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-type Broadcaster struct {
-	lck sync.Mutex
-	cs  []*fastws.Conn
-}
-
-func (b *Broadcaster) Add(c *fastws.Conn) {
-	b.lck.Lock()
-	b.cs = append(b.cs, c)
-	b.lck.Unlock()
-}
+func main() {
+	var hub fastws.Hub
+	defer hub.Close()
 
-func (b *Broadcaster) Start() {
-	for {
-		b.lck.Lock()
-		for i := 0; i < len(b.cs); i++ {
-			c := b.cs[i]
-			_, err := c.WriteString("Message")
-			if err != nil {
-				b.cs = append(b.cs[:i], b.cs[i+1:]...)
-				fmt.Println(len(b.cs))
-				continue
-			}
+	go func() {
+		for range time.Tick(time.Second) {
+			hub.Broadcast(fastws.ModeText, []byte("Message"))
 		}
-		b.lck.Unlock()
+	}()
 
-		time.Sleep(time.Second)
-	}
-}
-
-func main() {
-	b := &Broadcaster{}
 	router := fasthttprouter.New()
 	router.GET("/", rootHandler)
 	router.GET("/ws", fastws.Upgrade(func(c *fastws.Conn) {
-		b.Add(c)
+		hub.Register(c)
+		defer hub.Unregister(c)
+
 		for {
 			_, _, err := c.ReadMessage(nil)
 			if err != nil {
-				if err == fastws.EOF {
+				if errors.Is(err, fastws.EOF) {
 					break
 				}
 				panic(err)
 			}
 		}
 	}))
-	go b.Start()
 
 	server := fasthttp.Server{
 		Handler: router.Handler,
@@ -27,8 +27,13 @@ func main() {
 			break
 		}
 		log.Printf("Client: %s\n", msg)
-		conn.Write(msg)
-		time.Sleep(time.Second)
+
+		// the message is sent back a second later, unless the connection gets closed before.
+		reply := append([]byte(nil), msg...)
+		conn.After(time.Second, func() error {
+			_, err := conn.Write(reply)
+			return err
+		})
 	}
 	conn.Close()
 }
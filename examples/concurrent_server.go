@@ -4,11 +4,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
@@ -48,41 +48,23 @@ func wsHandler(conn *fastws.Conn) {
 
 	conn.WriteString("Hello")
 
-	var msg []byte
-	var wg sync.WaitGroup
-	mch := make(chan string, 128)
-
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		defer close(mch)
-
-		var err error
-		for {
-			_, msg, err = conn.ReadMessage(msg[:0])
-			if err != nil {
-				if err != fastws.EOF {
-					fmt.Fprintf(os.Stderr, "error reading message: %s\n", err)
-				}
-				break
-			}
-			mch <- string(msg)
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		for m := range mch {
-			_, err := conn.WriteString(m)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error writing message: %s\n", err)
-				break
-			}
-			time.Sleep(time.Millisecond * 100)
-		}
-	}()
+	// the messages are read while the previous ones are being handled:
+	// every message is echoed 100ms later without delaying the next ones.
+	p := &fastws.Pipeline{
+		Handle: func(conn *fastws.Conn, v interface{}) (interface{}, error) {
+			msg := append([]byte(nil), v.([]byte)...)
+			conn.After(time.Millisecond*100, func() error {
+				_, err := conn.Write(msg)
+				return err
+			})
+			return nil, nil
+		},
+	}
 
-	wg.Wait()
+	err := p.Serve(conn)
+	if err != nil && !errors.Is(err, fastws.EOF) {
+		fmt.Fprintf(os.Stderr, "error serving connection: %s\n", err)
+	}
 
 	fmt.Printf("Closed connection\n")
 }
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -38,18 +39,21 @@ func wsHandler(conn *fastws.Conn) {
 	for {
 		_, msg, err = conn.ReadMessage(msg[:0])
 		if err != nil {
-			if err != fastws.EOF {
+			if !errors.Is(err, fastws.EOF) {
 				fmt.Fprintf(os.Stderr, "error reading message: %s\n", err)
 			}
 			break
 		}
-		time.Sleep(time.Second)
 
-		_, err = conn.Write(msg)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error writing message: %s\n", err)
-			break
-		}
+		// the message is echoed a second later, unless the connection gets closed before.
+		echo := append([]byte(nil), msg...)
+		conn.After(time.Second, func() error {
+			_, err := conn.Write(echo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error writing message: %s\n", err)
+			}
+			return err
+		})
 	}
 
 	fmt.Printf("Closed connection\n")
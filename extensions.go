@@ -0,0 +1,152 @@
+package fastws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidExtension is matched by the errors building an extension offer
+// whose names or values are not valid tokens.
+var ErrInvalidExtension = errors.New("invalid extension")
+
+// ExtensionParam is a parameter of an Extension.
+type ExtensionParam struct {
+	// Name is the name of the parameter.
+	Name string
+	// Value is the value of the parameter. Can be empty (a parameter without value).
+	Value string
+}
+
+// Extension is an extension offered in the Sec-WebSocket-Extensions header.
+type Extension struct {
+	// Name is the name of the extension, i.e.: permessage-deflate.
+	Name string
+	// Params are the parameters of the extension.
+	Params []ExtensionParam
+}
+
+// BuildExtensions returns the value of the Sec-WebSocket-Extensions header
+// offering exts, in order of preference. It can be set in the request
+// of ClientWithHeaders or DialWithHeaders:
+//
+//	offer, err := fastws.BuildExtensions(fastws.Extension{
+//		Name: "permessage-deflate",
+//		Params: []fastws.ExtensionParam{
+//			{Name: "client_max_window_bits"},
+//		},
+//	})
+//	req.Header.Set("Sec-WebSocket-Extensions", offer)
+//
+// The names and values must be tokens (RFC 6455 section 9.1),
+// otherwise the error returned matches ErrInvalidExtension.
+func BuildExtensions(exts ...Extension) (string, error) {
+	b, err := AppendExtensions(nil, exts...)
+	return string(b), err
+}
+
+// AppendExtensions appends the offer of exts to dst as BuildExtensions does.
+func AppendExtensions(dst []byte, exts ...Extension) ([]byte, error) {
+	for i, ext := range exts {
+		if !isToken(ext.Name) {
+			return dst, fmt.Errorf("%w: name %q", ErrInvalidExtension, ext.Name)
+		}
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		dst = append(dst, ext.Name...)
+
+		for _, param := range ext.Params {
+			if !isToken(param.Name) {
+				return dst, fmt.Errorf("%w: %s parameter %q", ErrInvalidExtension, ext.Name, param.Name)
+			}
+			dst = append(dst, "; "...)
+			dst = append(dst, param.Name...)
+
+			if param.Value == "" {
+				continue
+			}
+			// the quoted values must be tokens once unescaped,
+			// so they are always sent unquoted.
+			if !isToken(param.Value) {
+				return dst, fmt.Errorf("%w: %s parameter %s value %q",
+					ErrInvalidExtension, ext.Name, param.Name, param.Value)
+			}
+			dst = append(dst, '=')
+			dst = append(dst, param.Value...)
+		}
+	}
+
+	return dst, nil
+}
+
+// ParseExtensions parses the value of a Sec-WebSocket-Extensions header,
+// as the one accepted by the server.
+//
+// The names and values must be tokens (the quoted values are unquoted),
+// otherwise the error returned matches ErrInvalidExtension.
+func ParseExtensions(s string) ([]Extension, error) {
+	var exts []Extension
+	for _, e := range strings.Split(s, ",") {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+
+		params := strings.Split(e, ";")
+		ext := Extension{
+			Name: strings.TrimSpace(params[0]),
+		}
+		if !isToken(ext.Name) {
+			return nil, fmt.Errorf("%w: name %q", ErrInvalidExtension, ext.Name)
+		}
+
+		for _, p := range params[1:] {
+			var param ExtensionParam
+			param.Name = strings.TrimSpace(p)
+			if n := strings.IndexByte(param.Name, '='); n != -1 {
+				param.Value = strings.TrimSpace(param.Name[n+1:])
+				param.Name = strings.TrimSpace(param.Name[:n])
+				if len(param.Value) > 1 && param.Value[0] == '"' && param.Value[len(param.Value)-1] == '"' {
+					param.Value = param.Value[1 : len(param.Value)-1]
+				}
+				if !isToken(param.Value) {
+					return nil, fmt.Errorf("%w: %s parameter %s value %q",
+						ErrInvalidExtension, ext.Name, param.Name, param.Value)
+				}
+			}
+			if !isToken(param.Name) {
+				return nil, fmt.Errorf("%w: %s parameter %q", ErrInvalidExtension, ext.Name, param.Name)
+			}
+			ext.Params = append(ext.Params, param)
+		}
+
+		exts = append(exts, ext)
+	}
+
+	return exts, nil
+}
+
+// isToken returns whether s is a token as defined by RFC 7230 section 3.2.6.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
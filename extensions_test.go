@@ -0,0 +1,70 @@
+package fastws
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBuildExtensions(t *testing.T) {
+	offer, err := BuildExtensions(
+		Extension{
+			Name: "permessage-deflate",
+			Params: []ExtensionParam{
+				{Name: "client_max_window_bits"},
+				{Name: "server_max_window_bits", Value: "10"},
+			},
+		},
+		Extension{Name: "x-custom"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "permessage-deflate; client_max_window_bits; server_max_window_bits=10, x-custom"
+	if offer != expected {
+		t.Fatalf("Unexpected offer: %q <> %q", offer, expected)
+	}
+}
+
+func TestBuildExtensionsInvalid(t *testing.T) {
+	for _, ext := range []Extension{
+		{Name: ""},
+		{Name: "foo bar"},
+		{Name: "foo", Params: []ExtensionParam{{Name: "a,b"}}},
+		{Name: "foo", Params: []ExtensionParam{{Name: "a", Value: `"quoted"`}}},
+		{Name: "foo", Params: []ExtensionParam{{Name: "a", Value: "b;c"}}},
+	} {
+		_, err := BuildExtensions(ext)
+		if !errors.Is(err, ErrInvalidExtension) {
+			t.Fatalf("Unexpected error for %+v: %v", ext, err)
+		}
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	exts, err := ParseExtensions(`permessage-deflate; server_no_context_takeover; server_max_window_bits="10", x-custom`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exts) != 2 || exts[0].Name != "permessage-deflate" || exts[1].Name != "x-custom" {
+		t.Fatalf("Unexpected extensions: %+v", exts)
+	}
+
+	params := exts[0].Params
+	if len(params) != 2 ||
+		params[0] != (ExtensionParam{Name: "server_no_context_takeover"}) ||
+		params[1] != (ExtensionParam{Name: "server_max_window_bits", Value: "10"}) {
+		t.Fatalf("Unexpected params: %+v", params)
+	}
+
+	for _, s := range []string{
+		"foo bar",
+		"foo; a b",
+		"foo; a=b c",
+		"foo; a=",
+	} {
+		if _, err := ParseExtensions(s); !errors.Is(err, ErrInvalidExtension) {
+			t.Fatalf("Unexpected error for %q: %v", s, err)
+		}
+	}
+}
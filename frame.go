@@ -7,11 +7,15 @@ import (
 	"io"
 	"strconv"
 	"sync"
+	"unicode/utf8"
 )
 
 // StatusCode is sent when closing a connection.
 //
-// The following constants have been defined by the RFC.
+// The following constants have been defined by the RFC
+// and the IANA WebSocket Close Code Number Registry.
+// The codes from 4000 to 4999 can be used by the applications
+// (see RegisterStatusCode).
 type StatusCode uint16
 
 const (
@@ -25,16 +29,35 @@ const (
 	StatusNotAcceptable = 1003
 	// StatusReserved when a reserved field have been used
 	StatusReserved = 1004
-	// StatusNotConsistent IDK
+	// StatusNoStatus reports that no status was received.
+	// It must not be sent in a close frame.
+	StatusNoStatus = 1005
+	// StatusAbnormalClosure reports that the connection was closed
+	// without a close frame. It must not be sent in a close frame.
+	StatusAbnormalClosure = 1006
+	// StatusNotConsistent when the data of a message is not consistent
+	// with its type, i.e.: non UTF-8 text messages.
 	StatusNotConsistent = 1007
 	// StatusViolation a violation of the protocol happened
 	StatusViolation = 1008
 	// StatusTooBig payload bigger than expected
 	StatusTooBig = 1009
-	// StatuseExtensionsNeeded IDK
+	// StatuseExtensionsNeeded is sent by the client when the server
+	// didn't negotiate the extensions it expected.
 	StatuseExtensionsNeeded = 1010
-	// StatusUnexpected IDK
+	// StatusExtensionsNeeded is StatuseExtensionsNeeded.
+	StatusExtensionsNeeded = StatuseExtensionsNeeded
+	// StatusUnexpected when the server found an unexpected condition.
 	StatusUnexpected = 1011
+	// StatusServiceRestart when the server is restarting.
+	StatusServiceRestart = 1012
+	// StatusTryAgainLater when the server is overloaded.
+	StatusTryAgainLater = 1013
+	// StatusBadGateway when a gateway received an invalid response.
+	StatusBadGateway = 1014
+	// StatusTLSHandshake reports that the TLS handshake failed.
+	// It must not be sent in a close frame.
+	StatusTLSHandshake = 1015
 )
 
 func (status StatusCode) String() string {
@@ -49,6 +72,10 @@ func (status StatusCode) String() string {
 		return "NotAcceptable"
 	case StatusReserved:
 		return "Reserved"
+	case StatusNoStatus:
+		return "NoStatus"
+	case StatusAbnormalClosure:
+		return "AbnormalClosure"
 	case StatusNotConsistent:
 		return "NotConsistent"
 	case StatusViolation:
@@ -59,6 +86,18 @@ func (status StatusCode) String() string {
 		return "ExtensionsNeeded"
 	case StatusUnexpected:
 		return "Unexpected"
+	case StatusServiceRestart:
+		return "ServiceRestart"
+	case StatusTryAgainLater:
+		return "TryAgainLater"
+	case StatusBadGateway:
+		return "BadGateway"
+	case StatusTLSHandshake:
+		return "TLSHandshake"
+	}
+
+	if name := statusName(status); name != "" {
+		return name
 	}
 
 	return strconv.FormatInt(int64(status), 10)
@@ -100,12 +139,18 @@ const (
 
 // Frame is the unit used to transfer message
 // between endpoints using the websocket protocol.
+//
+// The status of the close frames is always held unmasked, even if the
+// frame is masked, as the mask is applied to it when writing the frame.
 type Frame struct {
 	max    uint64
 	op     []byte
 	mask   []byte
 	status []byte
 	b      []byte
+	// statusSet is set if the close frame holds a status,
+	// as it can be 0 when received.
+	statusSet bool
 }
 
 // CopyTo copies the frame `fr` to `fr2`
@@ -114,6 +159,7 @@ func (fr *Frame) CopyTo(fr2 *Frame) {
 	fr2.op = append(fr2.op[:0], fr.op...)
 	fr2.mask = append(fr2.mask[:0], fr.mask...)
 	fr2.status = append(fr2.status[:0], fr.status...)
+	fr2.statusSet = fr.statusSet
 	fr2.b = append(fr2.b[:0], fr.b...)
 }
 
@@ -154,11 +200,18 @@ var framePool = sync.Pool{
 
 // AcquireFrame gets Frame from the global pool.
 func AcquireFrame() *Frame {
-	return framePool.Get().(*Frame)
+	fr := framePool.Get().(*Frame)
+	if hooks := loadPoolHooks(); hooks != nil && hooks.AcquireFrame != nil {
+		hooks.AcquireFrame(fr)
+	}
+	return fr
 }
 
 // ReleaseFrame puts fr Frame into the global pool.
 func ReleaseFrame(fr *Frame) {
+	if hooks := loadPoolHooks(); hooks != nil && hooks.ReleaseFrame != nil {
+		hooks.ReleaseFrame(fr)
+	}
 	fr.Reset()
 	framePool.Put(fr)
 }
@@ -177,6 +230,7 @@ func (fr *Frame) resetHeader() {
 	copy(fr.op, zeroBytes)
 	copy(fr.mask, zeroBytes)
 	copy(fr.status, zeroBytes)
+	fr.statusSet = false
 }
 
 // Reset resets all Frame values to the default.
@@ -279,6 +333,7 @@ func (fr *Frame) parseStatus() {
 	if n >= 2 {
 		// copy the status from the payload to the fr.status
 		copy(fr.status, fr.b[:2])
+		fr.statusSet = true
 		fr.b = append(fr.b[:0], fr.b[2:]...)
 	}
 }
@@ -415,10 +470,15 @@ func (fr *Frame) setLength(n int) {
 
 // Mask performs the masking of the current payload
 func (fr *Frame) Mask() {
+	fr.maskWith(readMask)
+}
+
+// maskWith masks the payload using a key filled by read.
+func (fr *Frame) maskWith(read func([]byte)) {
 	fr.op[1] |= maskBit
-	readMask(fr.mask)
+	read(fr.mask)
 	if len(fr.b) > 0 {
-		mask(fr.mask, fr.b)
+		maskAt(fr.mask, fr.b, fr.payloadPos())
 	}
 }
 
@@ -426,13 +486,22 @@ func (fr *Frame) Mask() {
 func (fr *Frame) Unmask() {
 	if len(fr.b) > 0 {
 		key := fr.MaskKey()
-		mask(key, fr.b)
+		maskAt(key, fr.b, fr.payloadPos())
 	}
 	fr.UnsetMask()
 }
 
 func (fr *Frame) hasStatus() bool {
-	return fr.status[0] > 0 || fr.status[1] > 0
+	return fr.statusSet
+}
+
+// payloadPos returns the position of fr.b in the payload sent,
+// which follows the status of the close frames.
+func (fr *Frame) payloadPos() int {
+	if fr.hasStatus() {
+		return statusSize
+	}
+	return 0
 }
 
 // WriteTo writes the frame into wr.
@@ -453,7 +522,15 @@ func (fr *Frame) WriteTo(wr io.Writer) (n int64, err error) {
 		}
 		if err == nil {
 			if fr.hasStatus() {
+				if fr.IsMasked() {
+					// the status is masked as the first bytes of the payload,
+					// and unmasked back once written (see Frame).
+					mask(fr.mask, fr.status)
+				}
 				ni, err = wr.Write(fr.status)
+				if fr.IsMasked() {
+					mask(fr.mask, fr.status)
+				}
 				if ni > 0 {
 					n += int64(ni)
 				}
@@ -481,8 +558,10 @@ func (fr *Frame) Status() (status StatusCode) {
 // SetStatus sets status code.
 //
 // Status code is usually used in Close request.
+// A zero status removes the status, as it can't be sent.
 func (fr *Frame) SetStatus(status StatusCode) {
 	binary.BigEndian.PutUint16(fr.status, uint16(status))
+	fr.statusSet = status != 0
 }
 
 // mustRead returns the number of bytes that must be
@@ -517,10 +596,128 @@ var (
 	errReadingHeader = errors.New("error reading frame header")
 	errReadingLen    = errors.New("error reading b length")
 	errReadingMask   = errors.New("error reading mask")
-	errLenTooBig     = errors.New("message length is bigger than expected")
-	errStatusLen     = errors.New("length of the status must be = 2")
+	errLenTooBig     = newError(ErrTooBig, "message length is bigger than expected")
+	errStatusLen     = newError(ErrInvalidData, "length of the status must be = 2")
+	errControlTooBig = newError(ErrProtocol, "control frames payload must not exceed 125 bytes")
+)
+
+var (
+	errReservedOpcode     = newError(ErrProtocol, "reserved opcode")
+	errReservedBits       = newError(ErrProtocol, "RSV bits set without negotiated extensions")
+	errInvalidCloseStatus = newError(ErrProtocol, "invalid close status code")
+)
+
+var (
+	errUnmaskedFrame = newError(ErrProtocol, "frames sent by the client must be masked")
+	errMaskedFrame   = newError(ErrProtocol, "frames sent by the server must not be masked")
 )
 
+// Validate checks fr follows the RFC 6455 framing rules, returning an error
+// describing the first rule broken:
+//
+//   - The opcode must not be reserved.
+//   - The RSV bits must not be set, as no extension is negotiated.
+//   - Control frames must not be fragmented and their payload
+//     (status included) must not exceed 125 bytes.
+//   - The status of close frames must be a valid status code.
+//
+// The masking and the close reason are not checked, see ValidateFrame.
+func (fr *Frame) Validate() error {
+	return fr.validate(false)
+}
+
+// ValidateFrame checks fr follows all the RFC 6455 rules Conn applies
+// to the frames received when Strict is enabled, so proxies and custom
+// read loops can apply the same rules.
+//
+// Besides the rules checked by Frame.Validate, the frames received
+// by the server (serverSide) must be masked and the frames received
+// by the client must not, and the close reason must be UTF-8 encoded.
+// If extensionsNegotiated is true the RSV bits are allowed,
+// as they are defined (and must be checked) by the extensions.
+//
+// fr must be validated before unmasking it, as the mask is checked.
+// The error returned matches ErrProtocol or ErrInvalidData using errors.Is.
+func ValidateFrame(fr *Frame, serverSide bool, extensionsNegotiated bool) error {
+	if err := fr.validateMask(serverSide); err != nil {
+		return err
+	}
+
+	if err := fr.validate(extensionsNegotiated); err != nil {
+		return err
+	}
+
+	if fr.IsClose() && len(fr.b) > 0 {
+		// control frames are small, so the reason is unmasked in a copy.
+		var reason [maxControlPayload]byte
+		b := reason[:copy(reason[:], fr.b)]
+		if fr.IsMasked() {
+			maskAt(fr.MaskKey(), b, fr.payloadPos())
+		}
+		if !utf8.Valid(b) {
+			return errInvalidUTF8
+		}
+	}
+
+	return nil
+}
+
+// validateMask checks fr is masked if it's received by the server (serverSide)
+// and unmasked otherwise.
+func (fr *Frame) validateMask(serverSide bool) error {
+	if fr.IsMasked() != serverSide {
+		if serverSide {
+			return errUnmaskedFrame
+		}
+		return errMaskedFrame
+	}
+	return nil
+}
+
+func (fr *Frame) validate(extensions bool) error {
+	switch code := fr.Code(); code {
+	case CodeContinuation, CodeText, CodeBinary, CodeClose, CodePing, CodePong:
+	default:
+		return fmt.Errorf("%w: %d", errReservedOpcode, code)
+	}
+
+	if fr.HasRSV2() || fr.HasRSV3() {
+		return errReservedBits
+	}
+	// RSV1 marks the compressed messages (RFC 7692 section 6),
+	// so it's only valid on their first frame.
+	if fr.HasRSV1() && (!extensions || fr.IsControl() || fr.IsContinuation()) {
+		return errReservedBits
+	}
+
+	if fr.IsControl() {
+		if !fr.IsFin() {
+			return errControlMustNotBeFragmented
+		}
+		if fr.controlTooBig() {
+			return errControlTooBig
+		}
+	}
+
+	if fr.IsClose() && fr.hasStatus() && !fr.Status().IsValidReceivedCloseCode() {
+		return fmt.Errorf("%w: %d", errInvalidCloseStatus, fr.Status())
+	}
+
+	return nil
+}
+
+// maxControlPayload is the max payload length of a control frame (including the status).
+const maxControlPayload = 125
+
+// controlTooBig returns whether fr is a control frame exceeding maxControlPayload.
+func (fr *Frame) controlTooBig() bool {
+	n := len(fr.b)
+	if fr.hasStatus() {
+		n += 2
+	}
+	return fr.IsControl() && n > maxControlPayload
+}
+
 const limitLen = 1 << 32
 
 func (fr *Frame) readFrom(r io.Reader) (int64, error) {
@@ -553,6 +750,8 @@ func (fr *Frame) readFrom(r io.Reader) (int64, error) {
 			// reading the payload
 			if frameSize := fr.Len(); (fr.max > 0 && frameSize > fr.max) || frameSize > limitLen {
 				err = errLenTooBig
+			} else if frameSize > maxControlPayload && fr.IsControl() {
+				err = errControlTooBig
 			} else if frameSize > 0 { // read the payload
 				nn := int64(frameSize)
 				if nn < 0 {
@@ -567,22 +766,26 @@ func (fr *Frame) readFrom(r io.Reader) (int64, error) {
 					}
 				}
 
+				if err == nil && isClose {
+					n, err = io.ReadFull(r, fr.status[:2])
+					if err == io.ErrUnexpectedEOF {
+						err = errStatusLen
+					}
+					// the status is present even if it's 0 (invalid).
+					fr.statusSet = err == nil
+					if err == nil && fr.IsMasked() {
+						// the status is held unmasked (see Frame).
+						mask(fr.mask, fr.status)
+					}
+				}
+
 				if err == nil && nn > 0 {
 					if rLen := nn - int64(cap(fr.b)); rLen > 0 {
 						fr.b = append(fr.b[:cap(fr.b)], make([]byte, rLen)...)
 					}
 
-					if isClose {
-						n, err = io.ReadFull(r, fr.status[:2])
-						if err == io.ErrUnexpectedEOF {
-							err = errStatusLen
-						}
-					}
-
-					if err == nil {
-						fr.b = fr.b[:nn]
-						n, err = io.ReadFull(r, fr.b)
-					}
+					fr.b = fr.b[:nn]
+					n, err = io.ReadFull(r, fr.b)
 				}
 			}
 		}
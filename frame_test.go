@@ -3,6 +3,7 @@ package fastws
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 )
@@ -123,3 +124,174 @@ func BenchmarkRead(b *testing.B) {
 		ReleaseFrame(fr)
 	})
 }
+
+func TestReadControlTooBig(t *testing.T) {
+	fr := AcquireFrame()
+	fr.SetFin()
+	fr.SetPing()
+	fr.SetPayload(make([]byte, 126))
+
+	bf := bytes.NewBuffer(nil)
+	fr.WriteTo(bf)
+
+	fr.Reset()
+	_, err := fr.ReadFrom(bf)
+	if err != errControlTooBig {
+		t.Fatalf("Unexpected error: %v <> %v", err, errControlTooBig)
+	}
+	ReleaseFrame(fr)
+}
+
+func TestReadCloseStatusOnly(t *testing.T) {
+	fr := AcquireFrame()
+	fr.SetFin()
+	fr.SetClose()
+	fr.SetStatus(StatusGoAway)
+
+	bf := bytes.NewBuffer(nil)
+	fr.WriteTo(bf)
+
+	fr.Reset()
+	_, err := fr.ReadFrom(bf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fr.Status() != StatusGoAway {
+		t.Fatalf("Unexpected status: %d <> %d", fr.Status(), StatusGoAway)
+	}
+	if bf.Len() != 0 {
+		t.Fatalf("Unread bytes: %d", bf.Len())
+	}
+	ReleaseFrame(fr)
+}
+
+// maskedClose returns a close frame holding status and reason
+// masked as RFC 6455 section 5.3 defines.
+func maskedClose(status StatusCode, reason string) []byte {
+	key := []byte{0x37, 0xfa, 0x21, 0x3d}
+	payload := append([]byte{byte(status >> 8), byte(status)}, reason...)
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+
+	b := []byte{0x88, 0x80 | byte(len(payload))}
+	b = append(b, key...)
+	return append(b, payload...)
+}
+
+func TestReadMaskedClose(t *testing.T) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	_, err := fr.ReadFrom(bytes.NewReader(maskedClose(StatusNone, "bye")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateFrame(fr, true, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fr.Status() != StatusNone {
+		t.Fatalf("Unexpected status: %d <> %d", fr.Status(), StatusNone)
+	}
+
+	fr.Unmask()
+	if string(fr.Payload()) != "bye" {
+		t.Fatalf("Unexpected reason: %q <> bye", fr.Payload())
+	}
+
+	// masking it back produces the same frame.
+	fr.SetMask([]byte{0x37, 0xfa, 0x21, 0x3d})
+	maskAt(fr.MaskKey(), fr.b, fr.payloadPos())
+	var bf bytes.Buffer
+	fr.WriteTo(&bf)
+	if !bytes.Equal(bf.Bytes(), maskedClose(StatusNone, "bye")) {
+		t.Fatalf("Unexpected frame: %v <> %v", bf.Bytes(), maskedClose(StatusNone, "bye"))
+	}
+}
+
+func TestReadMaskedCloseZero(t *testing.T) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	_, err := fr.ReadFrom(bytes.NewReader(maskedClose(0, "bye")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fr.hasStatus() || fr.payloadPos() != 2 {
+		t.Fatalf("Unexpected status position: %v %d", fr.hasStatus(), fr.payloadPos())
+	}
+	if err := ValidateFrame(fr, true, false); !errors.Is(err, ErrProtocol) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrProtocol)
+	}
+}
+
+func TestFrameValidate(t *testing.T) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	for _, tc := range []struct {
+		name  string
+		setup func(fr *Frame)
+		valid bool
+	}{
+		{"text", func(fr *Frame) { fr.SetFin(); fr.SetText() }, true},
+		{"fragment", func(fr *Frame) { fr.SetBinary() }, true},
+		{"reserved opcode", func(fr *Frame) { fr.SetFin(); fr.SetCode(0x3) }, false},
+		{"rsv", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.SetRSV2() }, false},
+		{"fragmented ping", func(fr *Frame) { fr.SetPing() }, false},
+		{"big pong", func(fr *Frame) { fr.SetFin(); fr.SetPong(); fr.SetPayload(make([]byte, 126)) }, false},
+		{"close", func(fr *Frame) { fr.SetFin(); fr.SetClose(); fr.SetStatus(StatusGoAway) }, true},
+		{"close no status", func(fr *Frame) { fr.SetFin(); fr.SetClose() }, true},
+		{"close reserved status", func(fr *Frame) { fr.SetFin(); fr.SetClose(); fr.SetStatus(1005) }, false},
+		{"close app status", func(fr *Frame) { fr.SetFin(); fr.SetClose(); fr.SetStatus(4000) }, true},
+	} {
+		fr.Reset()
+		tc.setup(fr)
+		if err := fr.Validate(); (err == nil) != tc.valid {
+			t.Fatalf("%s: unexpected validation result: %v", tc.name, err)
+		}
+	}
+}
+
+func TestValidateFrame(t *testing.T) {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	for _, tc := range []struct {
+		name       string
+		setup      func(fr *Frame)
+		server     bool
+		extensions bool
+		err        error
+	}{
+		{"masked text", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.Mask() }, true, false, nil},
+		{"unmasked text", func(fr *Frame) { fr.SetFin(); fr.SetText() }, true, false, errUnmaskedFrame},
+		{"server text", func(fr *Frame) { fr.SetFin(); fr.SetText() }, false, false, nil},
+		{"masked server text", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.Mask() }, false, false, errMaskedFrame},
+		{"rsv", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.SetRSV1() }, false, false, errReservedBits},
+		{"rsv negotiated", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.SetRSV1() }, false, true, nil},
+		{"rsv continuation", func(fr *Frame) { fr.SetFin(); fr.SetContinuation(); fr.SetRSV1() }, false, true, errReservedBits},
+		{"rsv2 negotiated", func(fr *Frame) { fr.SetFin(); fr.SetText(); fr.SetRSV2() }, false, true, errReservedBits},
+		{"big ping", func(fr *Frame) { fr.SetFin(); fr.SetPing(); fr.SetPayload(make([]byte, 126)) }, false, true, errControlTooBig},
+		{"close reason", func(fr *Frame) {
+			fr.SetFin()
+			fr.SetClose()
+			fr.SetStatus(StatusNone)
+			fr.SetPayload([]byte("bye"))
+			fr.Mask()
+		}, true, false, nil},
+		{"close invalid reason", func(fr *Frame) {
+			fr.SetFin()
+			fr.SetClose()
+			fr.SetStatus(StatusNone)
+			fr.SetPayload([]byte{0xff, 0xfe})
+			fr.Mask()
+		}, true, false, errInvalidUTF8},
+	} {
+		fr.Reset()
+		tc.setup(fr)
+		if err := ValidateFrame(fr, tc.server, tc.extensions); !errors.Is(err, tc.err) {
+			t.Fatalf("%s: unexpected error: %v <> %v", tc.name, err, tc.err)
+		}
+	}
+}
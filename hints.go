@@ -0,0 +1,76 @@
+package fastws
+
+import (
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Client hint headers, sent optionally by the clients when upgrading
+// so the servers can tailor the connection to the device.
+const (
+	// HeaderMaxMessageSize is the max size in bytes of the messages the client wants to receive.
+	HeaderMaxMessageSize = "X-WS-Max-Message-Size"
+	// HeaderPreferCompression reports whether the client prefers saving bandwidth
+	// over CPU (compression), as a boolean value (1, t, true, 0, f, false).
+	HeaderPreferCompression = "X-WS-Prefer-Compression"
+	// HeaderDeviceClass is the class of the client device, i.e.: mobile or desktop.
+	HeaderDeviceClass = "X-WS-Device-Class"
+)
+
+var (
+	hintMaxMessageSize    = []byte(HeaderMaxMessageSize)
+	hintPreferCompression = []byte(HeaderPreferCompression)
+	hintDeviceClass       = []byte(HeaderDeviceClass)
+)
+
+// ClientHints are the hints sent by the client when upgrading the connection.
+//
+// The hints are advisory: the server decides whether to honor them.
+// The hints not sent or having invalid values are left as zero values.
+type ClientHints struct {
+	// MaxMessageSize is the max size of the messages the client wants to receive.
+	MaxMessageSize uint64
+
+	// PreferCompression reports whether the client prefers compressed messages.
+	PreferCompression bool
+
+	// DeviceClass is the class of the client device.
+	DeviceClass string
+}
+
+// ClientHints returns the hints sent by the client when upgrading conn.
+//
+// The hints are only parsed by the servers.
+func (conn *Conn) ClientHints() ClientHints {
+	return conn.hints
+}
+
+// SetRequestHeaders sets the hints as headers of req,
+// so they can be sent using ClientWithHeaders or DialWithHeaders.
+// The hints having zero values are not set.
+func (h *ClientHints) SetRequestHeaders(req *fasthttp.Request) {
+	if h.MaxMessageSize > 0 {
+		req.Header.SetBytesKV(hintMaxMessageSize, fasthttp.AppendUint(nil, int(h.MaxMessageSize)))
+	}
+	if h.PreferCompression {
+		req.Header.SetBytesK(hintPreferCompression, "true")
+	}
+	if h.DeviceClass != "" {
+		req.Header.SetBytesK(hintDeviceClass, h.DeviceClass)
+	}
+}
+
+// parseClientHints parses the hints of the headers returned by peek.
+func parseClientHints(peek func(key []byte) []byte) (h ClientHints) {
+	if n, err := fasthttp.ParseUint(peek(hintMaxMessageSize)); err == nil {
+		h.MaxMessageSize = uint64(n)
+	}
+	if ok, err := strconv.ParseBool(b2s(peek(hintPreferCompression))); err == nil {
+		h.PreferCompression = ok
+	}
+	if b := peek(hintDeviceClass); len(b) > 0 {
+		h.DeviceClass = string(b)
+	}
+	return h
+}
@@ -0,0 +1,73 @@
+package fastws
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestClientHints(t *testing.T) {
+	hints := make(chan ClientHints, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Handler: func(conn *Conn) {
+			hints <- conn.ClientHints()
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := ClientHints{
+		MaxMessageSize:    1 << 16,
+		PreferCompression: true,
+		DeviceClass:       "mobile",
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	sent.SetRequestHeaders(req)
+
+	conn, err := ClientWithHeaders(c, "http://localhost/", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if h := <-hints; h != sent {
+		t.Fatalf("Unexpected hints: %+v <> %+v", h, sent)
+	}
+}
+
+func TestParseClientHints(t *testing.T) {
+	for _, tc := range []struct {
+		headers map[string]string
+		hints   ClientHints
+	}{
+		{nil, ClientHints{}},
+		{map[string]string{
+			HeaderMaxMessageSize:    "1024",
+			HeaderPreferCompression: "1",
+			HeaderDeviceClass:       "desktop",
+		}, ClientHints{1024, true, "desktop"}},
+		{map[string]string{
+			HeaderMaxMessageSize:    "-1",
+			HeaderPreferCompression: "maybe",
+		}, ClientHints{}},
+	} {
+		h := parseClientHints(func(key []byte) []byte {
+			return []byte(tc.headers[string(key)])
+		})
+		if h != tc.hints {
+			t.Fatalf("Unexpected hints: %+v <> %+v", h, tc.hints)
+		}
+	}
+}
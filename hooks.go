@@ -0,0 +1,41 @@
+package fastws
+
+import (
+	"sync/atomic"
+)
+
+// PoolHooks are the functions called when the Conns and the Frames are
+// taken from and returned to the package pools, so the applications can
+// integrate their lifecycles into their own pools and account for leaks.
+//
+// The hooks are called synchronously in the hot paths, so they must be fast.
+// Any hook can be nil.
+type PoolHooks struct {
+	// AcquireConn is called when a Conn is acquired,
+	// either by the upgraders or by the clients.
+	AcquireConn func(conn *Conn)
+	// ReleaseConn is called when a Conn is returned to the pool, after its
+	// handler returned. The client connections are not returned to the pool.
+	ReleaseConn func(conn *Conn)
+
+	// AcquireFrame is called when a Frame is acquired using AcquireFrame.
+	AcquireFrame func(fr *Frame)
+	// ReleaseFrame is called when a Frame is released using ReleaseFrame,
+	// before resetting it.
+	ReleaseFrame func(fr *Frame)
+}
+
+// poolHooks holds the *PoolHooks set using SetPoolHooks.
+var poolHooks atomic.Value
+
+// SetPoolHooks sets the hooks called by the pools. nil removes them.
+//
+// The hooks are global, so they are usually set once when starting.
+func SetPoolHooks(hooks *PoolHooks) {
+	poolHooks.Store(hooks)
+}
+
+func loadPoolHooks() *PoolHooks {
+	hooks, _ := poolHooks.Load().(*PoolHooks)
+	return hooks
+}
@@ -0,0 +1,78 @@
+package fastws
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestPoolHooksFrame(t *testing.T) {
+	var (
+		lck      sync.Mutex
+		acquired = make(map[*Frame]bool)
+	)
+	SetPoolHooks(&PoolHooks{
+		AcquireFrame: func(fr *Frame) {
+			lck.Lock()
+			acquired[fr] = true
+			lck.Unlock()
+		},
+		ReleaseFrame: func(fr *Frame) {
+			lck.Lock()
+			delete(acquired, fr)
+			lck.Unlock()
+		},
+	})
+	defer SetPoolHooks(nil)
+
+	fr := AcquireFrame()
+	lck.Lock()
+	ok := acquired[fr]
+	lck.Unlock()
+	if !ok {
+		t.Fatal("AcquireFrame hook not called")
+	}
+
+	ReleaseFrame(fr)
+	lck.Lock()
+	ok = acquired[fr]
+	lck.Unlock()
+	if ok {
+		t.Fatal("ReleaseFrame hook not called")
+	}
+}
+
+func TestPoolHooksConn(t *testing.T) {
+	handled := make(chan *Conn, 1)
+	released := make(chan *Conn, 4)
+
+	SetPoolHooks(&PoolHooks{
+		ReleaseConn: func(conn *Conn) {
+			released <- conn
+		},
+	})
+	defer SetPoolHooks(nil)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Handler: func(conn *Conn) {
+			handled <- conn
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+	// replying to the close frame.
+	go conn.ReadMessage(nil)
+
+	if c := <-handled; c != <-released {
+		t.Fatal("Unexpected connection released")
+	}
+}
@@ -0,0 +1,348 @@
+package fastws
+
+import (
+	"sync"
+)
+
+// Hub broadcasts messages to the connections registered.
+//
+// Every message is encoded once (see PreparedMessage) and queued to every connection,
+// which is written by its own goroutine, so a slow peer doesn't delay the others.
+// The connections whose queue gets full (slow) or failing to write (dead)
+// are removed from the Hub and closed, unless OnRemove is set.
+//
+// The connections can join named rooms (see Join), so the messages
+// can be published to the members of a room only (see Publish).
+//
+// The connections must be registered by their handler, which keeps reading:
+//
+//	hub.Register(conn)
+//	defer hub.Unregister(conn)
+//	for {
+//		_, msg, err := conn.ReadMessage(msg[:0])
+//		...
+//	}
+type Hub struct {
+	// QueueSize is the max number of messages queued per connection.
+	//
+	// By default QueueSize is DefaultQueueSize.
+	QueueSize int
+
+	// OnRemove, if not nil, is called when the connection is removed because
+	// its queue got full (the error is ErrQueueFull) or writing failed,
+	// instead of closing the connection.
+	// Unregister waits for OnRemove to return, so OnRemove must not call it.
+	OnRemove func(conn *Conn, err error)
+
+	lck   sync.RWMutex
+	conns map[*Conn]*hubConn
+	rooms map[string]map[*hubConn]struct{}
+	// removing are the connections removed whose writer
+	// or OnRemove (see release) didn't finish yet.
+	removing map[*Conn]*hubConn
+}
+
+type hubConn struct {
+	conn *Conn
+	msgs chan *PreparedMessage
+	// rooms are the rooms joined, guarded by Hub.lck.
+	rooms map[string]struct{}
+	// quit is closed when the connection is removed,
+	// and done when its writer and the removal callback return.
+	quit chan struct{}
+	done chan struct{}
+	// refs counts the writer and the removal callback, guarded by Hub.lck.
+	refs int
+}
+
+// Register adds conn to h.
+// Registering a connection already registered does nothing.
+func (h *Hub) Register(conn *Conn) {
+	h.lck.Lock()
+	h.register(conn)
+	h.lck.Unlock()
+}
+
+// register registers conn if needed, returning its hubConn. h.lck must be held.
+func (h *Hub) register(conn *Conn) *hubConn {
+	if hc := h.conns[conn]; hc != nil {
+		return hc
+	}
+	if h.conns == nil {
+		h.conns = make(map[*Conn]*hubConn)
+	}
+
+	size := h.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	hc := &hubConn{
+		conn: conn,
+		msgs: make(chan *PreparedMessage, size),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+		refs: 1,
+	}
+	h.conns[conn] = hc
+
+	go h.writer(hc)
+
+	return hc
+}
+
+// Unregister removes conn from h and from the rooms joined,
+// waiting for the write in progress (if any) and for OnRemove
+// if conn was removed. The messages queued are discarded.
+func (h *Hub) Unregister(conn *Conn) {
+	h.lck.Lock()
+	hc := h.conns[conn]
+	if hc != nil {
+		h.remove(hc)
+	} else {
+		hc = h.removing[conn]
+	}
+	h.lck.Unlock()
+
+	if hc != nil {
+		<-hc.done
+	}
+}
+
+// Len returns the number of connections registered.
+func (h *Hub) Len() int {
+	h.lck.RLock()
+	defer h.lck.RUnlock()
+
+	return len(h.conns)
+}
+
+// Broadcast queues b to be written to every connection using mode.
+//
+// b is not copied, so it must not be modified after calling Broadcast.
+func (h *Hub) Broadcast(mode Mode, b []byte) {
+	msg := prepareMessage(mode, b)
+
+	var slow []*hubConn
+
+	h.lck.RLock()
+	for _, hc := range h.conns {
+		slow = hc.queue(msg, slow)
+	}
+	h.lck.RUnlock()
+
+	h.removeSlow(slow)
+}
+
+// Send queues b to be written to conn using mode.
+//
+// Send returns EOF if conn is not registered and ErrQueueFull if its queue
+// is full, removing conn as Broadcast does.
+// b is not copied, so it must not be modified after calling Send.
+func (h *Hub) Send(conn *Conn, mode Mode, b []byte) error {
+	return h.send(conn, prepareMessage(mode, b))
+}
+
+// send queues msg to conn, returning EOF if conn is not registered
+// and ErrQueueFull if its queue is full (removing conn).
+func (h *Hub) send(conn *Conn, msg *PreparedMessage) error {
+	var slow []*hubConn
+
+	h.lck.RLock()
+	hc := h.conns[conn]
+	if hc != nil {
+		slow = hc.queue(msg, slow)
+	}
+	h.lck.RUnlock()
+
+	if hc == nil {
+		return EOF
+	}
+	if len(slow) > 0 {
+		h.removeSlow(slow)
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Join adds conn to room, registering conn if needed.
+func (h *Hub) Join(conn *Conn, room string) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+
+	hc := h.register(conn)
+	if hc.rooms == nil {
+		hc.rooms = make(map[string]struct{})
+	}
+	hc.rooms[room] = struct{}{}
+
+	if h.rooms == nil {
+		h.rooms = make(map[string]map[*hubConn]struct{})
+	}
+	members := h.rooms[room]
+	if members == nil {
+		members = make(map[*hubConn]struct{})
+		h.rooms[room] = members
+	}
+	members[hc] = struct{}{}
+}
+
+// Leave removes conn from room. conn keeps registered in h.
+func (h *Hub) Leave(conn *Conn, room string) {
+	h.lck.Lock()
+	if hc := h.conns[conn]; hc != nil {
+		h.leave(hc, room)
+	}
+	h.lck.Unlock()
+}
+
+// Members returns the number of connections in room.
+func (h *Hub) Members(room string) int {
+	h.lck.RLock()
+	defer h.lck.RUnlock()
+
+	return len(h.rooms[room])
+}
+
+// Publish queues b to be written to the connections in room using mode.
+//
+// b is not copied, so it must not be modified after calling Publish.
+func (h *Hub) Publish(room string, mode Mode, b []byte) {
+	msg := prepareMessage(mode, b)
+
+	var slow []*hubConn
+
+	h.lck.RLock()
+	for hc := range h.rooms[room] {
+		slow = hc.queue(msg, slow)
+	}
+	h.lck.RUnlock()
+
+	h.removeSlow(slow)
+}
+
+// Close unregisters all the connections.
+func (h *Hub) Close() {
+	h.lck.Lock()
+	hcs := make([]*hubConn, 0, len(h.conns)+len(h.removing))
+	for _, hc := range h.conns {
+		h.remove(hc)
+	}
+	for _, hc := range h.removing {
+		hcs = append(hcs, hc)
+	}
+	h.lck.Unlock()
+
+	for _, hc := range hcs {
+		<-hc.done
+	}
+}
+
+func (h *Hub) writer(hc *hubConn) {
+	defer h.release(hc)
+
+	for {
+		select {
+		case msg := <-hc.msgs:
+			select {
+			case <-hc.quit:
+				return
+			default:
+			}
+			if _, err := hc.conn.WritePrepared(msg); err != nil {
+				if h.removeConn(hc) {
+					h.removed(hc.conn, err)
+					h.release(hc)
+				}
+				return
+			}
+		case <-hc.quit:
+			return
+		}
+	}
+}
+
+// removeConn removes hc, returning false if it was removed before.
+// If removed, hc must be released after calling the removal callback.
+func (h *Hub) removeConn(hc *hubConn) bool {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+
+	if h.conns[hc.conn] != hc {
+		return false
+	}
+	hc.refs++
+	h.remove(hc)
+	return true
+}
+
+// release releases a reference of hc, closing done when
+// neither its writer nor the removal callback are running.
+func (h *Hub) release(hc *hubConn) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+
+	hc.refs--
+	if hc.refs > 0 {
+		return
+	}
+	if h.removing[hc.conn] == hc {
+		delete(h.removing, hc.conn)
+	}
+	close(hc.done)
+}
+
+// remove removes hc from h and its rooms. h.lck must be held.
+func (h *Hub) remove(hc *hubConn) {
+	for room := range hc.rooms {
+		h.leave(hc, room)
+	}
+	delete(h.conns, hc.conn)
+	if h.removing == nil {
+		h.removing = make(map[*Conn]*hubConn)
+	}
+	h.removing[hc.conn] = hc
+	close(hc.quit)
+}
+
+// leave removes hc from room, deleting the room if empty. h.lck must be held.
+func (h *Hub) leave(hc *hubConn, room string) {
+	delete(hc.rooms, room)
+
+	members := h.rooms[room]
+	delete(members, hc)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// removeSlow removes the connections whose queue got full.
+func (h *Hub) removeSlow(slow []*hubConn) {
+	for _, hc := range slow {
+		if h.removeConn(hc) {
+			// the writer can be blocked writing, so it can't call it.
+			go func(hc *hubConn) {
+				h.removed(hc.conn, ErrQueueFull)
+				h.release(hc)
+			}(hc)
+		}
+	}
+}
+
+// queue queues msg, appending hc to slow if its queue is full.
+func (hc *hubConn) queue(msg *PreparedMessage, slow []*hubConn) []*hubConn {
+	select {
+	case hc.msgs <- msg:
+	default:
+		slow = append(slow, hc)
+	}
+	return slow
+}
+
+func (h *Hub) removed(conn *Conn, err error) {
+	if h.OnRemove != nil {
+		h.OnRemove(conn, err)
+		return
+	}
+	conn.Close()
+}
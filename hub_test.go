@@ -0,0 +1,175 @@
+package fastws
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHubBroadcast(t *testing.T) {
+	var hub Hub
+	defer hub.Close()
+
+	clients := make([]*Conn, 3)
+	for i := range clients {
+		server, client := pipeConns()
+		defer client.mustClose(false)
+		defer server.mustClose(false)
+
+		hub.Register(server)
+		clients[i] = client
+	}
+	if n := hub.Len(); n != 3 {
+		t.Fatalf("Unexpected length: %d <> 3", n)
+	}
+
+	hub.Broadcast(ModeBinary, []byte("Hello"))
+
+	for _, client := range clients {
+		mode, b, err := client.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != ModeBinary || string(b) != "Hello" {
+			t.Fatalf("Unexpected message: %v %q", mode, b)
+		}
+	}
+}
+
+func TestHubSlowConn(t *testing.T) {
+	removed := make(chan error, 1)
+	hub := Hub{
+		QueueSize: 1,
+		OnRemove: func(conn *Conn, err error) {
+			removed <- err
+		},
+	}
+	defer hub.Close()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close() // never read, so the writes block.
+
+	conn := acquireConn(c1)
+	conn.server = true
+	conn.WriteTimeout = 0
+	defer conn.mustClose(false)
+
+	hub.Register(conn)
+	for i := 0; i < 3; i++ {
+		hub.Broadcast(ModeText, []byte("Hello"))
+	}
+
+	select {
+	case err := <-removed:
+		if err != ErrQueueFull {
+			t.Fatalf("Unexpected error: %v <> %v", err, ErrQueueFull)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Slow connection not removed")
+	}
+	if n := hub.Len(); n != 0 {
+		t.Fatalf("Unexpected length: %d <> 0", n)
+	}
+	c2.Close() // unblocks the writer.
+}
+
+func TestHubUnregisterWaitsRemove(t *testing.T) {
+	removing := make(chan struct{})
+	unblock := make(chan struct{})
+	hub := Hub{
+		QueueSize: 1,
+		OnRemove: func(conn *Conn, err error) {
+			close(removing)
+			<-unblock
+		},
+	}
+	defer hub.Close()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	conn := acquireConn(c1)
+	conn.server = true
+	conn.WriteTimeout = 0
+	defer conn.mustClose(false)
+
+	hub.Register(conn)
+	for i := 0; i < 3; i++ {
+		hub.Broadcast(ModeText, []byte("Hello"))
+	}
+	<-removing
+
+	unregistered := make(chan struct{})
+	go func() {
+		hub.Unregister(conn)
+		close(unregistered)
+	}()
+
+	select {
+	case <-unregistered:
+		t.Fatal("Unregister returned before OnRemove")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(unblock)
+	c2.Close() // unblocks the writer.
+	select {
+	case <-unregistered:
+	case <-time.After(time.Second):
+		t.Fatal("Unregister didn't return")
+	}
+}
+
+func TestHubRooms(t *testing.T) {
+	var hub Hub
+	defer hub.Close()
+
+	servers := make([]*Conn, 2)
+	clients := make([]*Conn, 2)
+	for i := range clients {
+		server, client := pipeConns()
+		defer client.mustClose(false)
+		defer server.mustClose(false)
+
+		servers[i], clients[i] = server, client
+	}
+
+	hub.Join(servers[0], "lobby")
+	hub.Join(servers[0], "game")
+	hub.Join(servers[1], "lobby")
+
+	if n := hub.Len(); n != 2 {
+		t.Fatalf("Unexpected length: %d <> 2", n)
+	}
+	if n := hub.Members("lobby"); n != 2 {
+		t.Fatalf("Unexpected members: %d <> 2", n)
+	}
+
+	// only the first connection is in the game room,
+	// so the second one reads the lobby message only.
+	hub.Publish("game", ModeText, []byte("game"))
+	hub.Publish("lobby", ModeText, []byte("lobby"))
+
+	expected := [][]string{{"game", "lobby"}, {"lobby"}}
+	for i, client := range clients {
+		for _, s := range expected[i] {
+			_, b, err := client.ReadMessage(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != s {
+				t.Fatalf("Unexpected message: %q <> %q", b, s)
+			}
+		}
+	}
+
+	hub.Leave(servers[0], "game")
+	if n := hub.Members("game"); n != 0 {
+		t.Fatalf("Unexpected members: %d <> 0", n)
+	}
+
+	hub.Unregister(servers[1])
+	if n := hub.Members("lobby"); n != 1 {
+		t.Fatalf("Unexpected members: %d <> 1", n)
+	}
+}
@@ -0,0 +1,91 @@
+package fastws
+
+import (
+	"sync"
+	"time"
+)
+
+// Job is a function scheduled on a Conn using Every or After.
+//
+// The jobs are stopped when the connection is closed,
+// so the periodic pushes don't outlive the connection.
+type Job struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Stop stops j. The run in progress, if any, is not interrupted.
+// Calling Stop again does nothing.
+func (j *Job) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
+
+// Done returns a channel closed when j won't run anymore, either because
+// it has been stopped, the connection was closed or fn failed.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Every calls fn every d until the connection is closed, the Job is stopped
+// or fn returns an error.
+//
+// fn is called from its own goroutine, so it can write to the connection
+// as any other writer. The next run is scheduled when fn returns,
+// so the runs don't overlap.
+func (conn *Conn) Every(d time.Duration, fn func() error) *Job {
+	return conn.schedule(d, fn, true)
+}
+
+// After calls fn once after d, unless the connection is closed
+// or the Job is stopped before.
+//
+// fn is called from its own goroutine as Every does.
+func (conn *Conn) After(d time.Duration, fn func() error) *Job {
+	return conn.schedule(d, fn, false)
+}
+
+func (conn *Conn) schedule(d time.Duration, fn func() error, repeat bool) *Job {
+	j := &Job{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	conn.lck.Lock()
+	closed := conn.closed
+	done := conn.readDone
+	conn.lck.Unlock()
+
+	if closed {
+		close(j.done)
+	} else {
+		go conn.runJob(j, d, fn, repeat, done)
+	}
+
+	return j
+}
+
+func (conn *Conn) runJob(j *Job, d time.Duration, fn func() error, repeat bool, done <-chan struct{}) {
+	defer close(j.done)
+
+	clock := conn.getClock()
+
+	for {
+		timer := clock.NewTimer(d)
+		select {
+		case <-timer.C():
+		case <-j.stop:
+			timer.Stop()
+			return
+		case <-done:
+			timer.Stop()
+			return
+		}
+
+		if conn.isClosed() || fn() != nil || !repeat {
+			return
+		}
+	}
+}
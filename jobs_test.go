@@ -0,0 +1,105 @@
+package fastws
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnEvery(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	clock := newFakeClock()
+	server.SetClock(clock)
+
+	runs := make(chan struct{}, 3)
+	j := server.Every(time.Second, func() error {
+		runs <- struct{}{}
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		clock.waitTimers(t, 1)
+		clock.Advance(time.Second)
+		<-runs
+	}
+
+	// closing the connection stops the job.
+	clock.waitTimers(t, 1)
+	server.mustClose(false)
+
+	select {
+	case <-j.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Job not stopped after closing")
+	}
+	if len(runs) != 0 {
+		t.Fatalf("Unexpected runs: %d", len(runs))
+	}
+
+	j = server.Every(time.Second, func() error {
+		return nil
+	})
+	select {
+	case <-j.Done():
+	default:
+		t.Fatal("Job scheduled on a closed connection")
+	}
+}
+
+func TestConnEveryError(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	clock := newFakeClock()
+	server.SetClock(clock)
+
+	n := 0
+	j := server.Every(time.Second, func() error {
+		n++
+		return errors.New("failed")
+	})
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	<-j.Done()
+
+	if n != 1 {
+		t.Fatalf("Unexpected runs: %d <> 1", n)
+	}
+}
+
+func TestConnAfter(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	clock := newFakeClock()
+	server.SetClock(clock)
+
+	j := server.After(time.Second, func() error {
+		_, err := server.WriteString("Hello")
+		return err
+	})
+
+	stopped := server.After(time.Second, func() error {
+		t.Error("Unexpected run of a stopped job")
+		return nil
+	})
+	stopped.Stop()
+	<-stopped.Done()
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+
+	_, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %q", b)
+	}
+	<-j.Done()
+}
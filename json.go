@@ -0,0 +1,44 @@
+package fastws
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+//
+// The message is read using a pooled buffer, so v can't keep references to it
+// (json.RawMessage values are copied by encoding/json).
+func (conn *Conn) ReadJSON(v interface{}) error {
+	b := bytePool.Get().([]byte)
+	_, b, err := conn.ReadMessage(b[:0])
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	bytePool.Put(b)
+
+	return err
+}
+
+// WriteJSON encodes v as JSON and writes it as a text message.
+//
+// As with json.Encoder the message ends with a newline.
+func (conn *Conn) WriteJSON(v interface{}) error {
+	bf := jsonBufferPool.Get().(*bytes.Buffer)
+	bf.Reset()
+
+	err := json.NewEncoder(bf).Encode(v)
+	if err == nil {
+		_, err = conn.WriteMessage(ModeText, bf.Bytes())
+	}
+	jsonBufferPool.Put(bf)
+
+	return err
+}
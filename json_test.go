@@ -0,0 +1,34 @@
+package fastws
+
+import (
+	"testing"
+)
+
+type jsonMessage struct {
+	Type string `json:"type"`
+	Data []int  `json:"data"`
+}
+
+func TestJSON(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go client.WriteJSON(&jsonMessage{
+		Type: "numbers",
+		Data: []int{1, 2, 3},
+	})
+
+	var msg jsonMessage
+	if err := server.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "numbers" || len(msg.Data) != 3 || msg.Data[2] != 3 {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	go client.WriteString("{")
+	if err := server.ReadJSON(&msg); err == nil {
+		t.Fatal("Expected error")
+	}
+}
@@ -0,0 +1,73 @@
+package fastws
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrKeepAliveTimeout is the error which closes the connection
+// when the peer doesn't answer the keepalive PINGs in time.
+var ErrKeepAliveTimeout = errors.New("keepalive timeout")
+
+// EnableKeepAlive pings the peer when nothing has been received
+// for interval, closing the connection if the PONG doesn't arrive within timeout.
+//
+// The PONGs are matched by the readLoop (see Ping), so the connection
+// is kept alive even when nobody is reading messages.
+// After closing the connection the read functions return EOF.
+//
+// By default (timeout <= 0) timeout is interval.
+// Calling EnableKeepAlive again replaces the previous settings
+// and an interval <= 0 disables the keepalive.
+func (conn *Conn) EnableKeepAlive(interval, timeout time.Duration) {
+	conn.lck.Lock()
+	if conn.keepAliveStop != nil {
+		close(conn.keepAliveStop)
+		conn.keepAliveStop = nil
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	if interval > 0 && !conn.closed {
+		conn.keepAliveStop = make(chan struct{})
+		go conn.keepAlive(interval, timeout, conn.keepAliveStop, conn.readDone)
+	}
+	conn.lck.Unlock()
+}
+
+func (conn *Conn) keepAlive(interval, timeout time.Duration, stop, done <-chan struct{}) {
+	clock := conn.getClock()
+
+	for {
+		ticker := clock.NewTimer(interval)
+		select {
+		case <-ticker.C():
+		case <-stop:
+			ticker.Stop()
+			return
+		case <-done:
+			ticker.Stop()
+			return
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&conn.stats.lastRead))
+		if clock.Now().Sub(last) < interval {
+			continue
+		}
+
+		timer := clock.NewTimer(timeout)
+		_, err := conn.ping(nil, stop, timer.C())
+		timer.Stop()
+
+		switch {
+		case err == nil:
+		case err == errPingTimeout:
+			conn.closeWithError(ErrKeepAliveTimeout, StatusGoAway)
+			return
+		default:
+			// stopped, closed or failed writing.
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package fastws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveTimeout(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	// nobody reads from client, so the PINGs are not answered.
+	server.EnableKeepAlive(time.Millisecond*10, time.Millisecond*20)
+
+	_, _, err := server.ReadMessage(nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err := server.readError(); err != ErrKeepAliveTimeout {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrKeepAliveTimeout)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go client.ReadMessage(nil)
+
+	server.EnableKeepAlive(time.Millisecond*10, time.Millisecond*50)
+
+	time.Sleep(time.Millisecond * 100)
+	if server.isClosed() {
+		t.Fatalf("Connection closed: %v", server.readError())
+	}
+
+	server.EnableKeepAlive(0, 0)
+	if server.keepAliveStop != nil {
+		t.Fatal("The keepalive must be disabled")
+	}
+}
@@ -0,0 +1,165 @@
+package fastws
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	// MaxLabels is the max number of labels a connection can have.
+	MaxLabels = 8
+	// DefaultMaxSeries is the default max number of series of a LabelCollector.
+	DefaultMaxSeries = 100
+	// OverflowLabelValue is the value of the labels of the series
+	// aggregating the connections exceeding the max number of series.
+	OverflowLabelValue = "other"
+)
+
+// ErrTooManyLabels is returned when setting more than MaxLabels labels.
+var ErrTooManyLabels = errors.New("too many labels")
+
+// Label is a key/value pair identifying a segment of connections
+// (tenant, plan, region) the metrics are aggregated by.
+type Label struct {
+	Key   string
+	Value string
+}
+
+// SetLabel sets the label key to value, replacing the previous value.
+//
+// Labels are meant to be low cardinality values, so a connection
+// can have at most MaxLabels labels.
+func (conn *Conn) SetLabel(key, value string) error {
+	conn.labelLck.Lock()
+	defer conn.labelLck.Unlock()
+
+	for i := range conn.labels {
+		if conn.labels[i].Key == key {
+			conn.labels[i].Value = value
+			return nil
+		}
+	}
+	if len(conn.labels) >= MaxLabels {
+		return ErrTooManyLabels
+	}
+	conn.labels = append(conn.labels, Label{Key: key, Value: value})
+
+	return nil
+}
+
+// Label returns the value of the label key, empty if not set.
+func (conn *Conn) Label(key string) string {
+	conn.labelLck.Lock()
+	defer conn.labelLck.Unlock()
+
+	for _, l := range conn.labels {
+		if l.Key == key {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Labels returns the labels of conn sorted by key.
+func (conn *Conn) Labels() []Label {
+	conn.labelLck.Lock()
+	labels := append([]Label(nil), conn.labels...)
+	conn.labelLck.Unlock()
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Key < labels[j].Key
+	})
+
+	return labels
+}
+
+// Series is the aggregation of the statistics of the connections sharing the same labels.
+type Series struct {
+	// Labels are the labels of the connections, in the order of LabelCollector.Keys.
+	Labels []Label
+	// Conns is the number of connections.
+	Conns int
+	// Stats is the sum of the statistics of the connections.
+	Stats ConnStats
+}
+
+// LabelCollector aggregates the statistics of the connections by their labels,
+// so per-segment dashboards don't need a series per connection.
+type LabelCollector struct {
+	// Keys are the label keys the connections are aggregated by.
+	// Other labels are ignored and the labels not set are empty.
+	Keys []string
+
+	// MaxSeries limits the number of series returned by Collect.
+	// The connections exceeding it are aggregated into a series
+	// whose labels have OverflowLabelValue as value.
+	//
+	// By default MaxSeries is DefaultMaxSeries.
+	MaxSeries int
+}
+
+// Collect returns the series of conns, sorted by the first connection seen of every series.
+func (lc *LabelCollector) Collect(conns []*Conn) []Series {
+	max := lc.MaxSeries
+	if max <= 0 {
+		max = DefaultMaxSeries
+	}
+
+	var (
+		series   []Series
+		overflow *Series
+		index    = make(map[string]int)
+		values   = make([]string, len(lc.Keys))
+	)
+	for _, conn := range conns {
+		for i, key := range lc.Keys {
+			values[i] = conn.Label(key)
+		}
+
+		var s *Series
+		id := strings.Join(values, "\x00")
+		if i, ok := index[id]; ok {
+			s = &series[i]
+		} else if len(series) < max {
+			index[id] = len(series)
+			series = append(series, Series{
+				Labels: lc.labels(values),
+			})
+			s = &series[len(series)-1]
+		} else {
+			if overflow == nil {
+				for i := range values {
+					values[i] = OverflowLabelValue
+				}
+				overflow = &Series{
+					Labels: lc.labels(values),
+				}
+			}
+			s = overflow
+		}
+
+		stats := conn.Stats()
+		s.Conns++
+		s.Stats.WriteLocks += stats.WriteLocks
+		s.Stats.WriteLocksContended += stats.WriteLocksContended
+		s.Stats.WriteLockWait += stats.WriteLockWait
+		s.Stats.MessagesRead += stats.MessagesRead
+		s.Stats.BytesRead += stats.BytesRead
+		s.Stats.MessagesWritten += stats.MessagesWritten
+		s.Stats.BytesWritten += stats.BytesWritten
+	}
+	if overflow != nil {
+		series = append(series, *overflow)
+	}
+
+	return series
+}
+
+func (lc *LabelCollector) labels(values []string) []Label {
+	labels := make([]Label, len(lc.Keys))
+	for i, key := range lc.Keys {
+		labels[i] = Label{Key: key, Value: values[i]}
+	}
+	return labels
+}
@@ -0,0 +1,92 @@
+package fastws
+
+import (
+	"testing"
+)
+
+func TestConnLabels(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetLabel("tenant", "acme")
+	server.SetLabel("plan", "free")
+	server.SetLabel("plan", "pro")
+
+	if v := server.Label("plan"); v != "pro" {
+		t.Fatalf("Unexpected label: %s <> pro", v)
+	}
+	if v := server.Label("region"); v != "" {
+		t.Fatalf("Unexpected label: %s", v)
+	}
+
+	labels := server.Labels()
+	if len(labels) != 2 || labels[0] != (Label{"plan", "pro"}) || labels[1] != (Label{"tenant", "acme"}) {
+		t.Fatalf("Unexpected labels: %v", labels)
+	}
+
+	for i := len(labels); i < MaxLabels; i++ {
+		if err := server.SetLabel(string(rune('a'+i)), "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.SetLabel("region", "eu"); err != ErrTooManyLabels {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrTooManyLabels)
+	}
+}
+
+func TestLabelCollector(t *testing.T) {
+	var conns, clients []*Conn
+	for _, tenant := range []string{"a", "b", "a", "c", "d"} {
+		server, client := pipeConns()
+		defer client.mustClose(false)
+		defer server.mustClose(false)
+
+		server.SetLabel("tenant", tenant)
+		server.SetLabel("id", tenant+"1") // ignored
+		conns = append(conns, server)
+		clients = append(clients, client)
+	}
+	conns[0].WriteString("Hello")
+	conns[2].WriteString("Hi")
+
+	go clients[1].WriteString("Hey")
+	if _, _, err := conns[1].ReadMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lc := &LabelCollector{
+		Keys:      []string{"tenant", "region"},
+		MaxSeries: 2,
+	}
+
+	series := lc.Collect(conns)
+	if len(series) != 3 {
+		t.Fatalf("Unexpected series: %v", series)
+	}
+	for i, tc := range []struct {
+		tenant string
+		conns  int
+	}{
+		{"a", 2},
+		{"b", 1},
+		{OverflowLabelValue, 2},
+	} {
+		s := series[i]
+		if s.Labels[0] != (Label{"tenant", tc.tenant}) || s.Conns != tc.conns {
+			t.Fatalf("Unexpected series: %v", s)
+		}
+		if s.Labels[1].Key != "region" {
+			t.Fatalf("Unexpected label: %v", s.Labels[1])
+		}
+	}
+	if series[0].Stats.WriteLocks == 0 {
+		t.Fatal("The stats were not aggregated")
+	}
+	if stats := series[0].Stats; stats.MessagesWritten != 2 || stats.BytesWritten != 7 {
+		t.Fatalf("Unexpected writes: %d %d", stats.MessagesWritten, stats.BytesWritten)
+	}
+	if stats := series[1].Stats; stats.MessagesRead != 1 || stats.BytesRead != 3 {
+		t.Fatalf("Unexpected reads: %d %d", stats.MessagesRead, stats.BytesRead)
+	}
+}
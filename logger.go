@@ -0,0 +1,111 @@
+package fastws
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// LogLevel is the severity of the messages logged by a Logger.
+type LogLevel uint8
+
+const (
+	// LogDebug is the level of the frames read and written (see Conn.SetLogger).
+	LogDebug LogLevel = iota
+	// LogInfo is the level of the failed upgrades.
+	LogInfo
+	// LogWarn is the level of the errors closing the connections.
+	LogWarn
+	// LogError is the level of the errors the connections can't recover from.
+	LogError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return strconv.Itoa(int(level))
+}
+
+// Logger logs the events of the upgrades and the connections
+// (see Upgrader.Logger and Conn.SetLogger).
+type Logger interface {
+	// Enabled returns whether the messages of level are logged,
+	// so the messages not logged are not formatted.
+	Enabled(level LogLevel) bool
+	// Logf logs the message formatted using format and args as fmt.Sprintf does.
+	Logf(level LogLevel, format string, args ...interface{})
+}
+
+// NewLogger returns a Logger printing to l the messages of level or above,
+// prefixed by their level. A nil l prints using the standard logger.
+//
+// LogDebug traces the header of every frame, so it's meant for diagnosing.
+func NewLogger(l *log.Logger, level LogLevel) Logger {
+	return &stdLogger{
+		l:     l,
+		level: level,
+	}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level LogLevel
+}
+
+func (sl *stdLogger) Enabled(level LogLevel) bool {
+	return level >= sl.level
+}
+
+func (sl *stdLogger) Logf(level LogLevel, format string, args ...interface{}) {
+	if !sl.Enabled(level) {
+		return
+	}
+	s := level.String() + ": " + fmt.Sprintf(format, args...)
+	if sl.l == nil {
+		log.Print(s)
+	} else {
+		sl.l.Print(s)
+	}
+}
+
+// loggerHolder allows storing any Logger (even nil) in an atomic.Value.
+type loggerHolder struct {
+	Logger
+}
+
+// SetLogger sets the Logger of conn. nil removes it.
+//
+// At LogDebug the header (opcode, FIN, length and mask) of every frame
+// read or written is logged, including the control frames.
+func (conn *Conn) SetLogger(l Logger) {
+	conn.logger.Store(loggerHolder{l})
+}
+
+func (conn *Conn) getLogger() Logger {
+	return conn.logger.Load().(loggerHolder).Logger
+}
+
+// logf logs the message using the Logger of conn, if any and enabled.
+func (conn *Conn) logf(level LogLevel, format string, args ...interface{}) {
+	if l := conn.getLogger(); l != nil && l.Enabled(level) {
+		l.Logf(level, format, args...)
+	}
+}
+
+// logFrame logs the header of a frame read or written.
+func (conn *Conn) logFrame(dir Direction, code Code, fin, masked bool, length uint64) {
+	l := conn.getLogger()
+	if l == nil || !l.Enabled(LogDebug) {
+		return
+	}
+	l.Logf(LogDebug, "%s frame: opcode=%d fin=%v length=%d mask=%v",
+		dir, code, fin, length, masked)
+}
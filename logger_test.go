@@ -0,0 +1,42 @@
+package fastws
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFrames(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var bf bytes.Buffer
+	server.SetLogger(NewLogger(log.New(&bf, "", 0), LogDebug))
+
+	go client.WriteString("Hello")
+	if _, _, err := server.ReadMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "debug: in frame: opcode=1 fin=true length=5 mask=true"
+	if s := bf.String(); !strings.HasPrefix(s, expected) {
+		t.Fatalf("Unexpected log: %q <> %q", s, expected)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var bf bytes.Buffer
+	l := NewLogger(log.New(&bf, "", 0), LogWarn)
+
+	if l.Enabled(LogInfo) || !l.Enabled(LogError) {
+		t.Fatal("Unexpected levels enabled")
+	}
+
+	l.Logf(LogInfo, "skipped")
+	l.Logf(LogError, "failed %d", 1)
+	if s := bf.String(); s != "error: failed 1\n" {
+		t.Fatalf("Unexpected log: %q", s)
+	}
+}
@@ -10,6 +10,14 @@ func mask(mask, b []byte) {
 	}
 }
 
+// maskAt masks b as the part of the payload starting at pos,
+// as the key is applied to the whole payload.
+func maskAt(mask, b []byte, pos int) {
+	for i := range b {
+		b[i] ^= mask[(pos+i)&3]
+	}
+}
+
 func readMask(b []byte) {
 	rand.Read(b)
 }
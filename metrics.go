@@ -0,0 +1,94 @@
+package fastws
+
+import (
+	"sync/atomic"
+)
+
+// Metrics receives the events of the connections, so they can be reported
+// to the telemetry systems (see Upgrader.Metrics and Conn.SetMetrics).
+//
+// The methods are called synchronously by the goroutine causing the event,
+// so they must be fast and safe for concurrent use.
+// NopMetrics can be embedded to implement only some of them.
+// The events can be segmented by the labels of the connection (see Conn.Labels),
+// or aggregated by label from the Stats using a LabelCollector.
+type Metrics interface {
+	// OnUpgrade is called when a connection is upgraded, before Upgrader.OnConnect.
+	OnUpgrade(conn *Conn)
+	// OnHandshakeError is called when an upgrade fails
+	// with the status code and the reason responded.
+	OnHandshakeError(status int, reason string)
+	// OnMessageRead is called when a message of size bytes is read.
+	OnMessageRead(conn *Conn, size int)
+	// OnMessageWrite is called when a message of size bytes has been written,
+	// whatever the way of writing it (see Conn.SetMetrics).
+	OnMessageWrite(conn *Conn, size int)
+	// OnClose is called once when conn terminates,
+	// with the error returned by Conn.Err.
+	OnClose(conn *Conn, err error)
+}
+
+// NopMetrics is a Metrics doing nothing.
+type NopMetrics struct{}
+
+// OnUpgrade implements Metrics.
+func (NopMetrics) OnUpgrade(conn *Conn) {}
+
+// OnHandshakeError implements Metrics.
+func (NopMetrics) OnHandshakeError(status int, reason string) {}
+
+// OnMessageRead implements Metrics.
+func (NopMetrics) OnMessageRead(conn *Conn, size int) {}
+
+// OnMessageWrite implements Metrics.
+func (NopMetrics) OnMessageWrite(conn *Conn, size int) {}
+
+// OnClose implements Metrics.
+func (NopMetrics) OnClose(conn *Conn, err error) {}
+
+// metricsHolder allows storing any Metrics (even nil) in an atomic.Value.
+type metricsHolder struct {
+	Metrics
+}
+
+// SetMetrics sets the Metrics receiving the events of conn. nil removes it.
+//
+// The messages written are reported once written successfully, including
+// the messages written as a stream (NextWriter) or frame by frame (WriteFrame),
+// which are reported when the FIN frame is written.
+// The messages read are reported as Tap mirrors them, so the messages read
+// as a stream or frame by frame are not reported.
+func (conn *Conn) SetMetrics(m Metrics) {
+	conn.metrics.Store(metricsHolder{m})
+}
+
+func (conn *Conn) getMetrics() Metrics {
+	return conn.metrics.Load().(metricsHolder).Metrics
+}
+
+// reportRead counts the message of size bytes read (see Stats),
+// reporting it to the Metrics.
+func (conn *Conn) reportRead(size int) {
+	atomic.AddUint64(&conn.stats.messagesRead, 1)
+	atomic.AddUint64(&conn.stats.bytesRead, uint64(size))
+	if m := conn.getMetrics(); m != nil {
+		m.OnMessageRead(conn, size)
+	}
+}
+
+// reportWrite counts the data frame of size bytes written (see Stats),
+// reporting the message to the Metrics when fin is set.
+//
+// reportWrite must be called holding the write lock.
+func (conn *Conn) reportWrite(size int, fin bool) {
+	conn.writtenSize += size
+	if !fin {
+		return
+	}
+	atomic.AddUint64(&conn.stats.messagesWritten, 1)
+	atomic.AddUint64(&conn.stats.bytesWritten, uint64(conn.writtenSize))
+	if m := conn.getMetrics(); m != nil {
+		m.OnMessageWrite(conn, conn.writtenSize)
+	}
+	conn.writtenSize = 0
+}
@@ -0,0 +1,154 @@
+package fastws
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type countingMetrics struct {
+	NopMetrics
+	upgrades, errors, read, written, closed int64
+}
+
+func (m *countingMetrics) OnUpgrade(conn *Conn) {
+	atomic.AddInt64(&m.upgrades, 1)
+}
+
+func (m *countingMetrics) OnHandshakeError(status int, reason string) {
+	atomic.AddInt64(&m.errors, 1)
+}
+
+func (m *countingMetrics) OnMessageRead(conn *Conn, size int) {
+	atomic.AddInt64(&m.read, int64(size))
+}
+
+func (m *countingMetrics) OnMessageWrite(conn *Conn, size int) {
+	atomic.AddInt64(&m.written, int64(size))
+}
+
+func (m *countingMetrics) OnClose(conn *Conn, err error) {
+	atomic.AddInt64(&m.closed, 1)
+}
+
+func TestConnMetrics(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	var m countingMetrics
+	server.SetMetrics(&m)
+
+	go client.WriteString("Hello")
+	if _, _, err := server.ReadMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	go client.ReadMessage(nil)
+	if _, err := server.WriteString("Hello world"); err != nil {
+		t.Fatal(err)
+	}
+	server.mustClose(false)
+
+	if m.read != 5 || m.written != 11 {
+		t.Fatalf("Unexpected sizes: %d %d", m.read, m.written)
+	}
+	if m.closed != 1 {
+		t.Fatalf("Unexpected closes: %d <> 1", m.closed)
+	}
+}
+
+func TestConnMetricsWrites(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	var m countingMetrics
+	server.SetMetrics(&m)
+
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(nil); err != nil {
+				return
+			}
+		}
+	}()
+
+	w, err := server.NextWriter(ModeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("Hello"))
+	w.Write(make([]byte, DefaultWriterFrameSize))
+	if m.written != 0 {
+		t.Fatalf("Message reported before finishing it: %d", m.written)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := int64(DefaultWriterFrameSize + 5); m.written != n {
+		t.Fatalf("Unexpected size: %d <> %d", m.written, n)
+	}
+
+	fr := AcquireFrame()
+	fr.SetText()
+	fr.SetPayload([]byte("Hello "))
+	server.WriteFrame(fr)
+	fr.Reset()
+	fr.SetFin()
+	fr.SetContinuation()
+	fr.SetPayload([]byte("world"))
+	server.WriteFrame(fr)
+	ReleaseFrame(fr)
+
+	if n := int64(DefaultWriterFrameSize + 16); m.written != n {
+		t.Fatalf("Unexpected size: %d <> %d", m.written, n)
+	}
+
+	server.mustClose(false)
+	if _, err := server.WriteString("Hello"); err == nil {
+		t.Fatal("Expected error writing after closing")
+	}
+	if n := int64(DefaultWriterFrameSize + 16); m.written != n {
+		t.Fatalf("Failed write reported: %d <> %d", m.written, n)
+	}
+}
+
+func TestUpgraderMetrics(t *testing.T) {
+	var m countingMetrics
+	done := make(chan struct{})
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Metrics: &m,
+		Handler: func(conn *Conn) {
+			conn.ReadMessage(nil)
+		},
+		OnDisconnect: func(conn *Conn, err error) {
+			close(done)
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	conn.WriteString("Hello")
+	conn.Close()
+	<-done
+
+	if m.upgrades != 1 || m.read != 5 || m.closed != 1 {
+		t.Fatalf("Unexpected metrics: %d %d %d", m.upgrades, m.read, m.closed)
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("POST")
+	if _, err := upgr.Accept(&ctx); err != ErrCannotUpgrade {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrCannotUpgrade)
+	}
+	if m.errors != 1 {
+		t.Fatalf("Unexpected handshake errors: %d <> 1", m.errors)
+	}
+}
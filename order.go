@@ -0,0 +1,52 @@
+package fastws
+
+import (
+	"sync"
+)
+
+// ticketLock hands itself off in the order it was requested.
+type ticketLock struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	next    uint64
+	serving uint64
+}
+
+func (l *ticketLock) lock() {
+	l.mu.Lock()
+	if l.cond.L == nil {
+		l.cond.L = &l.mu
+	}
+	ticket := l.next
+	l.next++
+	for ticket != l.serving {
+		l.cond.Wait()
+	}
+	l.mu.Unlock()
+}
+
+func (l *ticketLock) unlock() {
+	l.mu.Lock()
+	l.serving++
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// lockOrdered locks m. If OrderedWrites is set the callers
+// get m in the order they called lockOrdered, queuing in queue.
+//
+// Only the callers queued take m, so m is handed off to them in order.
+func (conn *Conn) lockOrdered(queue *ticketLock, m *sync.Mutex) {
+	if !conn.OrderedWrites {
+		m.Lock()
+		return
+	}
+	queue.lock()
+	m.Lock()
+	queue.unlock()
+}
+
+// lockMessage acquires the message lock (see msgLck).
+func (conn *Conn) lockMessage() {
+	conn.lockOrdered(&conn.msgQueue, &conn.msgLck)
+}
@@ -0,0 +1,70 @@
+package fastws
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// queued returns the number of tickets taken from l.
+func (l *ticketLock) queued() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next
+}
+
+func TestOrderedWrites(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.OrderedWrites = true
+
+	// the writers queue while the message lock is held.
+	server.lockMessage()
+	n := 10
+	for i := 0; i < n; i++ {
+		go server.WriteString(strconv.Itoa(i))
+
+		for server.msgQueue.queued() != uint64(i+2) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	server.msgLck.Unlock()
+
+	for i := 0; i < n; i++ {
+		_, b, err := client.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != strconv.Itoa(i) {
+			t.Fatalf("Unexpected message: %s <> %d", b, i)
+		}
+	}
+}
+
+func TestTicketLock(t *testing.T) {
+	var (
+		l     ticketLock
+		order = make(chan int, 3)
+	)
+	l.lock()
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			l.lock()
+			order <- i
+			l.unlock()
+		}(i)
+		for l.queued() != uint64(i+2) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	l.unlock()
+
+	for i := 0; i < 3; i++ {
+		if j := <-order; j != i {
+			t.Fatalf("Unexpected order: %d <> %d", j, i)
+		}
+	}
+}
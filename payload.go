@@ -0,0 +1,92 @@
+package fastws
+
+import (
+	"sync"
+)
+
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		return &Payload{}
+	},
+}
+
+// Payload is the payload of a message read using ReadMessagePooled.
+//
+// Payload must be released once it's not needed and
+// its bytes must not be used after releasing it.
+type Payload struct {
+	// fr holds the payload of the unfragmented messages.
+	fr *Frame
+	// b holds the payload of the fragmented messages.
+	b []byte
+}
+
+// Bytes returns the payload.
+func (p *Payload) Bytes() []byte {
+	if p.fr != nil {
+		return p.fr.Payload()
+	}
+	return p.b
+}
+
+// Release returns p to the pool.
+func (p *Payload) Release() {
+	if p.fr != nil {
+		ReleaseFrame(p.fr)
+		p.fr = nil
+	}
+	p.b = p.b[:0]
+	payloadPool.Put(p)
+}
+
+// ReadMessagePooled reads the next message as ReadMessage does,
+// returning the payload of the frame read instead of copying it.
+// The fragmented messages are appended into a pooled buffer.
+//
+// The Payload returned must be released using Payload.Release.
+// If an error is returned the Payload is nil.
+func (conn *Conn) ReadMessagePooled() (Mode, *Payload, error) {
+	conn.beginRead()
+	defer conn.endRead()
+
+	for {
+		mode, p, err := conn.readPooled()
+		if err != nil || conn.dedup == nil || !conn.dedup.IsDuplicate(mode, p.Bytes()) {
+			return mode, p, err
+		}
+		p.Release()
+	}
+}
+
+func (conn *Conn) readPooled() (Mode, *Payload, error) {
+	fr, err := conn.recvFrame(nil)
+	if isInterruption(err) {
+		return ModeText, nil, err
+	}
+	if err != nil {
+		return ModeText, nil, conn.closeOnReadError(err)
+	}
+
+	p := payloadPool.Get().(*Payload)
+
+	mode := fr.Mode()
+	if conn.isWhole(fr) {
+		if fr.IsMasked() {
+			fr.Unmask()
+		}
+		storeMax(&conn.stats.maxMessageRead, uint64(fr.PayloadLen()))
+		conn.tap(DirectionIn, mode, fr.Payload())
+		p.fr = fr
+		return mode, p, nil
+	}
+
+	p.b, err = conn.readFull(nil, p.b[:0], fr, true)
+	mode = fr.Mode()
+	ReleaseFrame(fr)
+	if err != nil {
+		p.Release()
+		return mode, nil, err
+	}
+
+	return mode, p, nil
+}
@@ -0,0 +1,61 @@
+package fastws
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadMessagePooled(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	big := bytes.Repeat([]byte("a"), tinyMessageSize*4)
+	go func() {
+		client.WriteString("Hello")
+		client.WriteMessage(ModeBinary, big)
+		writeFragments(client, "Hello", " ", "world")
+	}()
+
+	expected := []struct {
+		mode    Mode
+		payload []byte
+	}{
+		{ModeText, []byte("Hello")},
+		{ModeBinary, big},
+		{ModeText, []byte("Hello world")},
+	}
+	for _, e := range expected {
+		mode, p, err := server.ReadMessagePooled()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != e.mode || !bytes.Equal(p.Bytes(), e.payload) {
+			t.Fatalf("Unexpected message: %v %q <> %v %q", mode, p.Bytes(), e.mode, e.payload)
+		}
+		p.Release()
+	}
+}
+
+func BenchmarkReadMessagePooled(b *testing.B) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	msg := bytes.Repeat([]byte("a"), 4096)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			client.WriteMessage(ModeBinary, msg)
+		}
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	for i := 0; i < b.N; i++ {
+		_, p, err := server.ReadMessagePooled()
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.Release()
+	}
+}
@@ -0,0 +1,205 @@
+package fastws
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultPipelineQueueSize is the default size of the queues between the Pipeline stages.
+const DefaultPipelineQueueSize = 128
+
+var errPipelineValue = errors.New("pipeline: cannot encode value")
+
+// Pipeline processes the messages of a connection in stages:
+//
+//	read -> decode -> handle -> encode -> write
+//
+// Every stage runs in its own goroutine and the stages are connected
+// by bounded queues, so a stage only waits for the next one when its queue is full.
+// The messages are processed (and replied) in the order they are read.
+//
+// Decode and Encode are optional.
+type Pipeline struct {
+	// Decode converts the message read to the value passed to Handle.
+	//
+	// By default the value is the message payload ([]byte).
+	Decode func(mode Mode, b []byte) (interface{}, error)
+
+	// Handle processes the decoded value returning the value to reply.
+	// If the value returned is nil nothing is replied.
+	Handle func(conn *Conn, v interface{}) (interface{}, error)
+
+	// Encode converts the value returned by Handle to the message written.
+	//
+	// By default []byte values are written using the mode of the message read
+	// and strings as text.
+	Encode func(v interface{}) (Mode, []byte, error)
+
+	// QueueSize is the number of messages each queue can hold.
+	//
+	// By default QueueSize is DefaultPipelineQueueSize.
+	QueueSize int
+}
+
+type pipeMessage struct {
+	mode Mode
+	b    []byte
+	v    interface{}
+}
+
+type pipeRun struct {
+	p    *Pipeline
+	conn *Conn
+
+	once sync.Once
+	err  error
+	done chan struct{}
+}
+
+// fail records the first error, stopping the pipeline.
+// fail returns whether err is the first error.
+func (r *pipeRun) fail(err error) (first bool) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+		first = true
+	})
+	return first
+}
+
+// send sends msg to ch unless the pipeline is stopped.
+func (r *pipeRun) send(ch chan<- pipeMessage, msg pipeMessage) bool {
+	select {
+	case ch <- msg:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// Serve runs the pipeline over conn until reading fails or
+// any stage returns an error. Serve returns the first error.
+//
+// When a stage fails conn is closed with StatusUnexpected.
+// When reading fails the messages already read are processed before returning.
+func (p *Pipeline) Serve(conn *Conn) error {
+	size := p.QueueSize
+	if size <= 0 {
+		size = DefaultPipelineQueueSize
+	}
+
+	r := &pipeRun{
+		p:    p,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	read := make(chan pipeMessage, size)
+	decoded := make(chan pipeMessage, size)
+	handled := make(chan pipeMessage, size)
+	encoded := make(chan pipeMessage, size)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go r.stage(&wg, read, decoded, r.decode)
+	go r.stage(&wg, decoded, handled, r.handle)
+	go r.stage(&wg, handled, encoded, r.encode)
+	go r.write(&wg, encoded)
+
+	var err error
+	for {
+		var msg pipeMessage
+		msg.mode, msg.b, err = conn.ReadMessage(nil)
+		if err != nil || !r.send(read, msg) {
+			break
+		}
+	}
+	// the errors of the stages after reading failed are the consequence
+	// of the connection being closed.
+	stageFailed := false
+	select {
+	case <-r.done:
+		stageFailed = true
+	default:
+	}
+
+	// the messages already read are processed unless a stage failed.
+	close(read)
+	wg.Wait()
+
+	if stageFailed {
+		return r.err
+	}
+	return err
+}
+
+// stage runs fn over the messages of in, sending the results to out.
+func (r *pipeRun) stage(wg *sync.WaitGroup, in <-chan pipeMessage, out chan<- pipeMessage, fn func(*pipeMessage) (bool, error)) {
+	defer wg.Done()
+	defer close(out)
+
+	for msg := range in {
+		ok, err := fn(&msg)
+		if err != nil {
+			r.stop(err)
+			return
+		}
+		if ok && !r.send(out, msg) {
+			return
+		}
+	}
+}
+
+func (r *pipeRun) write(wg *sync.WaitGroup, in <-chan pipeMessage) {
+	defer wg.Done()
+
+	for msg := range in {
+		if _, err := r.conn.WriteMessage(msg.mode, msg.b); err != nil {
+			r.stop(err)
+			return
+		}
+	}
+}
+
+// stop stops the pipeline due to err, closing the connection
+// to unblock the reader.
+func (r *pipeRun) stop(err error) {
+	if r.fail(err) {
+		r.conn.CloseWithCode(StatusUnexpected, "")
+	}
+}
+
+func (r *pipeRun) decode(msg *pipeMessage) (bool, error) {
+	if r.p.Decode == nil {
+		msg.v = msg.b
+		return true, nil
+	}
+	v, err := r.p.Decode(msg.mode, msg.b)
+	msg.v = v
+	return true, err
+}
+
+func (r *pipeRun) handle(msg *pipeMessage) (bool, error) {
+	v, err := r.p.Handle(r.conn, msg.v)
+	msg.v = v
+	return v != nil, err
+}
+
+func (r *pipeRun) encode(msg *pipeMessage) (bool, error) {
+	var err error
+	if r.p.Encode != nil {
+		msg.mode, msg.b, err = r.p.Encode(msg.v)
+		return true, err
+	}
+
+	switch v := msg.v.(type) {
+	case []byte:
+		msg.b = v
+	case string:
+		msg.mode, msg.b = ModeText, []byte(v)
+	default:
+		err = fmt.Errorf("%w of type %T", errPipelineValue, v)
+	}
+	return true, err
+}
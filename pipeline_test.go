@@ -0,0 +1,100 @@
+package fastws
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	p := &Pipeline{
+		QueueSize: 2,
+		Decode: func(mode Mode, b []byte) (interface{}, error) {
+			return strconv.Atoi(string(b))
+		},
+		Handle: func(conn *Conn, v interface{}) (interface{}, error) {
+			n := v.(int)
+			if n%2 == 1 {
+				return nil, nil
+			}
+			return n * 2, nil
+		},
+		Encode: func(v interface{}) (Mode, []byte, error) {
+			return ModeText, []byte(strconv.Itoa(v.(int))), nil
+		},
+	}
+
+	errch := make(chan error, 1)
+	go func() {
+		errch <- p.Serve(server)
+	}()
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.WriteString(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b []byte
+	var err error
+	for i := 0; i < 10; i += 2 {
+		_, b, err = client.ReadMessage(b[:0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := strconv.Itoa(i * 2); string(b) != expected {
+			t.Fatalf("Unexpected message: %s <> %s", b, expected)
+		}
+	}
+
+	// a value that cannot be decoded stops the pipeline.
+	client.WriteString("NaN")
+
+	_, _, err = client.ReadMessage(nil)
+	var cerr *CloseError
+	if !errors.As(err, &cerr) || cerr.Status != StatusUnexpected {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = <-errch
+	if err == nil || !strings.Contains(err.Error(), "invalid syntax") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPipelineDefaults(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+
+	p := &Pipeline{
+		Handle: func(conn *Conn, v interface{}) (interface{}, error) {
+			return strings.ToUpper(string(v.([]byte))), nil
+		},
+	}
+
+	errch := make(chan error, 1)
+	go func() {
+		errch <- p.Serve(server)
+	}()
+
+	client.WriteMessage(ModeBinary, []byte("hello"))
+
+	mode, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != ModeText || string(b) != "HELLO" {
+		t.Fatalf("Unexpected message: %d %s", mode, b)
+	}
+
+	client.CloseWithCode(StatusGoAway, "")
+
+	err = <-errch
+	if !errors.Is(err, EOF) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
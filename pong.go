@@ -0,0 +1,75 @@
+package fastws
+
+import (
+	"bytes"
+)
+
+// PongPolicy defines how the unsolicited PONGs are handled.
+//
+// A PONG is unsolicited when its payload doesn't match any of the PINGs
+// sent using SendCode or Ping still waiting for a reply.
+type PongPolicy uint8
+
+const (
+	// PongSurface passes the unsolicited PONGs to the pong handler
+	// as the solicited ones. This is the default policy.
+	PongSurface PongPolicy = iota
+	// PongIgnore drops the unsolicited PONGs without calling the pong handler.
+	PongIgnore
+	// PongReject treats the unsolicited PONGs as a protocol error,
+	// closing the connection with StatusProtocolError.
+	// The read functions return ErrUnsolicitedPong.
+	PongReject
+)
+
+// ErrUnsolicitedPong is returned when an unsolicited PONG is received
+// using the PongReject policy. It matches ErrProtocol.
+var ErrUnsolicitedPong = newError(ErrProtocol, "unsolicited pong received")
+
+// maxSentPings is the max number of PING payloads kept to match the PONGs.
+const maxSentPings = 16
+
+// pingSent records the payload of a PING to match its PONG.
+func (conn *Conn) pingSent(payload []byte) {
+	conn.pingLck.Lock()
+	if len(conn.sentPings) == maxSentPings {
+		copy(conn.sentPings, conn.sentPings[1:])
+		conn.sentPings = conn.sentPings[:maxSentPings-1]
+	}
+	conn.sentPings = append(conn.sentPings, append([]byte(nil), payload...))
+	conn.pingLck.Unlock()
+}
+
+// pongSolicited returns whether payload matches a PING sent.
+//
+// The matching PING and the older ones are forgotten, as peers can
+// reply only to the most recent PING.
+func (conn *Conn) pongSolicited(payload []byte) bool {
+	conn.pingLck.Lock()
+	defer conn.pingLck.Unlock()
+
+	for i, p := range conn.sentPings {
+		if bytes.Equal(p, payload) {
+			n := copy(conn.sentPings, conn.sentPings[i+1:])
+			conn.sentPings = conn.sentPings[:n]
+			return true
+		}
+	}
+	return false
+}
+
+// handlePong applies the pong policy to the PONG received with payload.
+func (conn *Conn) handlePong(payload []byte) error {
+	if !conn.pongSolicited(payload) {
+		switch conn.UnsolicitedPong {
+		case PongIgnore:
+			return nil
+		case PongReject:
+			return ErrUnsolicitedPong
+		}
+	}
+	if conn.pongHandler != nil {
+		return conn.pongHandler(payload)
+	}
+	return nil
+}
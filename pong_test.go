@@ -0,0 +1,49 @@
+package fastws
+
+import (
+	"testing"
+)
+
+func TestUnsolicitedPong(t *testing.T) {
+	for _, tc := range []struct {
+		policy PongPolicy
+		pongs  []string
+		err    error
+	}{
+		{PongSurface, []string{"unsolicited", "solicited"}, nil},
+		{PongIgnore, []string{"solicited"}, nil},
+		{PongReject, nil, ErrUnsolicitedPong},
+	} {
+		server, client := pipeConns()
+		server.UnsolicitedPong = tc.policy
+
+		var pongs []string
+		server.SetPongHandler(func(payload []byte) error {
+			pongs = append(pongs, string(payload))
+			return nil
+		})
+
+		server.SendCode(CodePing, 0, []byte("solicited"))
+		go func() {
+			client.SendCode(CodePong, 0, []byte("unsolicited"))
+			client.SendCode(CodePong, 0, []byte("solicited"))
+			client.WriteString("Hello")
+		}()
+
+		_, _, err := server.ReadMessage(nil)
+		if err != tc.err {
+			t.Fatalf("%d: Unexpected error: %v <> %v", tc.policy, err, tc.err)
+		}
+		if len(pongs) != len(tc.pongs) {
+			t.Fatalf("%d: Unexpected pongs: %q <> %q", tc.policy, pongs, tc.pongs)
+		}
+		for i := range pongs {
+			if pongs[i] != tc.pongs[i] {
+				t.Fatalf("%d: Unexpected pong: %s <> %s", tc.policy, pongs[i], tc.pongs[i])
+			}
+		}
+
+		client.mustClose(false)
+		server.mustClose(false)
+	}
+}
@@ -0,0 +1,334 @@
+package fastws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClientPoolClosed is returned when using a closed ClientPool.
+var ErrClientPoolClosed = errors.New("client pool closed")
+
+const (
+	// DefaultPoolMaxIdle is the default max number of idle connections kept by a ClientPool.
+	DefaultPoolMaxIdle = 4
+	// DefaultHealthCheckTimeout is the default time a ClientPool waits for the PONG
+	// of the health checks.
+	DefaultHealthCheckTimeout = time.Second * 5
+)
+
+// ClientPool keeps client connections to URL ready to be used.
+//
+// The idle connections are probed periodically (see HealthCheckInterval)
+// and recycled when they get too old or have been idle for too long,
+// so the connections returned by Get are not silently dead.
+// The health checks read the idle connections, discarding the messages received.
+// Optionally the pool dials in the background to keep MinIdle connections warm.
+//
+// The connections got must be returned using Put once they are not needed,
+// or closed if they can't be reused.
+type ClientPool struct {
+	// URL is the URL dialed.
+	URL string
+
+	// Dialer is the Dialer used to dial URL.
+	//
+	// If nil a zero Dialer is used.
+	Dialer *Dialer
+
+	// Dial, if set, is used instead of dialing URL with Dialer.
+	Dial func() (*Conn, error)
+
+	// MaxIdle is the max number of idle connections kept.
+	//
+	// By default MaxIdle is DefaultPoolMaxIdle.
+	MaxIdle int
+
+	// MinIdle is the number of idle connections kept warm,
+	// dialing in the background when there are fewer.
+	//
+	// The connections are only dialed by the health checks,
+	// so MinIdle requires HealthCheckInterval.
+	MinIdle int
+
+	// MaxIdleTime is the max time a connection can stay idle.
+	//
+	// 0 means no limit.
+	MaxIdleTime time.Duration
+
+	// MaxAge is the max time a connection is reused since it was dialed.
+	//
+	// 0 means no limit.
+	MaxAge time.Duration
+
+	// HealthCheckInterval is the interval the idle connections are pinged,
+	// closing the ones not answering within HealthCheckTimeout.
+	//
+	// 0 disables the health checks.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the max time waited for the PONG of the health checks.
+	//
+	// By default HealthCheckTimeout is DefaultHealthCheckTimeout.
+	HealthCheckTimeout time.Duration
+
+	lck    sync.Mutex
+	idle   []pooledConn
+	dialed map[*Conn]time.Time
+	closed bool
+	stop   chan struct{}
+}
+
+type pooledConn struct {
+	conn   *Conn
+	dialed time.Time
+	since  time.Time
+}
+
+// Get returns an idle connection, dialing a new one if there's none.
+//
+// The idle connections closed, too old or idle for too long are discarded.
+func (p *ClientPool) Get() (*Conn, error) {
+	p.lck.Lock()
+	if p.closed {
+		p.lck.Unlock()
+		return nil, ErrClientPoolClosed
+	}
+	p.startHealthChecks()
+
+	now := time.Now()
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if p.expired(pc, now) || pc.conn.IsClosed() {
+			go pc.conn.Close()
+			continue
+		}
+		p.track(pc.conn, pc.dialed)
+		p.lck.Unlock()
+
+		return pc.conn, nil
+	}
+	p.lck.Unlock()
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lck.Lock()
+	p.track(conn, now)
+	p.lck.Unlock()
+
+	return conn, nil
+}
+
+// track records when the connection got was dialed. p.lck must be held.
+func (p *ClientPool) track(conn *Conn, dialed time.Time) {
+	if p.dialed == nil {
+		p.dialed = make(map[*Conn]time.Time)
+	}
+	p.dialed[conn] = dialed
+}
+
+// Put returns conn to the pool.
+//
+// conn is closed if it's closed, too old, or the pool is full or closed.
+func (p *ClientPool) Put(conn *Conn) {
+	now := time.Now()
+
+	p.lck.Lock()
+	dialed, ok := p.dialed[conn]
+	delete(p.dialed, conn)
+	if !ok {
+		dialed = now
+	}
+	pc := pooledConn{
+		conn:   conn,
+		dialed: dialed,
+		since:  now,
+	}
+	keep := !p.closed && len(p.idle) < p.maxIdle() && !p.expired(pc, now) && !conn.IsClosed()
+	if keep {
+		p.idle = append(p.idle, pc)
+	}
+	p.lck.Unlock()
+
+	if !keep {
+		conn.Close()
+	}
+}
+
+// Len returns the number of idle connections.
+func (p *ClientPool) Len() int {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	return len(p.idle)
+}
+
+// Close closes the idle connections and stops the health checks.
+//
+// The connections returned after closing the pool are closed.
+func (p *ClientPool) Close() error {
+	p.lck.Lock()
+	if p.closed {
+		p.lck.Unlock()
+		return ErrClientPoolClosed
+	}
+	p.closed = true
+	if p.stop != nil {
+		close(p.stop)
+	}
+	idle := p.idle
+	p.idle = nil
+	p.lck.Unlock()
+
+	for _, pc := range idle {
+		pc.conn.Close()
+	}
+	return nil
+}
+
+func (p *ClientPool) maxIdle() int {
+	if p.MaxIdle > 0 {
+		return p.MaxIdle
+	}
+	return DefaultPoolMaxIdle
+}
+
+func (p *ClientPool) expired(pc pooledConn, now time.Time) bool {
+	return (p.MaxAge > 0 && now.Sub(pc.dialed) >= p.MaxAge) ||
+		(p.MaxIdleTime > 0 && now.Sub(pc.since) >= p.MaxIdleTime)
+}
+
+func (p *ClientPool) dial() (*Conn, error) {
+	switch {
+	case p.Dial != nil:
+		return p.Dial()
+	case p.Dialer != nil:
+		return p.Dialer.Dial(p.URL)
+	}
+	return Dial(p.URL)
+}
+
+// startHealthChecks starts the health checks if enabled and not started yet.
+//
+// startHealthChecks must be called holding p.lck.
+func (p *ClientPool) startHealthChecks() {
+	if p.stop != nil || p.HealthCheckInterval <= 0 {
+		return
+	}
+	p.stop = make(chan struct{})
+	go p.healthChecks(p.stop)
+}
+
+func (p *ClientPool) healthChecks(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		p.check()
+		p.warm()
+	}
+}
+
+// check pings the idle connections, discarding the ones failing or expired.
+func (p *ClientPool) check() {
+	p.lck.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.lck.Unlock()
+
+	timeout := p.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	var (
+		wg      sync.WaitGroup
+		healthy = make([]bool, len(idle))
+		now     = time.Now()
+	)
+	for i := range idle {
+		if p.expired(idle[i], now) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			healthy[i] = ping(idle[i].conn, timeout) == nil
+		}(i)
+	}
+	wg.Wait()
+
+	var discarded []*Conn
+	p.lck.Lock()
+	for i, pc := range idle {
+		if healthy[i] && !p.closed && len(p.idle) < p.maxIdle() {
+			p.idle = append(p.idle, pc)
+		} else {
+			discarded = append(discarded, pc.conn)
+		}
+	}
+	p.lck.Unlock()
+
+	for _, conn := range discarded {
+		conn.Close()
+	}
+}
+
+// ping pings the idle conn waiting up to timeout for the PONG.
+//
+// The frames received meanwhile are read (and the messages discarded),
+// as nothing else reads the idle connections, so the PONGs don't pile up
+// in the queue of the readLoop.
+func ping(conn *Conn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pinged := make(chan error, 1)
+	go func() {
+		_, err := conn.Ping(ctx, nil)
+		pinged <- err
+		// stops reading.
+		cancel()
+	}()
+
+	var (
+		b   []byte
+		err error
+	)
+	for err == nil {
+		_, b, err = conn.ReadMessageContext(ctx, b[:0])
+	}
+
+	return <-pinged
+}
+
+// warm dials the connections missing to have MinIdle idle connections.
+func (p *ClientPool) warm() {
+	for {
+		p.lck.Lock()
+		need := !p.closed && len(p.idle) < p.MinIdle && len(p.idle) < p.maxIdle()
+		p.lck.Unlock()
+		if !need {
+			return
+		}
+
+		conn, err := p.dial()
+		if err != nil {
+			// retried on the next health check.
+			return
+		}
+		p.Put(conn)
+	}
+}
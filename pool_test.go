@@ -0,0 +1,184 @@
+package fastws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func newTestPool(t *testing.T, handler RequestHandler) (*ClientPool, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: Upgrade(handler),
+	}
+	go s.Serve(ln)
+
+	p := &ClientPool{
+		Dial: func() (*Conn, error) {
+			c, err := ln.Dial()
+			if err != nil {
+				return nil, err
+			}
+			return Client(c, "http://localhost/")
+		},
+	}
+	return p, func() {
+		p.Close()
+		ln.Close()
+	}
+}
+
+func readAll(conn *Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(nil); err != nil {
+			return
+		}
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	p, stop := newTestPool(t, readAll)
+	defer stop()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	if p.Len() != 1 {
+		t.Fatalf("Unexpected idle connections: %d <> 1", p.Len())
+	}
+
+	conn2, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn2 != conn {
+		t.Fatal("The idle connection was not reused")
+	}
+
+	p.MaxAge = time.Millisecond
+	time.Sleep(time.Millisecond * 2)
+	p.Put(conn2)
+	if p.Len() != 0 {
+		t.Fatal("Expected the old connection to be closed")
+	}
+
+	p.Close()
+	if _, err := p.Get(); err != ErrClientPoolClosed {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrClientPoolClosed)
+	}
+}
+
+func TestClientPoolMaxIdleTime(t *testing.T) {
+	p, stop := newTestPool(t, readAll)
+	defer stop()
+
+	p.MaxIdleTime = time.Millisecond * 10
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	time.Sleep(time.Millisecond * 20)
+
+	conn2, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if conn2 == conn {
+		t.Fatal("The connection idle for too long was reused")
+	}
+}
+
+func TestClientPoolHealthCheck(t *testing.T) {
+	block := make(chan struct{})
+	p, stop := newTestPool(t, func(conn *Conn) {
+		// not reading, so the PINGs are not answered.
+		<-block
+	})
+	defer stop()
+	defer close(block)
+
+	p.HealthCheckInterval = time.Millisecond * 10
+	p.HealthCheckTimeout = time.Millisecond * 10
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	for i := 0; p.Len() != 0; i++ {
+		if i == 100 {
+			t.Fatal("The dead connection was not discarded")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestClientPoolWarm(t *testing.T) {
+	p, stop := newTestPool(t, readAll)
+	defer stop()
+
+	p.MinIdle = 2
+	p.HealthCheckInterval = time.Millisecond * 10
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; p.Len() != 2; i++ {
+		if i == 100 {
+			t.Fatalf("Unexpected idle connections: %d <> 2", p.Len())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestClientPoolPutFirst(t *testing.T) {
+	p, stop := newTestPool(t, readAll)
+	defer stop()
+
+	conn, err := p.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	conn2, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn2 != conn {
+		t.Fatal("The idle connection was not reused")
+	}
+	p.Put(conn2)
+}
+
+func TestClientPoolManyHealthChecks(t *testing.T) {
+	p, stop := newTestPool(t, readAll)
+	defer stop()
+
+	p.HealthCheckTimeout = time.Second
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	// more PONGs than the frames the readLoop can queue.
+	for i := 0; i < 200; i++ {
+		p.check()
+		if p.Len() != 1 {
+			t.Fatalf("Healthy connection discarded after %d checks", i+1)
+		}
+	}
+}
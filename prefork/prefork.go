@@ -0,0 +1,474 @@
+// Package prefork runs fastws servers under fasthttp prefork.
+//
+// Every child process accepts its own connections, so the connections
+// must be tracked per process (see Prefork.Add). The children report
+// their stats to the master over a unix socket, and the master
+// coordinates the graceful shutdown of all of them:
+//
+//	p := &prefork.Prefork{Server: server}
+//	upgr.OnConnect = p.Add
+//	upgr.OnDisconnect = func(conn *fastws.Conn, err error) { p.Remove(conn) }
+//
+//	go func() {
+//		sig := make(chan os.Signal, 1)
+//		signal.Notify(sig, syscall.SIGTERM)
+//		<-sig
+//		p.Shutdown()
+//	}()
+//	p.ListenAndServe(":8080")
+package prefork
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrr/fastws"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/prefork"
+)
+
+const (
+	// DefaultStatsInterval is the default interval the children report their stats.
+	DefaultStatsInterval = time.Second
+	// DefaultShutdownTimeout is the default time the master waits for the children to shut down.
+	DefaultShutdownTimeout = time.Second * 30
+)
+
+// EnvSocket is the environment variable holding the path of the unix socket
+// the children use to talk to the master.
+const EnvSocket = "FASTWS_PREFORK_SOCKET"
+
+// ErrShutdownTimeout is returned by Shutdown when the children
+// don't shut down within the ShutdownTimeout.
+var ErrShutdownTimeout = errors.New("prefork: shutdown timeout")
+
+// shutdownCommand is sent by the master to the children to shut them down.
+const shutdownCommand = "shutdown"
+
+// report is sent by the children to the master.
+type report struct {
+	Stats Stats `json:"stats"`
+	// Done is set when the child has shut down.
+	Done bool `json:"done"`
+}
+
+// Stats represents the stats reported by a child process.
+type Stats struct {
+	// PID is the process id of the child.
+	PID int `json:"pid"`
+	// Conns is the number of connections tracked by the child.
+	Conns int `json:"conns"`
+	// Conn is the sum of the stats of the connections.
+	Conn fastws.ConnStats `json:"conn"`
+}
+
+// Prefork runs Server under fasthttp prefork.
+//
+// The master process starts a child per CPU, restarting the children
+// which exit (see fasthttp's prefork.Prefork). The fields must be set
+// before calling ListenAndServe, in the master and the children alike.
+type Prefork struct {
+	// Server is the server run by the children.
+	Server *fasthttp.Server
+
+	// Reuseport makes the children listen using SO_REUSEPORT
+	// instead of sharing the listener of the master.
+	Reuseport bool
+
+	// ChildProcs is the GOMAXPROCS of the children.
+	//
+	// By default the children use one thread (GOMAXPROCS=1)
+	// as there are as many children as CPUs.
+	ChildProcs int
+
+	// StatsInterval is the interval the children report their stats to the master.
+	//
+	// By default StatsInterval is DefaultStatsInterval.
+	StatsInterval time.Duration
+
+	// ShutdownTimeout is the max time the master waits for the children to shut down.
+	//
+	// By default ShutdownTimeout is DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	// Closer closes the connections of the children when shutting down.
+	//
+	// If nil a zero Closer is used.
+	Closer *fastws.Closer
+
+	lck   sync.Mutex
+	conns map[*fastws.Conn]struct{}
+
+	// children holds the last report of every child (in the master).
+	children map[net.Conn]*report
+	// updated is signaled when a report is received.
+	updated chan struct{}
+
+	// stopping is set when the child starts shutting down.
+	stopping bool
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// IsChild returns whether the current process is a prefork child.
+func IsChild() bool {
+	return prefork.IsChild()
+}
+
+// ListenAndServe runs the server on addr.
+//
+// In the master ListenAndServe starts the children and returns
+// after shutting them down, and then the process must exit.
+// In a child it serves the connections until the server is shut down,
+// returning when the master lets the children exit.
+func (p *Prefork) ListenAndServe(addr string) error {
+	pf := prefork.New(p.Server)
+	pf.Reuseport = p.Reuseport
+	pf.ServeFunc = func(ln net.Listener) error {
+		if p.ChildProcs > 0 {
+			runtime.GOMAXPROCS(p.ChildProcs)
+		}
+		return p.serveChild(ln)
+	}
+
+	if IsChild() {
+		return pf.ListenAndServe(addr)
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("fastws-prefork-%d.sock", os.Getpid()))
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ln.Close()
+		// letting the children exit.
+		p.lck.Lock()
+		for c := range p.children {
+			c.Close()
+		}
+		p.lck.Unlock()
+	}()
+	if err = os.Setenv(EnvSocket, path); err != nil {
+		return err
+	}
+	go p.serveMaster(ln)
+
+	errch := make(chan error, 1)
+	go func() {
+		errch <- pf.ListenAndServe(addr)
+	}()
+
+	select {
+	case err = <-errch:
+	case <-p.doneCh():
+	}
+	return err
+}
+
+// Shutdown shuts down the server gracefully.
+//
+// In the master Shutdown asks the children to shut down, waiting
+// for them up to ShutdownTimeout. In a child Shutdown closes
+// the connections using the Closer and shuts down the server.
+func (p *Prefork) Shutdown() error {
+	if IsChild() {
+		return p.shutdownChild()
+	}
+
+	p.lck.Lock()
+	for c := range p.children {
+		fmt.Fprintln(c, shutdownCommand)
+	}
+	p.lck.Unlock()
+	n := p.running()
+
+	timeout := p.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	for n > 0 {
+		select {
+		case <-p.updatedCh():
+			n = p.running()
+		case <-timer.C:
+			err = ErrShutdownTimeout
+			n = 0
+		}
+	}
+
+	p.doneOnce.Do(func() {
+		close(p.doneCh())
+	})
+	return err
+}
+
+// Add tracks conn as a connection of the current process.
+//
+// Add can be used as Upgrader.OnConnect.
+func (p *Prefork) Add(conn *fastws.Conn) {
+	p.lck.Lock()
+	if p.conns == nil {
+		p.conns = make(map[*fastws.Conn]struct{})
+	}
+	p.conns[conn] = struct{}{}
+	p.lck.Unlock()
+}
+
+// Remove stops tracking conn.
+func (p *Prefork) Remove(conn *fastws.Conn) {
+	p.lck.Lock()
+	delete(p.conns, conn)
+	p.lck.Unlock()
+}
+
+// Conns returns the connections tracked by the current process.
+func (p *Prefork) Conns() []*fastws.Conn {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	conns := make([]*fastws.Conn, 0, len(p.conns))
+	for conn := range p.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Stats returns the last stats reported by the children, sorted by PID.
+//
+// Stats must be called in the master.
+func (p *Prefork) Stats() []Stats {
+	p.lck.Lock()
+	stats := make([]Stats, 0, len(p.children))
+	for _, r := range p.children {
+		if r.Stats.PID != 0 {
+			stats = append(stats, r.Stats)
+		}
+	}
+	p.lck.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].PID < stats[j].PID
+	})
+	return stats
+}
+
+// Total returns the sum of the stats reported by the children.
+// The PID of the stats returned is the master one.
+//
+// Total must be called in the master.
+func (p *Prefork) Total() Stats {
+	total := Stats{PID: os.Getpid()}
+	for _, s := range p.Stats() {
+		total.Conns += s.Conns
+		addConnStats(&total.Conn, s.Conn)
+	}
+	return total
+}
+
+func (p *Prefork) stats() Stats {
+	s := Stats{PID: os.Getpid()}
+	for _, conn := range p.Conns() {
+		s.Conns++
+		addConnStats(&s.Conn, conn.Stats())
+	}
+	return s
+}
+
+func addConnStats(dst *fastws.ConnStats, s fastws.ConnStats) {
+	dst.WriteLocks += s.WriteLocks
+	dst.WriteLocksContended += s.WriteLocksContended
+	dst.WriteLockWait += s.WriteLockWait
+}
+
+func (p *Prefork) doneCh() chan struct{} {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	return p.done
+}
+
+func (p *Prefork) updatedCh() chan struct{} {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	if p.updated == nil {
+		p.updated = make(chan struct{}, 1)
+	}
+	return p.updated
+}
+
+// running returns the number of children not shut down.
+func (p *Prefork) running() int {
+	p.lck.Lock()
+	defer p.lck.Unlock()
+
+	n := 0
+	for _, r := range p.children {
+		if !r.Done {
+			n++
+		}
+	}
+	return n
+}
+
+// serveMaster accepts the connections of the children.
+func (p *Prefork) serveMaster(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.serveStats(c)
+	}
+}
+
+// serveStats reads the reports of a child until it exits.
+func (p *Prefork) serveStats(c net.Conn) {
+	updated := p.updatedCh()
+
+	p.lck.Lock()
+	if p.children == nil {
+		p.children = make(map[net.Conn]*report)
+	}
+	p.children[c] = &report{}
+	p.lck.Unlock()
+
+	sc := bufio.NewScanner(c)
+	for sc.Scan() {
+		r := &report{}
+		if json.Unmarshal(sc.Bytes(), r) != nil {
+			continue
+		}
+		p.lck.Lock()
+		p.children[c] = r
+		p.lck.Unlock()
+
+		select {
+		case updated <- struct{}{}:
+		default:
+		}
+	}
+
+	p.lck.Lock()
+	delete(p.children, c)
+	p.lck.Unlock()
+	c.Close()
+
+	select {
+	case updated <- struct{}{}:
+	default:
+	}
+}
+
+// serveChild serves ln reporting the stats to the master.
+//
+// After shutting down serveChild waits for the master to let the child exit,
+// so the master doesn't start other child.
+func (p *Prefork) serveChild(ln net.Listener) error {
+	path := os.Getenv(EnvSocket)
+	if path == "" {
+		return p.Server.Serve(ln)
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	masterGone := make(chan struct{})
+	go p.waitShutdown(c, masterGone)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.report(c)
+	}()
+
+	err = p.Server.Serve(ln)
+
+	p.lck.Lock()
+	stopping := p.stopping
+	p.lck.Unlock()
+	if stopping {
+		<-p.doneCh()
+		wg.Wait()
+		<-masterGone
+	}
+	return err
+}
+
+// report sends the stats of the child to the master every StatsInterval
+// until shutting down, when the last report is sent.
+func (p *Prefork) report(c net.Conn) {
+	interval := p.StatsInterval
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	enc := json.NewEncoder(c)
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.doneCh():
+			enc.Encode(report{Stats: p.stats(), Done: true})
+			return
+		}
+		if enc.Encode(report{Stats: p.stats()}) != nil {
+			return
+		}
+	}
+}
+
+// waitShutdown shuts down the child when the master asks for it,
+// closing gone when the master closes the connection.
+func (p *Prefork) waitShutdown(c net.Conn, gone chan<- struct{}) {
+	defer close(gone)
+
+	sc := bufio.NewScanner(c)
+	for sc.Scan() {
+		if sc.Text() == shutdownCommand {
+			go p.shutdownChild()
+		}
+	}
+}
+
+func (p *Prefork) shutdownChild() error {
+	p.lck.Lock()
+	p.stopping = true
+	p.lck.Unlock()
+
+	closer := p.Closer
+	if closer == nil {
+		closer = &fastws.Closer{}
+	}
+	err := closer.Close(p.Conns())
+
+	if serr := p.Server.Shutdown(); err == nil {
+		err = serr
+	}
+	p.doneOnce.Do(func() {
+		close(p.doneCh())
+	})
+	return err
+}
@@ -0,0 +1,120 @@
+package prefork
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrr/fastws"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestPreforkChild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master := &Prefork{}
+	path := filepath.Join(dir, "master.sock")
+	mln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mln.Close()
+	go master.serveMaster(mln)
+
+	os.Setenv(EnvSocket, path)
+	defer os.Unsetenv(EnvSocket)
+
+	child := &Prefork{
+		StatsInterval: time.Millisecond * 10,
+	}
+	upgr := fastws.Upgrader{
+		OnConnect: child.Add,
+		OnDisconnect: func(conn *fastws.Conn, err error) {
+			child.Remove(conn)
+		},
+		Handler: func(conn *fastws.Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(nil); err != nil {
+					return
+				}
+			}
+		},
+	}
+	child.Server = &fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	served := make(chan error, 1)
+	go func() {
+		served <- child.serveChild(ln)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := fastws.Client(c, "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// replying to the close frame sent when shutting down.
+		for {
+			if _, _, err := conn.ReadMessage(nil); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; ; i++ {
+		if total := master.Total(); total.Conns == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Unexpected stats: %+v", master.Stats())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stats := master.Stats(); len(stats) != 1 || stats[0].PID != os.Getpid() {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	if err := master.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(child.Conns()); n != 0 {
+		t.Fatalf("Unexpected connections after shutting down: %d", n)
+	}
+
+	// the child waits for the master to let it exit.
+	select {
+	case err := <-served:
+		t.Fatalf("Unexpected child exit: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	master.lck.Lock()
+	for c := range master.children {
+		c.Close()
+	}
+	master.lck.Unlock()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("The child didn't exit")
+	}
+}
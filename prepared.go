@@ -0,0 +1,100 @@
+package fastws
+
+import (
+	"bytes"
+)
+
+// PreparedMessage is a data message encoded once to be written
+// to many connections (see Conn.WritePrepared).
+//
+// The frame is encoded unmasked, so the server connections write it
+// with a single buffered write, without framing the payload again.
+// The client connections mask the payload, as WriteMessage does.
+// A PreparedMessage is immutable, so it's safe for concurrent use.
+type PreparedMessage struct {
+	mode    Mode
+	payload []byte
+	frame   []byte
+}
+
+// NewPreparedMessage returns the message b encoded using mode. b is copied.
+func NewPreparedMessage(mode Mode, b []byte) *PreparedMessage {
+	return prepareMessage(mode, append([]byte(nil), b...))
+}
+
+// prepareMessage returns the message b encoded using mode without copying b.
+func prepareMessage(mode Mode, b []byte) *PreparedMessage {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	fr.SetFin()
+	if mode == ModeBinary {
+		fr.SetBinary()
+	} else {
+		fr.SetText()
+	}
+	fr.SetPayload(b)
+
+	var bb bytes.Buffer
+	fr.WriteTo(&bb)
+
+	return &PreparedMessage{
+		mode:    mode,
+		payload: b,
+		frame:   bb.Bytes(),
+	}
+}
+
+// Mode returns the mode of the message.
+func (pm *PreparedMessage) Mode() Mode {
+	return pm.mode
+}
+
+// Payload returns the payload of the message, which must not be modified.
+func (pm *PreparedMessage) Payload() []byte {
+	return pm.payload
+}
+
+func (pm *PreparedMessage) code() Code {
+	if pm.mode == ModeBinary {
+		return CodeBinary
+	}
+	return CodeText
+}
+
+// WritePrepared writes pm as WriteMessage does.
+func (conn *Conn) WritePrepared(pm *PreparedMessage) (int, error) {
+	if !conn.server {
+		return conn.WriteMessage(pm.mode, pm.payload)
+	}
+
+	defer conn.closeIfPeerDead()
+
+	single := conn.isSingleWriter()
+	if !single {
+		conn.lockMessage()
+		defer conn.msgLck.Unlock()
+	}
+	conn.lockFrames(single)
+	defer conn.unlockFrames(single)
+	if conn.closed {
+		return 0, errClosed
+	}
+
+	conn.tap(DirectionOut, pm.mode, pm.payload)
+	storeMax(&conn.stats.maxFrameWritten, uint64(len(pm.payload)))
+	conn.logFrame(DirectionOut, pm.code(), true, false, uint64(len(pm.payload)))
+
+	stop := conn.beginWrite(nil)
+	n, err := conn.bf.Write(pm.frame)
+	if err == nil && !conn.manualFlush {
+		err = conn.bf.Flush()
+	}
+	conn.endWrite(stop, err)
+
+	if err == nil {
+		conn.reportWrite(len(pm.payload), true)
+	}
+
+	return n, err
+}
@@ -0,0 +1,29 @@
+package fastws
+
+import (
+	"testing"
+)
+
+func TestWritePrepared(t *testing.T) {
+	b := []byte("Hello")
+	pm := NewPreparedMessage(ModeBinary, b)
+	// the payload is copied.
+	b[0] = 'h'
+
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	// written as is by the server and masked by the client.
+	for _, c := range [][2]*Conn{{server, client}, {client, server}} {
+		go c[0].WritePrepared(pm)
+
+		mode, b, err := c[1].ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != ModeBinary || string(b) != "Hello" {
+			t.Fatalf("Unexpected message: %v %q", mode, b)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package fastws
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrProxy is returned when the proxy can't connect to the websocket server.
+var ErrProxy = errors.New("proxy error")
+
+// ProxyURL returns a Dialer.Proxy always using the proxy rawurl.
+func ProxyURL(rawurl string) func(url string) (string, error) {
+	return func(string) (string, error) {
+		return rawurl, nil
+	}
+}
+
+const (
+	socksVersion  = 5
+	socksNoAuth   = 0
+	socksUserPass = 2
+	socksConnect  = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+)
+
+// dialProxy connects to addr through the proxy rawurl, performing
+// the TLS handshake over the tunnel if cnf is not nil.
+func (d *Dialer) dialProxy(nd *net.Dialer, network, rawurl, addr string, cnf *tls.Config) (net.Conn, error) {
+	start := time.Now()
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "socks5", "socks5h":
+		port = "1080"
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	c, err := nd.Dial(network, host)
+	if err != nil {
+		return nil, err
+	}
+	// the ConnectTimeout bounds the proxy and the TLS handshakes too.
+	if d.ConnectTimeout > 0 {
+		c.SetDeadline(start.Add(d.ConnectTimeout))
+	}
+
+	switch u.Scheme {
+	case "http":
+		err = connectHTTP(c, addr, u.User)
+	case "socks5":
+		// the host is resolved locally, so the proxy gets the IP.
+		var target string
+		target, err = resolveAddr(nd, network, addr, d.deadline(start))
+		if err == nil {
+			err = connectSOCKS5(c, target, u.User)
+		}
+	case "socks5h":
+		// the host name is resolved by the proxy.
+		err = connectSOCKS5(c, addr, u.User)
+	}
+	if err == nil && cnf != nil {
+		if cnf.ServerName == "" {
+			cnf = cnf.Clone()
+			cnf.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(c, cnf)
+		err = tc.Handshake()
+		c = tc
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if d.ConnectTimeout > 0 {
+		c.SetDeadline(zeroTime)
+	}
+
+	return c, nil
+}
+
+// deadline returns the time the ConnectTimeout started at start expires,
+// which is zero if there's no ConnectTimeout.
+func (d *Dialer) deadline(start time.Time) time.Time {
+	if d.ConnectTimeout <= 0 {
+		return zeroTime
+	}
+	return start.Add(d.ConnectTimeout)
+}
+
+// resolveAddr returns addr using the IP of its host, resolved using
+// the resolver of nd before deadline (if not zero).
+// The IPv4 addresses are preferred unless network is tcp6.
+func resolveAddr(nd *net.Dialer, network, addr string, deadline time.Time) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+
+	r := nd.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	var ip net.IP
+	for _, ia := range ips {
+		isIPv4 := ia.IP.To4() != nil
+		if isIPv4 == (network != "tcp6") {
+			ip = ia.IP
+			break
+		}
+		if ip == nil && network == "tcp" {
+			ip = ia.IP
+		}
+	}
+	if ip == nil {
+		return "", fmt.Errorf("no %s address for %s", network, host)
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// connectHTTP opens a tunnel to addr using an HTTP CONNECT request.
+func connectHTTP(c net.Conn, addr string, user *url.Userinfo) error {
+	bw := bufio.NewWriter(c)
+	fmt.Fprintf(bw, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.EncodeToString([]byte(user.Username() + ":" + password))
+		fmt.Fprintf(bw, "Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	bw.WriteString("\r\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	// the tunnel starts after the header. Nothing is buffered after it,
+	// as the server doesn't send anything before the upgrade request.
+	res.SkipBody = true
+	if err := res.Read(bufio.NewReader(c)); err != nil {
+		return err
+	}
+	if res.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("%w: CONNECT responded with %d", ErrProxy, res.StatusCode())
+	}
+
+	return nil
+}
+
+// connectSOCKS5 opens a tunnel to addr using the SOCKS5 protocol (RFC 1928),
+// authenticating with user and password if user is not nil (RFC 1929).
+func connectSOCKS5(c net.Conn, addr string, user *url.Userinfo) error {
+	host, sport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(sport, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", sport)
+	}
+
+	b := []byte{socksVersion, 1, socksNoAuth}
+	if user != nil {
+		b = []byte{socksVersion, 2, socksNoAuth, socksUserPass}
+	}
+	if _, err = c.Write(b); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(c, b[:2]); err != nil {
+		return err
+	}
+	if b[0] != socksVersion {
+		return fmt.Errorf("%w: unexpected SOCKS version %d", ErrProxy, b[0])
+	}
+
+	switch b[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if user == nil {
+			return fmt.Errorf("%w: SOCKS authentication required", ErrProxy)
+		}
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("%w: SOCKS credentials too long", ErrProxy)
+		}
+
+		b = append(b[:0], 1, byte(len(username)))
+		b = append(b, username...)
+		b = append(b, byte(len(password)))
+		b = append(b, password...)
+		if _, err = c.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(c, b[:2]); err != nil {
+			return err
+		}
+		if b[1] != 0 {
+			return fmt.Errorf("%w: SOCKS authentication failed", ErrProxy)
+		}
+	default:
+		return fmt.Errorf("%w: no acceptable SOCKS authentication method", ErrProxy)
+	}
+
+	b = append(b[:0], socksVersion, socksConnect, 0)
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("%w: host name too long", ErrProxy)
+		}
+		b = append(b, socksDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socksIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksIPv6)
+		b = append(b, ip...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	if _, err = c.Write(b); err != nil {
+		return err
+	}
+
+	// reading the reply, skipping the address bound by the proxy.
+	var reply [4]byte
+	if _, err = io.ReadFull(c, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("%w: SOCKS connect failed with %d", ErrProxy, reply[1])
+	}
+
+	var n int
+	switch reply[3] {
+	case socksIPv4:
+		n = net.IPv4len
+	case socksIPv6:
+		n = net.IPv6len
+	case socksDomain:
+		if _, err = io.ReadFull(c, reply[:1]); err != nil {
+			return err
+		}
+		n = int(reply[0])
+	default:
+		return fmt.Errorf("%w: unexpected SOCKS address type %d", ErrProxy, reply[3])
+	}
+	_, err = io.CopyN(ioutil.Discard, c, int64(n+2))
+
+	return err
+}
@@ -0,0 +1,226 @@
+package fastws
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveEcho serves an echo websocket server, returning its address.
+func serveEcho(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			mode, b, err := conn.ReadMessage(nil)
+			if err == nil {
+				conn.WriteMessage(mode, b)
+			}
+		}),
+	}
+	go s.Serve(ln)
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+// serveProxy serves the proxy connections using handshake,
+// which returns the address to tunnel to.
+func serveProxy(t *testing.T, handshake func(c net.Conn, br *bufio.Reader) (string, error)) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+
+				br := bufio.NewReader(c)
+				addr, err := handshake(c, br)
+				if err != nil {
+					return
+				}
+				tc, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer tc.Close()
+
+				go io.Copy(tc, br)
+				io.Copy(c, tc)
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func testProxy(t *testing.T, proxy string) error {
+	d := Dialer{
+		Proxy: ProxyURL(proxy),
+	}
+	addr, stop := serveEcho(t)
+	defer stop()
+
+	conn, err := d.Dial("ws://" + addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.WriteString("Hello")
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		return err
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %q", b)
+	}
+	return nil
+}
+
+func TestDialerHTTPProxy(t *testing.T) {
+	proxy, stop := serveProxy(t, func(c net.Conn, br *bufio.Reader) (string, error) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return "", err
+		}
+		auth := req.Header.Get("Proxy-Authorization")
+		if req.Method != "CONNECT" || auth != "Basic "+base64.EncodeToString([]byte("user:secret")) {
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+			return "", errors.New("unauthorized")
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host, nil
+	})
+	defer stop()
+
+	if err := testProxy(t, "http://user:secret@"+proxy); err != nil {
+		t.Fatal(err)
+	}
+	if err := testProxy(t, "http://"+proxy); !errors.Is(err, ErrProxy) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrProxy)
+	}
+}
+
+func TestDialerSOCKS5Proxy(t *testing.T) {
+	proxy, stop := serveProxy(t, func(c net.Conn, br *bufio.Reader) (string, error) {
+		b := make([]byte, 512)
+		// greeting: the user and password method is required.
+		if _, err := io.ReadFull(br, b[:2]); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(br, b[:b[1]]); err != nil {
+			return "", err
+		}
+		c.Write([]byte{socksVersion, socksUserPass})
+
+		// authentication
+		if _, err := io.ReadFull(br, b[:2]); err != nil {
+			return "", err
+		}
+		user := make([]byte, b[1])
+		io.ReadFull(br, user)
+		io.ReadFull(br, b[:1])
+		password := make([]byte, b[0])
+		io.ReadFull(br, password)
+		if string(user) != "user" || string(password) != "secret" {
+			c.Write([]byte{1, 1})
+			return "", errors.New("unauthorized")
+		}
+		c.Write([]byte{1, 0})
+
+		// connect request
+		if _, err := io.ReadFull(br, b[:4]); err != nil {
+			return "", err
+		}
+		if b[3] != socksIPv4 {
+			return "", errors.New("unexpected address type")
+		}
+		io.ReadFull(br, b[:6])
+		ip := net.IP(b[:4]).String()
+		port := int(b[4])<<8 | int(b[5])
+
+		c.Write([]byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+	})
+	defer stop()
+
+	if err := testProxy(t, "socks5://user:secret@"+proxy); err != nil {
+		t.Fatal(err)
+	}
+	if err := testProxy(t, "socks5://"+proxy); !errors.Is(err, ErrProxy) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrProxy)
+	}
+}
+
+func TestDialerSOCKS5Resolve(t *testing.T) {
+	types := make(chan byte, 1)
+	proxy, stop := serveProxy(t, func(c net.Conn, br *bufio.Reader) (string, error) {
+		b := make([]byte, 512)
+		if _, err := io.ReadFull(br, b[:3]); err != nil {
+			return "", err
+		}
+		c.Write([]byte{socksVersion, socksNoAuth})
+
+		if _, err := io.ReadFull(br, b[:4]); err != nil {
+			return "", err
+		}
+		types <- b[3]
+
+		var host string
+		switch b[3] {
+		case socksIPv4:
+			io.ReadFull(br, b[:4])
+			host = net.IP(b[:4]).String()
+		case socksDomain:
+			io.ReadFull(br, b[:1])
+			n := int(b[0])
+			io.ReadFull(br, b[:n])
+			host = string(b[:n])
+		default:
+			return "", errors.New("unexpected address type")
+		}
+		io.ReadFull(br, b[:2])
+		port := int(b[0])<<8 | int(b[1])
+
+		c.Write([]byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	})
+	defer stop()
+
+	addr, stopEcho := serveEcho(t)
+	defer stopEcho()
+	_, port, _ := net.SplitHostPort(addr)
+
+	for _, tc := range []struct {
+		scheme string
+		typ    byte
+	}{
+		{"socks5", socksIPv4},
+		{"socks5h", socksDomain},
+	} {
+		d := Dialer{
+			Proxy: ProxyURL(tc.scheme + "://" + proxy),
+		}
+		conn, err := d.Dial("ws://localhost:" + port)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.scheme, err)
+		}
+		conn.Close()
+
+		if typ := <-types; typ != tc.typ {
+			t.Fatalf("%s: unexpected address type: %d <> %d", tc.scheme, typ, tc.typ)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package fastws
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// NextReader returns the mode and a reader of the next message received.
+//
+// The reader spans the continuation frames of the message, so the payload
+// doesn't need to be accumulated in memory. The reader returns io.EOF
+// when the message has been read completely.
+//
+// The message must be read completely before calling NextReader again.
+// This function responds automatically to PING and PONG messages.
+func (conn *Conn) NextReader() (Mode, io.Reader, error) {
+	conn.beginRead()
+	defer conn.endRead()
+
+	fr := AcquireFrame()
+
+	err := conn.nextDataFrame(nil, fr, false, false)
+	if err != nil {
+		ReleaseFrame(fr)
+		if !isInterruption(err) {
+			err = conn.closeOnReadError(err)
+		}
+		return ModeText, nil, err
+	}
+
+	r := &messageReader{
+		conn:      conn,
+		fr:        fr,
+		size:      uint64(fr.PayloadLen()),
+		fragments: 1,
+	}
+	if err = conn.checkMessageLimits(r.size, r.fragments); err != nil {
+		ReleaseFrame(fr)
+		return ModeText, nil, conn.closeOnReadError(err)
+	}
+
+	return fr.Mode(), r, nil
+}
+
+type messageReader struct {
+	conn *Conn
+	fr   *Frame
+	pos  int
+
+	size      uint64
+	fragments int
+}
+
+// Read reads the message payload into b reading the next frame when needed.
+func (r *messageReader) Read(b []byte) (n int, err error) {
+	r.conn.beginRead()
+	defer r.conn.endRead()
+
+	for r.fr != nil && n < len(b) {
+		p := r.fr.Payload()[r.pos:]
+		if len(p) > 0 {
+			m := copy(b[n:], p)
+			r.pos += m
+			n += m
+			continue
+		}
+
+		if err = r.next(); err != nil {
+			return n, err
+		}
+	}
+
+	if r.fr == nil && n == 0 && err == nil {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// WriteTo writes the remaining message payload to w, frame by frame,
+// without copying it.
+func (r *messageReader) WriteTo(w io.Writer) (n int64, err error) {
+	r.conn.beginRead()
+	defer r.conn.endRead()
+
+	for r.fr != nil {
+		if p := r.fr.Payload()[r.pos:]; len(p) > 0 {
+			m, err := w.Write(p)
+			r.pos += m
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		if err = r.next(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// next reads the next frame of the message, releasing the frame
+// if the message has been read completely.
+func (r *messageReader) next() error {
+	if r.fr.IsFin() {
+		ReleaseFrame(r.fr)
+		r.fr = nil
+		return nil
+	}
+
+	err := r.conn.nextDataFrame(nil, r.fr, true, false)
+	if err == nil && !r.fr.IsContinuation() {
+		err = fmt.Errorf("%w. Got %d", errFrameBetweenContinuation, r.fr.Code())
+	}
+	if err == nil {
+		r.size += uint64(r.fr.PayloadLen())
+		r.fragments++
+		err = r.conn.checkMessageLimits(r.size, r.fragments)
+	}
+	if err != nil {
+		ReleaseFrame(r.fr)
+		r.fr = nil
+		return r.conn.closeOnReadError(err)
+	}
+	r.pos = 0
+
+	return nil
+}
+
+// WriteTo writes the payload of the messages received to w
+// until the connection is closed, implementing io.WriterTo.
+//
+// The messages are written frame by frame as they are received,
+// so io.Copy doesn't buffer whole messages. As Read, WriteTo doesn't
+// preserve the message boundaries and writes the remainder of the message
+// being read by Read first. The duplicated messages are not dropped.
+// If permessage-deflate is negotiated (see Dialer.Compress) the messages
+// are decompressed, so they are read whole as Read does.
+//
+// WriteTo returns a nil error when the connection is closed normally.
+func (conn *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	if conn.rpos < len(conn.rb) {
+		m, err := w.Write(conn.rb[conn.rpos:])
+		conn.rpos += m
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	for {
+		var m int64
+		if conn.compress {
+			m, err = conn.writeMessageTo(w)
+		} else {
+			var r io.Reader
+			_, r, err = conn.NextReader()
+			if err == nil {
+				m, err = r.(*messageReader).WriteTo(w)
+			}
+		}
+		n += m
+		if err != nil {
+			if err = readEOF(err); err == EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+}
+
+// writeMessageTo reads the next message (decompressed if needed) into
+// the buffer of Read, writing it to w.
+func (conn *Conn) writeMessageTo(w io.Writer) (int64, error) {
+	conn.beginRead()
+	defer conn.endRead()
+
+	var err error
+	_, conn.rb, err = conn.read(nil, conn.rb[:0])
+	conn.rpos = 0
+	if err != nil {
+		conn.rb = conn.rb[:0]
+		return 0, err
+	}
+
+	m, err := w.Write(conn.rb)
+	conn.rpos += m
+	return int64(m), err
+}
+
+// beginRead and endRead track the number of reads in progress.
+// The reads hold conn.readLck, so conn is not released while reading.
+func (conn *Conn) beginRead() {
+	conn.readLck.RLock()
+	atomic.AddInt32(&conn.readers, 1)
+}
+
+func (conn *Conn) endRead() {
+	atomic.AddInt32(&conn.readers, -1)
+	conn.readLck.RUnlock()
+}
+
+// checkConcurrentRead checks the data frame fr has been taken by the reader
+// of its message, as other reader might have taken some frames.
+//
+// betweenContinue is true if the reader is reading a fragmented message.
+// A continuation frame starting a message or other frame continuing it
+// means the frames of a message have been split between the readers.
+func (conn *Conn) checkConcurrentRead(fr *Frame, betweenContinue bool) error {
+	if atomic.LoadInt32(&conn.readers) < 2 && atomic.LoadInt32(&conn.poisoned) == 0 {
+		return nil
+	}
+
+	switch {
+	case atomic.LoadInt32(&conn.poisoned) == 1,
+		betweenContinue != fr.IsContinuation():
+		atomic.StoreInt32(&conn.poisoned, 1)
+		if conn.Debug {
+			panic(ErrConcurrentRead)
+		}
+		return ErrConcurrentRead
+	}
+
+	return nil
+}
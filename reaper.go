@@ -0,0 +1,166 @@
+package fastws
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reaper pings the connections idle (nothing received) for IdleTimeout,
+// closing the ones not answering in time with ErrKeepAliveTimeout.
+//
+// Unlike EnableKeepAlive, a single goroutine checks all the connections,
+// so the idle connections don't cost a timer each. It's useful to free
+// the connections of the peers gone without closing (i.e.: browser tabs
+// left open behind a NAT) without implementing timers in the handlers.
+//
+// The connections are added and removed by the Upgraders using the Reaper
+// (see Upgrader.Reaper). A Reaper is safe for concurrent use.
+type Reaper struct {
+	// IdleTimeout is the time without receiving anything after which
+	// the connection is pinged. A Reaper with IdleTimeout <= 0 does nothing.
+	IdleTimeout time.Duration
+
+	// PingTimeout is the time to wait for the PONG.
+	//
+	// By default PingTimeout is IdleTimeout.
+	PingTimeout time.Duration
+
+	lck sync.Mutex
+	// conns holds the PING in progress of the connections (nil if none).
+	conns map[*Conn]*reaperPing
+	stop  chan struct{}
+}
+
+// reaperPing is the PING in progress of a connection.
+type reaperPing struct {
+	// quit interrupts the PING and done is closed when it returns.
+	quit chan struct{}
+	done chan struct{}
+	// interrupted is guarded by Reaper.lck.
+	interrupted bool
+}
+
+// interrupt interrupts p. Reaper.lck must be held.
+func (p *reaperPing) interrupt() {
+	if !p.interrupted {
+		p.interrupted = true
+		close(p.quit)
+	}
+}
+
+// Add adds conn to r, starting r if needed.
+func (r *Reaper) Add(conn *Conn) {
+	r.lck.Lock()
+	if r.conns == nil {
+		r.conns = make(map[*Conn]*reaperPing)
+	}
+	r.conns[conn] = nil
+	if r.stop == nil && r.IdleTimeout > 0 {
+		r.stop = make(chan struct{})
+		go r.run(r.stop)
+	}
+	r.lck.Unlock()
+}
+
+// Remove removes conn from r, interrupting and waiting for its PING
+// in progress (if any), so conn can be released after.
+func (r *Reaper) Remove(conn *Conn) {
+	r.lck.Lock()
+	p := r.conns[conn]
+	delete(r.conns, conn)
+	if p != nil {
+		p.interrupt()
+	}
+	r.lck.Unlock()
+
+	if p != nil {
+		<-p.done
+	}
+}
+
+// Len returns the number of connections in r.
+func (r *Reaper) Len() int {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	return len(r.conns)
+}
+
+// Close stops r, interrupting the PINGs in progress.
+// The connections are kept, so r starts again when adding a connection.
+func (r *Reaper) Close() {
+	r.lck.Lock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	for _, p := range r.conns {
+		if p != nil {
+			p.interrupt()
+		}
+	}
+	r.lck.Unlock()
+}
+
+func (r *Reaper) run(stop <-chan struct{}) {
+	// checking twice per IdleTimeout the connections are pinged
+	// within 1.5*IdleTimeout of the last frame received.
+	ticker := time.NewTicker(r.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reap()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	for conn, p := range r.conns {
+		if p != nil {
+			continue
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&conn.stats.lastRead))
+		if conn.getClock().Now().Sub(last) < r.IdleTimeout {
+			continue
+		}
+
+		p = &reaperPing{
+			quit: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+		r.conns[conn] = p
+		go r.ping(conn, p)
+	}
+}
+
+func (r *Reaper) ping(conn *Conn, p *reaperPing) {
+	defer close(p.done)
+
+	timeout := r.PingTimeout
+	if timeout <= 0 {
+		timeout = r.IdleTimeout
+	}
+
+	timer := conn.getClock().NewTimer(timeout)
+	_, err := conn.ping(nil, p.quit, timer.C())
+	timer.Stop()
+
+	if err == errPingTimeout {
+		conn.closeWithError(ErrKeepAliveTimeout, StatusGoAway)
+	}
+
+	r.lck.Lock()
+	if r.conns[conn] == p {
+		r.conns[conn] = nil
+	}
+	r.lck.Unlock()
+}
@@ -0,0 +1,94 @@
+package fastws
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReaper(t *testing.T) {
+	idle, idleClient := pipeConns()
+	defer idleClient.mustClose(false)
+	defer idle.mustClose(false)
+
+	alive, aliveClient := pipeConns()
+	defer aliveClient.mustClose(false)
+	defer alive.mustClose(false)
+
+	// only aliveClient reads, so idleClient doesn't answer the PINGs.
+	go aliveClient.ReadMessage(nil)
+
+	r := Reaper{
+		IdleTimeout: time.Millisecond * 20,
+		PingTimeout: time.Millisecond * 20,
+	}
+	defer r.Close()
+
+	r.Add(idle)
+	r.Add(alive)
+
+	_, _, err := idle.ReadMessage(nil)
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if err := idle.readError(); err != ErrKeepAliveTimeout {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrKeepAliveTimeout)
+	}
+
+	if alive.isClosed() {
+		t.Fatalf("Connection closed: %v", alive.readError())
+	}
+
+	r.Remove(idle)
+	if n := r.Len(); n != 1 {
+		t.Fatalf("Unexpected length: %d <> 1", n)
+	}
+}
+
+func TestReaperRemoveWaitsPing(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2) // never answers the PINGs.
+
+	conn := acquireConn(c1)
+	conn.server = true
+	defer conn.mustClose(false)
+
+	r := Reaper{
+		IdleTimeout: time.Millisecond * 10,
+		PingTimeout: time.Minute,
+	}
+	defer r.Close()
+
+	r.Add(conn)
+
+	var p *reaperPing
+	for p == nil {
+		time.Sleep(time.Millisecond * 5)
+		r.lck.Lock()
+		p = r.conns[conn]
+		r.lck.Unlock()
+	}
+
+	removed := make(chan struct{})
+	go func() {
+		r.Remove(conn)
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("Remove didn't interrupt the PING")
+	}
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("Remove returned before the PING")
+	}
+	if conn.isClosed() {
+		t.Fatalf("Connection closed: %v", conn.readError())
+	}
+}
@@ -0,0 +1,238 @@
+package fastws
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReconnectingConnClosed is returned when using a closed ReconnectingConn.
+var ErrReconnectingConnClosed = errors.New("reconnecting connection closed")
+
+const (
+	// DefaultMinBackoff is the default time waited before the first reconnect attempt.
+	DefaultMinBackoff = time.Millisecond * 100
+	// DefaultMaxBackoff is the default max time waited between reconnect attempts.
+	DefaultMaxBackoff = time.Second * 30
+)
+
+// ReconnectingConn is a client connection which dials again when
+// the connection is lost.
+//
+// The reconnect attempts are spaced with an exponential backoff,
+// honoring the Retry-After header sent by the servers refusing to upgrade.
+//
+// Only one goroutine should call ReadMessage, which reconnects when
+// the connection is lost. The writes are done over the current connection,
+// waiting while reconnecting. The writes failing are not retried.
+type ReconnectingConn struct {
+	// URL is the URL dialed.
+	URL string
+
+	// Dialer is the Dialer used to dial URL.
+	//
+	// If nil a zero Dialer is used.
+	Dialer *Dialer
+
+	// Dial, if set, is used instead of dialing URL with Dialer.
+	Dial func() (*Conn, error)
+
+	// MinBackoff is the time waited before the first reconnect attempt.
+	// The time is doubled on every failed attempt up to MaxBackoff.
+	//
+	// By default MinBackoff is DefaultMinBackoff.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the max time waited between reconnect attempts.
+	//
+	// By default MaxBackoff is DefaultMaxBackoff.
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the max number of consecutive failed attempts
+	// before giving up. 0 means no limit.
+	MaxAttempts int
+
+	// OnConnect is called after every successful dial, before ReadMessage
+	// uses the connection. The connection can be written by others meanwhile.
+	// If OnConnect returns an error the connection is closed and dialed again.
+	OnConnect func(conn *Conn) error
+
+	lck    sync.Mutex
+	dialMu sync.Mutex
+	conn   *Conn
+	closed bool
+	done   chan struct{}
+}
+
+// Conn returns the current connection, dialing if not connected.
+func (rc *ReconnectingConn) Conn() (*Conn, error) {
+	return rc.connect(false)
+}
+
+// current returns the current connection, nil if not connected.
+func (rc *ReconnectingConn) current() *Conn {
+	rc.lck.Lock()
+	conn := rc.conn
+	rc.lck.Unlock()
+
+	return conn
+}
+
+// ReadMessage reads the next message as Conn.ReadMessage does,
+// reconnecting when the connection is lost.
+//
+// ReadMessage returns an error when the ReconnectingConn is closed
+// or the reconnect attempts are exhausted.
+func (rc *ReconnectingConn) ReadMessage(b []byte) (Mode, []byte, error) {
+	n := len(b)
+	for reconnect := false; ; reconnect = true {
+		conn, err := rc.connect(reconnect)
+		if err != nil {
+			return ModeText, b, err
+		}
+
+		mode, bb, err := conn.ReadMessage(b[:n])
+		if err == nil || rc.isClosed() {
+			return mode, bb, err
+		}
+		b = bb
+		rc.drop(conn)
+	}
+}
+
+// WriteMessage writes b using mode over the current connection, dialing if not connected.
+func (rc *ReconnectingConn) WriteMessage(mode Mode, b []byte) (int, error) {
+	conn, err := rc.connect(false)
+	if err != nil {
+		return 0, err
+	}
+	return conn.WriteMessage(mode, b)
+}
+
+// Close closes the current connection and stops reconnecting.
+func (rc *ReconnectingConn) Close() error {
+	rc.lck.Lock()
+	if rc.closed {
+		rc.lck.Unlock()
+		return ErrReconnectingConnClosed
+	}
+	rc.closed = true
+	if rc.done == nil {
+		rc.done = make(chan struct{})
+	}
+	close(rc.done)
+	conn := rc.conn
+	rc.conn = nil
+	rc.lck.Unlock()
+
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (rc *ReconnectingConn) isClosed() bool {
+	rc.lck.Lock()
+	closed := rc.closed
+	rc.lck.Unlock()
+
+	return closed
+}
+
+// drop closes conn if it's still the current connection.
+func (rc *ReconnectingConn) drop(conn *Conn) {
+	rc.lck.Lock()
+	if rc.conn == conn {
+		rc.conn = nil
+	}
+	rc.lck.Unlock()
+
+	conn.mustClose(false)
+}
+
+// connect returns the current connection or dials a new one.
+//
+// If wait is true the backoff is waited before the first attempt.
+func (rc *ReconnectingConn) connect(wait bool) (*Conn, error) {
+	rc.dialMu.Lock()
+	defer rc.dialMu.Unlock()
+
+	rc.lck.Lock()
+	conn, closed := rc.conn, rc.closed
+	if rc.done == nil {
+		rc.done = make(chan struct{})
+	}
+	done := rc.done
+	rc.lck.Unlock()
+
+	if closed {
+		return nil, ErrReconnectingConnClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	backoff := rc.MinBackoff
+	if backoff <= 0 {
+		backoff = DefaultMinBackoff
+	}
+	max := rc.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || wait {
+			d := backoff
+			if ra, ok := RetryAfter(err); ok && ra > d {
+				d = ra
+			}
+			select {
+			case <-time.After(d):
+			case <-done:
+				return nil, ErrReconnectingConnClosed
+			}
+			if attempt > 0 {
+				if backoff *= 2; backoff > max {
+					backoff = max
+				}
+			}
+		}
+
+		conn, err = rc.dial()
+		if err == nil {
+			rc.lck.Lock()
+			closed = rc.closed
+			if !closed {
+				rc.conn = conn
+			}
+			rc.lck.Unlock()
+
+			if closed {
+				conn.Close()
+				return nil, ErrReconnectingConnClosed
+			}
+			if rc.OnConnect == nil {
+				return conn, nil
+			}
+			if err = rc.OnConnect(conn); err == nil {
+				return conn, nil
+			}
+			rc.drop(conn)
+		}
+		if rc.MaxAttempts > 0 && attempt+1 >= rc.MaxAttempts {
+			return nil, err
+		}
+	}
+}
+
+func (rc *ReconnectingConn) dial() (*Conn, error) {
+	switch {
+	case rc.Dial != nil:
+		return rc.Dial()
+	case rc.Dialer != nil:
+		return rc.Dialer.Dial(rc.URL)
+	}
+	return Dial(rc.URL)
+}
@@ -0,0 +1,120 @@
+package fastws
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// configureEchoServer returns a listener of a server replying "ack:" to every message,
+// closing the connection when receiving "drop".
+func configureEchoServer() *fasthttputil.InmemoryListener {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			var b []byte
+			var err error
+			for {
+				_, b, err = conn.ReadMessage(b[:0])
+				if err != nil || string(b) == "drop" {
+					break
+				}
+				conn.WriteString("ack:" + string(b))
+			}
+		}),
+	}
+	go s.Serve(ln)
+
+	return ln
+}
+
+func dialInmemory(ln *fasthttputil.InmemoryListener) func() (*Conn, error) {
+	return func() (*Conn, error) {
+		c, err := ln.Dial()
+		if err != nil {
+			return nil, err
+		}
+		return Client(c, "http://localhost/")
+	}
+}
+
+func expectString(t *testing.T, rc *ReconnectingConn, expected string) {
+	_, b, err := rc.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Unexpected message: %s <> %s", b, expected)
+	}
+}
+
+func TestReconnectingConn(t *testing.T) {
+	ln := configureEchoServer()
+	defer ln.Close()
+
+	var connects int32
+	rc := &ReconnectingConn{
+		Dial:       dialInmemory(ln),
+		MinBackoff: time.Millisecond,
+		OnConnect: func(conn *Conn) error {
+			atomic.AddInt32(&connects, 1)
+			return nil
+		},
+	}
+	defer rc.Close()
+
+	rc.WriteMessage(ModeText, []byte("Hello"))
+	expectString(t, rc, "ack:Hello")
+
+	rc.WriteMessage(ModeText, []byte("drop"))
+
+	// the connection is dialed again while reading.
+	go func() {
+		for rc.current() == nil || atomic.LoadInt32(&connects) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		rc.WriteMessage(ModeText, []byte("Hello again"))
+	}()
+	expectString(t, rc, "ack:Hello again")
+
+	if n := atomic.LoadInt32(&connects); n != 2 {
+		t.Fatalf("Unexpected connects: %d <> 2", n)
+	}
+
+	rc.Close()
+	if _, _, err := rc.ReadMessage(nil); err != ErrReconnectingConnClosed {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrReconnectingConnClosed)
+	}
+}
+
+func TestReconnectingConnMaxAttempts(t *testing.T) {
+	attempts := 0
+	rc := &ReconnectingConn{
+		Dial: func() (*Conn, error) {
+			attempts++
+			return nil, &UpgradeError{
+				StatusCode: fasthttp.StatusServiceUnavailable,
+				RetryAfter: time.Millisecond * 20,
+			}
+		},
+		MinBackoff:  time.Millisecond,
+		MaxAttempts: 3,
+	}
+
+	start := time.Now()
+	_, err := rc.Conn()
+	if !errors.Is(err, ErrCannotUpgrade) {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrCannotUpgrade)
+	}
+	if attempts != 3 {
+		t.Fatalf("Unexpected attempts: %d <> 3", attempts)
+	}
+	// the Retry-After time is honored.
+	if d := time.Since(start); d < time.Millisecond*40 {
+		t.Fatalf("Retry-After was not honored: %s", d)
+	}
+}
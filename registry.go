@@ -0,0 +1,237 @@
+package fastws
+
+import (
+	"sync"
+)
+
+// Registry tracks the live connections of the Upgraders and NetUpgraders
+// using it (see Upgrader.Registry).
+//
+// The connections are added when upgraded (before OnConnect)
+// and removed once the handler returns and the connection is closed,
+// so the Registry never holds dead connections.
+// The Upgraders enforce their DuplicatePolicy on the connections
+// of the Registry (see Identity).
+//
+// The connections are released (reused) once removed, so they are passed
+// to callbacks and are only valid inside them: Remove waits for
+// the callbacks using the connection, which must not remove it.
+// A Registry is safe for concurrent use. The zero value is ready to use.
+type Registry struct {
+	// ID returns the ID of conn used by Get. The connections
+	// with an empty ID are not indexed. It's called once when adding conn.
+	//
+	// By default the ID is the ID of the Session of the connection (see SessionKey).
+	ID func(conn *Conn) string
+
+	lck        sync.RWMutex
+	conns      map[*Conn]*registered
+	ids        map[string]*Conn
+	identities map[string][]*Conn
+	// unused is signaled when a connection is no longer used by a callback.
+	unused *sync.Cond
+}
+
+// registered holds the keys indexing a connection.
+type registered struct {
+	conn     *Conn
+	id       string
+	identity string
+	// refs is the number of callbacks using the connection, guarded by Registry.lck.
+	refs int
+}
+
+// Add adds conn to r.
+//
+// The connections having an identity (see IdentityKey) are indexed by it.
+func (r *Registry) Add(conn *Conn) {
+	r.add(conn, nil)
+}
+
+// add adds conn to r if accept, given the connections of the same identity,
+// returns true. A nil accept accepts every connection.
+func (r *Registry) add(conn *Conn, accept func(conns []*Conn) bool) bool {
+	reg := &registered{
+		conn: conn,
+		id:   r.id(conn),
+	}
+	reg.identity, _ = conn.UserValue(IdentityKey).(string)
+
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if accept != nil && !accept(r.identities[reg.identity]) {
+		return false
+	}
+
+	if r.conns == nil {
+		r.conns = make(map[*Conn]*registered)
+		r.ids = make(map[string]*Conn)
+		r.identities = make(map[string][]*Conn)
+		r.unused = sync.NewCond(&r.lck)
+	}
+	r.conns[conn] = reg
+	if reg.id != "" {
+		r.ids[reg.id] = conn
+	}
+	if reg.identity != "" {
+		r.identities[reg.identity] = append(r.identities[reg.identity], conn)
+	}
+
+	return true
+}
+
+// Remove removes conn from r, waiting for the callbacks using it.
+func (r *Registry) Remove(conn *Conn) {
+	r.lck.Lock()
+	reg := r.conns[conn]
+	if reg != nil {
+		delete(r.conns, conn)
+		if reg.id != "" && r.ids[reg.id] == conn {
+			delete(r.ids, reg.id)
+		}
+		if reg.identity != "" {
+			r.removeIdentity(conn, reg.identity)
+		}
+		for reg.refs > 0 {
+			r.unused.Wait()
+		}
+	}
+	r.lck.Unlock()
+}
+
+// removeIdentity removes conn from the connections of identity. r.lck must be held.
+func (r *Registry) removeIdentity(conn *Conn, identity string) {
+	conns := r.identities[identity]
+	for i := range conns {
+		if conns[i] == conn {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(r.identities, identity)
+	} else {
+		r.identities[identity] = conns
+	}
+}
+
+// Len returns the number of connections in r.
+func (r *Registry) Len() int {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+
+	return len(r.conns)
+}
+
+// Get calls f with the connection identified by id (see ID),
+// returning false if not found.
+func (r *Registry) Get(id string, f func(conn *Conn)) bool {
+	r.lck.Lock()
+	reg := r.conns[r.ids[id]]
+	if reg != nil {
+		reg.refs++
+	}
+	r.lck.Unlock()
+
+	if reg == nil {
+		return false
+	}
+	defer r.unuse(reg)
+
+	f(reg.conn)
+	return true
+}
+
+// Identity calls f for the connections of identity (see IdentityKey),
+// from the oldest to the newest, until f returns false.
+func (r *Registry) Identity(identity string, f func(conn *Conn) bool) {
+	r.lck.Lock()
+	regs := r.use(r.identities[identity])
+	r.lck.Unlock()
+
+	r.call(regs, f)
+}
+
+// identityLen returns the number of connections of identity.
+func (r *Registry) identityLen(identity string) int {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+
+	return len(r.identities[identity])
+}
+
+// Find calls f for the connections whose user value key equals value
+// until f returns false.
+func (r *Registry) Find(key string, value interface{}, f func(conn *Conn) bool) {
+	r.Range(func(conn *Conn) bool {
+		if conn.UserValue(key) == value {
+			return f(conn)
+		}
+		return true
+	})
+}
+
+// Range calls f for every connection in r until f returns false.
+//
+// f is called on a snapshot of the connections, so it can use r
+// (i.e.: closing the connections). The connections removed meanwhile
+// can be closed already, but they are not released until f returns.
+func (r *Registry) Range(f func(conn *Conn) bool) {
+	r.lck.Lock()
+	conns := make([]*Conn, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	regs := r.use(conns)
+	r.lck.Unlock()
+
+	r.call(regs, f)
+}
+
+// use marks conns as used by a callback. r.lck must be held.
+func (r *Registry) use(conns []*Conn) []*registered {
+	regs := make([]*registered, len(conns))
+	for i, conn := range conns {
+		regs[i] = r.conns[conn]
+		regs[i].refs++
+	}
+	return regs
+}
+
+// call calls f for the connections of regs (see use) until f returns false,
+// marking them as unused.
+func (r *Registry) call(regs []*registered, f func(conn *Conn) bool) {
+	for i, reg := range regs {
+		if !r.callOne(reg, f) {
+			for _, reg := range regs[i+1:] {
+				r.unuse(reg)
+			}
+			return
+		}
+	}
+}
+
+func (r *Registry) callOne(reg *registered, f func(conn *Conn) bool) bool {
+	defer r.unuse(reg)
+	return f(reg.conn)
+}
+
+// unuse marks the connection of reg as no longer used by a callback,
+// waking up Remove.
+func (r *Registry) unuse(reg *registered) {
+	r.lck.Lock()
+	reg.refs--
+	r.lck.Unlock()
+	r.unused.Broadcast()
+}
+
+func (r *Registry) id(conn *Conn) string {
+	if r.ID != nil {
+		return r.ID(conn)
+	}
+	if s, ok := conn.UserValue(SessionKey).(*Session); ok {
+		return s.ID
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package fastws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRegistry(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetUserValue("user", 1)
+	client.SetUserValue("user", 2)
+	server.SetUserValue(IdentityKey, "bob")
+	client.SetUserValue(IdentityKey, "bob")
+
+	r := Registry{
+		ID: func(conn *Conn) string {
+			if conn.UserValue("user") == 1 {
+				return "first"
+			}
+			return ""
+		},
+	}
+	r.Add(server)
+	r.Add(client)
+
+	if n := r.Len(); n != 2 {
+		t.Fatalf("Unexpected length: %d <> 2", n)
+	}
+	var got *Conn
+	if !r.Get("first", func(conn *Conn) { got = conn }) || got != server {
+		t.Fatalf("Unexpected connection: %p <> %p", got, server)
+	}
+	if conns := collect(func(f func(*Conn) bool) { r.Find("user", 2, f) }); len(conns) != 1 || conns[0] != client {
+		t.Fatalf("Unexpected connections: %v", conns)
+	}
+
+	if conns := collect(func(f func(*Conn) bool) { r.Identity("bob", f) }); len(conns) != 2 || conns[0] != server {
+		t.Fatalf("Unexpected connections: %v", conns)
+	}
+
+	n := 0
+	r.Range(func(conn *Conn) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("Range not stopped: %d", n)
+	}
+
+	r.Remove(server)
+	if r.Get("first", func(conn *Conn) {}) {
+		t.Fatal("Unexpected connection after removing it")
+	}
+	if conns := collect(func(f func(*Conn) bool) { r.Identity("bob", f) }); len(conns) != 1 || conns[0] != client {
+		t.Fatalf("Unexpected connections: %v", conns)
+	}
+	if n := r.Len(); n != 1 {
+		t.Fatalf("Unexpected length: %d <> 1", n)
+	}
+}
+
+// collect returns the connections iterated by iter.
+func collect(iter func(f func(conn *Conn) bool)) []*Conn {
+	var conns []*Conn
+	iter(func(conn *Conn) bool {
+		conns = append(conns, conn)
+		return true
+	})
+	return conns
+}
+
+func TestRegistryRemoveWaitsCallbacks(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var r Registry
+	r.Add(server)
+
+	using := make(chan struct{})
+	unblock := make(chan struct{})
+	go r.Range(func(conn *Conn) bool {
+		close(using)
+		<-unblock
+		return true
+	})
+	<-using
+
+	removed := make(chan struct{})
+	go func() {
+		r.Remove(server)
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+		t.Fatal("Remove returned while the connection is used")
+	case <-time.After(time.Millisecond * 50):
+	}
+	if n := r.Len(); n != 0 {
+		t.Fatalf("Unexpected length: %d <> 0", n)
+	}
+
+	close(unblock)
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("Remove didn't return")
+	}
+}
+
+func TestUpgraderRegistry(t *testing.T) {
+	handled := make(chan *Conn, 1)
+	release := make(chan struct{})
+
+	var r Registry
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Registry: &r,
+		Sessions: &SessionIssuer{
+			Key: []byte("key"),
+		},
+		Handler: func(conn *Conn) {
+			handled <- conn
+			<-release
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+	// replying to the close frame.
+	go conn.ReadMessage(nil)
+
+	sconn := <-handled
+	session := sconn.UserValue(SessionKey).(*Session)
+	var got *Conn
+	if !r.Get(session.ID, func(conn *Conn) { got = conn }) || got != sconn {
+		t.Fatal("Connection not registered")
+	}
+	close(release)
+}
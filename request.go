@@ -0,0 +1,73 @@
+package fastws
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Request holds the parts of the upgrade request available to the handlers
+// once the connection has been upgraded.
+type Request struct {
+	// Host is the host requested.
+	Host string
+
+	// Path is the path of the request URI.
+	Path string
+
+	// QueryString is the query string of the request URI, without the '?'.
+	QueryString string
+
+	// Header holds the request headers.
+	Header http.Header
+
+	// Subprotocol is the subprotocol selected when upgrading
+	// (sent in the Sec-WebSocket-Protocol response header). Can be empty.
+	Subprotocol string
+}
+
+// RequestURI returns the path and the query string of the request.
+func (r *Request) RequestURI() string {
+	if r.QueryString == "" {
+		return r.Path
+	}
+	return r.Path + "?" + r.QueryString
+}
+
+// Query parses the query string returning its values.
+// The malformed pairs are ignored.
+func (r *Request) Query() url.Values {
+	values, _ := url.ParseQuery(r.QueryString)
+	return values
+}
+
+// Request returns the upgrade request of the connection.
+//
+// Request returns nil if the connection has not been upgraded by
+// Upgrader nor NetUpgrader (i.e.: client connections).
+func (conn *Conn) Request() *Request {
+	return conn.req
+}
+
+func newRequest(ctx *fasthttp.RequestCtx) *Request {
+	r := &Request{
+		Host:        string(ctx.Host()),
+		Path:        string(ctx.Path()),
+		QueryString: string(ctx.URI().QueryString()),
+		Header:      make(http.Header),
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		r.Header.Add(string(k), string(v))
+	})
+	return r
+}
+
+func newNetRequest(req *http.Request) *Request {
+	return &Request{
+		Host:        req.Host,
+		Path:        req.URL.Path,
+		QueryString: req.URL.RawQuery,
+		Header:      req.Header.Clone(),
+	}
+}
@@ -0,0 +1,82 @@
+package fastws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func checkRequest(t *testing.T, r *Request) {
+	t.Helper()
+
+	if r == nil {
+		t.Fatal("The request must not be nil")
+	}
+	if r.Path != "/chat" || r.RequestURI() != "/chat?room=go" {
+		t.Fatalf("Unexpected URI: %s", r.RequestURI())
+	}
+	if room := r.Query().Get("room"); room != "go" {
+		t.Fatalf("Unexpected room: %s", room)
+	}
+	if tenant := r.Header.Get("X-Tenant"); tenant != "acme" {
+		t.Fatalf("Unexpected tenant: %s", tenant)
+	}
+}
+
+func TestConnRequest(t *testing.T) {
+	reqs := make(chan *Request, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := fasthttp.Server{
+		Handler: Upgrade(func(conn *Conn) {
+			reqs <- conn.Request()
+		}),
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.Set("X-Tenant", "acme")
+
+	conn, err := ClientWithHeaders(c, "http://localhost/chat?room=go", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if conn.Request() != nil {
+		t.Fatal("Unexpected request in a client connection")
+	}
+	checkRequest(t, <-reqs)
+}
+
+func TestConnNetRequest(t *testing.T) {
+	reqs := make(chan *Request, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(NetUpgrade(func(conn *Conn) {
+		reqs <- conn.Request()
+	})))
+	defer s.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.Set("X-Tenant", "acme")
+
+	conn, err := DialWithHeaders(strings.Replace(s.URL, "http", "ws", 1)+"/chat?room=go", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	checkRequest(t, <-reqs)
+}
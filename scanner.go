@@ -0,0 +1,82 @@
+package fastws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// NewTextReader returns a reader of the text messages received by conn,
+// for bridging line-oriented protocols over text messages.
+//
+// delim is appended to the messages not ending with it, so every message
+// is at least one delimited record. The binary messages are skipped.
+// The reader returns io.EOF when the connection is closed normally.
+func NewTextReader(conn *Conn, delim []byte) io.Reader {
+	return &textReader{
+		conn:  conn,
+		delim: append([]byte(nil), delim...),
+	}
+}
+
+type textReader struct {
+	conn  *Conn
+	delim []byte
+	b     []byte
+	pos   int
+}
+
+func (r *textReader) Read(b []byte) (int, error) {
+	for r.pos == len(r.b) {
+		mode, msg, err := r.conn.ReadMessage(r.b[:0])
+		r.b, r.pos = msg, 0
+		if err != nil {
+			r.b = r.b[:0]
+			return 0, readEOF(err)
+		}
+		if mode != ModeText {
+			r.b = r.b[:0]
+			continue
+		}
+		if len(r.delim) > 0 && !bytes.HasSuffix(r.b, r.delim) {
+			r.b = append(r.b, r.delim...)
+		}
+	}
+
+	n := copy(b, r.b[r.pos:])
+	r.pos += n
+
+	return n, nil
+}
+
+// NewScanner returns a bufio.Scanner splitting the text messages received
+// by conn by delim (see NewTextReader).
+//
+// The tokens returned don't include delim. A token can span many messages
+// if the peer fragments the records, and a message can hold many records.
+func NewScanner(conn *Conn, delim []byte) *bufio.Scanner {
+	s := bufio.NewScanner(NewTextReader(conn, delim))
+	s.Split(ScanDelim(delim))
+	return s
+}
+
+// ScanDelim returns a bufio.SplitFunc splitting the tokens by delim.
+//
+// The last token is returned even if it's not followed by delim.
+func ScanDelim(delim []byte) bufio.SplitFunc {
+	delim = append([]byte(nil), delim...)
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if len(delim) > 0 {
+			if i := bytes.Index(data, delim); i >= 0 {
+				return i + len(delim), data[:i], nil
+			}
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,39 @@
+package fastws
+
+import (
+	"testing"
+)
+
+func TestNewScanner(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	go func() {
+		client.WriteString("PING")
+		client.WriteString("SET a 1\r\nGET a\r\n")
+		client.WriteMessage(ModeBinary, []byte("skipped"))
+		client.WriteString("DEL a")
+		client.SendCode(CodeClose, StatusNone, nil)
+	}()
+
+	s := NewScanner(server, []byte("\r\n"))
+
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"PING", "SET a 1", "GET a", "DEL a"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected lines: %q <> %q", lines, expected)
+	}
+	for i := range lines {
+		if lines[i] != expected[i] {
+			t.Fatalf("Unexpected line: %q <> %q", lines[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package fastws
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when the queue of a connection is full.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrSchedulerStopped is returned when enqueuing into a stopped Scheduler.
+	ErrSchedulerStopped = errors.New("scheduler is stopped")
+)
+
+const (
+	// DefaultTimeSlice is the default Scheduler time slice.
+	DefaultTimeSlice = time.Millisecond
+	// DefaultQueueSize is the default Scheduler queue size per connection.
+	DefaultQueueSize = 128
+)
+
+// Scheduler writes messages to many connections fairly.
+//
+// Every connection has its own queue of messages and the queues are
+// served in round-robin order by a fixed number of workers.
+// A worker writes the messages of a connection until the time slice
+// expires and then moves to the next connection, so one slow or congested
+// peer only holds one worker instead of serializing the whole pass.
+type Scheduler struct {
+	// Workers is the number of goroutines writing the messages.
+	//
+	// By default Workers is runtime.NumCPU().
+	Workers int
+
+	// TimeSlice is the time a worker spends writing to a connection
+	// before moving to the next one.
+	//
+	// By default TimeSlice is DefaultTimeSlice.
+	TimeSlice time.Duration
+
+	// QueueSize is the max number of messages queued per connection.
+	//
+	// By default QueueSize is DefaultQueueSize.
+	QueueSize int
+
+	// OnError is called when writing to a connection fails.
+	// The connection is removed from the Scheduler before calling OnError.
+	OnError func(conn *Conn, err error)
+
+	lck     sync.Mutex
+	cond    *sync.Cond
+	queues  map[*Conn]*schedQueue
+	ready   []*schedQueue
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type schedMessage struct {
+	mode Mode
+	b    []byte
+}
+
+type schedQueue struct {
+	conn      *Conn
+	msgs      []schedMessage
+	scheduled bool
+	removed   bool
+}
+
+// Start starts the Scheduler workers.
+func (s *Scheduler) Start() {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if s.cond != nil {
+		return
+	}
+	s.cond = sync.NewCond(&s.lck)
+	s.queues = make(map[*Conn]*schedQueue)
+	s.stopped = false
+
+	n := s.Workers
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	s.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go s.worker()
+	}
+}
+
+// Stop stops the Scheduler waiting for the workers to finish
+// writing the current message. Queued messages are discarded.
+func (s *Scheduler) Stop() {
+	s.lck.Lock()
+	if s.cond == nil || s.stopped {
+		s.lck.Unlock()
+		return
+	}
+	s.stopped = true
+	s.queues = nil
+	s.ready = nil
+	s.cond.Broadcast()
+	s.lck.Unlock()
+
+	s.wg.Wait()
+
+	s.lck.Lock()
+	s.cond = nil
+	s.lck.Unlock()
+}
+
+// Enqueue queues b to be written to conn using mode.
+//
+// b is not copied, so it must not be modified after calling Enqueue.
+// That allows sharing the same message between many connections.
+func (s *Scheduler) Enqueue(conn *Conn, mode Mode, b []byte) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if s.cond == nil || s.stopped {
+		return ErrSchedulerStopped
+	}
+
+	q := s.queues[conn]
+	if q == nil {
+		q = &schedQueue{conn: conn}
+		s.queues[conn] = q
+	}
+
+	max := s.QueueSize
+	if max <= 0 {
+		max = DefaultQueueSize
+	}
+	if len(q.msgs) >= max {
+		return ErrQueueFull
+	}
+	q.msgs = append(q.msgs, schedMessage{mode: mode, b: b})
+
+	if !q.scheduled {
+		q.scheduled = true
+		s.ready = append(s.ready, q)
+		s.cond.Signal()
+	}
+
+	return nil
+}
+
+// Remove discards the messages queued for conn.
+func (s *Scheduler) Remove(conn *Conn) {
+	s.lck.Lock()
+	s.remove(conn)
+	s.lck.Unlock()
+}
+
+func (s *Scheduler) remove(conn *Conn) {
+	if q := s.queues[conn]; q != nil {
+		q.removed = true
+		q.msgs = nil
+		delete(s.queues, conn)
+	}
+}
+
+// Len returns the number of messages queued for conn.
+func (s *Scheduler) Len(conn *Conn) int {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if q := s.queues[conn]; q != nil {
+		return len(q.msgs)
+	}
+	return 0
+}
+
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+
+	slice := s.TimeSlice
+	if slice <= 0 {
+		slice = DefaultTimeSlice
+	}
+
+	s.lck.Lock()
+	for {
+		for len(s.ready) == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			break
+		}
+
+		q := s.ready[0]
+		s.ready[0] = nil
+		s.ready = s.ready[1:]
+
+		err := s.serve(q, slice)
+		if err != nil {
+			s.remove(q.conn)
+			if s.OnError != nil {
+				s.lck.Unlock()
+				s.OnError(q.conn, err)
+				s.lck.Lock()
+			}
+			continue
+		}
+
+		// back to the end of the line if the slice expired.
+		if len(q.msgs) > 0 && !q.removed && !s.stopped {
+			s.ready = append(s.ready, q)
+			s.cond.Signal()
+		} else {
+			q.scheduled = false
+			// the drained queues are created again when enqueuing.
+			if s.queues[q.conn] == q && len(q.msgs) == 0 {
+				delete(s.queues, q.conn)
+			}
+		}
+	}
+	s.lck.Unlock()
+}
+
+// serve writes the messages of q until the time slice expires.
+//
+// serve must be called holding s.lck, which is released while writing.
+func (s *Scheduler) serve(q *schedQueue, slice time.Duration) (err error) {
+	deadline := time.Now().Add(slice)
+	for len(q.msgs) > 0 && !q.removed {
+		msg := q.msgs[0]
+		q.msgs[0] = schedMessage{}
+		q.msgs = q.msgs[1:]
+
+		s.lck.Unlock()
+		_, err = q.conn.WriteMessage(msg.mode, msg.b)
+		s.lck.Lock()
+
+		if err != nil || s.stopped || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	return err
+}
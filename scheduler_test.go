@@ -0,0 +1,118 @@
+package fastws
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// blockedConn returns a Conn whose peer never reads.
+func blockedConn() (*Conn, net.Conn) {
+	c1, c2 := net.Pipe()
+	conn := acquireConn(c1)
+	conn.server = true
+	return conn, c2
+}
+
+func TestSchedulerSlowPeer(t *testing.T) {
+	slowServer, slowPeer := blockedConn()
+	fastServer, fastClient := pipeConns()
+	defer fastClient.mustClose(false)
+	defer fastServer.mustClose(false)
+
+	s := &Scheduler{
+		Workers: 2,
+	}
+	s.Start()
+	defer s.Stop()
+
+	msg := []byte("Hello")
+	for i := 0; i < 10; i++ {
+		// nobody reads slowPeer, so the writes to slowServer block.
+		if err := s.Enqueue(slowServer, ModeText, msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Enqueue(fastServer, ModeText, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b []byte
+	var err error
+	for i := 0; i < 10; i++ {
+		_, b, err = fastClient.ReadMessage(b[:0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != string(msg) {
+			t.Fatalf("Unexpected message: %s <> %s", b, msg)
+		}
+	}
+	if n := s.Len(slowServer); n == 0 {
+		t.Fatal("The slow peer queue must not be empty")
+	}
+
+	// unblocks the slow peer writer
+	slowPeer.Close()
+	slowServer.mustClose(false)
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	server, peer := blockedConn()
+	defer server.mustClose(false)
+
+	s := &Scheduler{
+		Workers:   1,
+		QueueSize: 1,
+	}
+	if err := s.Enqueue(server, ModeText, nil); err != ErrSchedulerStopped {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrSchedulerStopped)
+	}
+
+	s.Start()
+	defer s.Stop()
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = s.Enqueue(server, ModeText, []byte("Hello"))
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err != ErrQueueFull {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrQueueFull)
+	}
+
+	// unblocks the writer before stopping the scheduler
+	peer.Close()
+}
+
+func TestSchedulerDrainedQueues(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	s := &Scheduler{
+		Workers: 1,
+	}
+	s.Start()
+	defer s.Stop()
+
+	if err := s.Enqueue(server, ModeText, []byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.ReadMessage(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		s.lck.Lock()
+		n := len(s.queues)
+		s.lck.Unlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Drained queue not deleted: %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,138 @@
+package fastws
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// Server is an event based alternative to writing the handlers of an Upgrader.
+//
+// The Server reads the messages of the upgraded connections calling
+// the handlers set, and the messages sent are written by a goroutine
+// per connection (see Hub), so the applications implement neither
+// the read loops nor the write pumps:
+//
+//	s := fastws.NewServer()
+//	s.HandleMessage(func(conn *fastws.Conn, b []byte) {
+//		s.Broadcast(fastws.ModeText, b)
+//	})
+//	fasthttp.ListenAndServe(":8080", s.Upgrade)
+//
+// The handlers must be set before upgrading connections.
+type Server struct {
+	// Upgrader holds the settings of the upgrades.
+	//
+	// Handler and OnDisconnect are set by NewServer and must not be replaced.
+	Upgrader Upgrader
+
+	// Hub holds the connections of the Server, which can join its rooms.
+	Hub Hub
+
+	onConnect    func(conn *Conn)
+	onMessage    func(conn *Conn, b []byte)
+	onBinary     func(conn *Conn, b []byte)
+	onPong       func(conn *Conn)
+	onDisconnect func(conn *Conn, err error)
+}
+
+// NewServer returns a Server ready to upgrade connections.
+func NewServer() *Server {
+	s := &Server{}
+	s.Upgrader.Handler = s.serve
+	s.Upgrader.OnDisconnect = s.disconnected
+	return s
+}
+
+// HandleConnect sets the function called when a connection is upgraded,
+// before reading from it.
+func (s *Server) HandleConnect(f func(conn *Conn)) {
+	s.onConnect = f
+}
+
+// HandleMessage sets the function called for the text messages,
+// and for the binary ones unless HandleMessageBinary is set.
+//
+// b must not be retained after returning.
+func (s *Server) HandleMessage(f func(conn *Conn, b []byte)) {
+	s.onMessage = f
+}
+
+// HandleMessageBinary sets the function called for the binary messages.
+//
+// b must not be retained after returning.
+func (s *Server) HandleMessageBinary(f func(conn *Conn, b []byte)) {
+	s.onBinary = f
+}
+
+// HandlePong sets the function called when a PONG is received.
+func (s *Server) HandlePong(f func(conn *Conn)) {
+	s.onPong = f
+}
+
+// HandleDisconnect sets the function called when the connection
+// has been closed, with the error as Upgrader.OnDisconnect.
+func (s *Server) HandleDisconnect(f func(conn *Conn, err error)) {
+	s.onDisconnect = f
+}
+
+// Upgrade upgrades the connection of ctx. It's the fasthttp.RequestHandler of s.
+func (s *Server) Upgrade(ctx *fasthttp.RequestCtx) {
+	s.Upgrader.Upgrade(ctx)
+}
+
+// Send queues b to be written to conn using mode. b is copied.
+//
+// Send returns EOF if conn is not served by s, and ErrQueueFull
+// if the queue of conn is full, closing conn as the Hub does.
+func (s *Server) Send(conn *Conn, mode Mode, b []byte) error {
+	return s.Hub.send(conn, NewPreparedMessage(mode, b))
+}
+
+// Broadcast queues b to be written to every connection using mode. b is copied.
+func (s *Server) Broadcast(mode Mode, b []byte) {
+	s.Hub.Broadcast(mode, append([]byte(nil), b...))
+}
+
+// Len returns the number of connections served.
+func (s *Server) Len() int {
+	return s.Hub.Len()
+}
+
+func (s *Server) serve(conn *Conn) {
+	s.Hub.Register(conn)
+	defer s.Hub.Unregister(conn)
+
+	if s.onPong != nil {
+		conn.SetPongHandler(func(payload []byte) error {
+			s.onPong(conn)
+			return nil
+		})
+	}
+	if s.onConnect != nil {
+		s.onConnect(conn)
+	}
+
+	var (
+		mode Mode
+		b    []byte
+		err  error
+	)
+	for {
+		mode, b, err = conn.ReadMessage(b[:0])
+		if err != nil {
+			return
+		}
+
+		switch {
+		case mode == ModeBinary && s.onBinary != nil:
+			s.onBinary(conn, b)
+		case s.onMessage != nil:
+			s.onMessage(conn, b)
+		}
+	}
+}
+
+func (s *Server) disconnected(conn *Conn, err error) {
+	if s.onDisconnect != nil {
+		s.onDisconnect(conn, err)
+	}
+}
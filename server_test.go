@@ -0,0 +1,55 @@
+package fastws
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestServer(t *testing.T) {
+	connected := make(chan *Conn, 1)
+	disconnected := make(chan *Conn, 1)
+
+	s := NewServer()
+	s.HandleConnect(func(conn *Conn) {
+		connected <- conn
+	})
+	s.HandleMessage(func(conn *Conn, b []byte) {
+		s.Send(conn, ModeText, append(b, " world"...))
+	})
+	s.HandleDisconnect(func(conn *Conn, err error) {
+		disconnected <- conn
+	})
+
+	ln := fasthttputil.NewInmemoryListener()
+	hs := fasthttp.Server{
+		Handler: s.Upgrade,
+	}
+	go hs.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+
+	sconn := <-connected
+	if n := s.Len(); n != 1 {
+		t.Fatalf("Unexpected length: %d <> 1", n)
+	}
+
+	conn.WriteString("Hello")
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello world" {
+		t.Fatalf("Unexpected message: %q", b)
+	}
+
+	conn.Close()
+	if c := <-disconnected; c != sconn {
+		t.Fatalf("Unexpected connection: %p <> %p", c, sconn)
+	}
+	if n := s.Len(); n != 0 {
+		t.Fatalf("Unexpected length: %d <> 0", n)
+	}
+}
@@ -1,7 +1,10 @@
 package fastws
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
@@ -160,7 +163,7 @@ func benchmarkFastServer(b *testing.B, clients, count int) {
 			for {
 				_, bf, err = conn.ReadMessage(bf)
 				if err != nil {
-					if err == io.EOF {
+					if errors.Is(err, io.EOF) {
 						break
 					}
 					panic(err)
@@ -431,3 +434,195 @@ func Benchmark10000GobwasMsgsPerConn(b *testing.B) {
 func Benchmark100000GobwasMsgsPerConn(b *testing.B) {
 	benchmarkGobwasServer(b, runtime.NumCPU(), 100000)
 }
+
+// loopConn is a transport reading the same client frame forever
+// and discarding the writes, so the echo loops only measure the websocket code.
+type loopConn struct {
+	net.TCPConn
+	frame  []byte
+	pos    int
+	closed uint32
+}
+
+func newLoopConn() *loopConn {
+	fr := AcquireFrame()
+	defer ReleaseFrame(fr)
+
+	fr.SetFin()
+	fr.SetText()
+	fr.SetPayload([]byte("make fasthttp great again with HTTP/2"))
+	fr.Mask()
+
+	var bf bytes.Buffer
+	fr.WriteTo(&bf)
+
+	return &loopConn{
+		frame: bf.Bytes(),
+	}
+}
+
+func (c *loopConn) Read(b []byte) (int, error) {
+	if atomic.LoadUint32(&c.closed) == 1 {
+		return 0, io.EOF
+	}
+	nn := 0
+	for len(b) > 0 {
+		n := copy(b, c.frame[c.pos:])
+		b = b[n:]
+		nn += n
+		c.pos = (c.pos + n) % len(c.frame)
+	}
+	return nn, nil
+}
+
+func (c *loopConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *loopConn) RemoteAddr() net.Addr {
+	return &fakeAddr
+}
+
+func (c *loopConn) Close() error {
+	atomic.StoreUint32(&c.closed, 1)
+	return nil
+}
+
+func (c *loopConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *loopConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *loopConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// hijackWriter is the http.ResponseWriter used to upgrade loopConns using gorilla.
+type hijackWriter struct {
+	http.ResponseWriter
+	c net.Conn
+}
+
+func (w *hijackWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.c, bufio.NewReadWriter(bufio.NewReader(w.c), bufio.NewWriter(w.c)), nil
+}
+
+func newFastEchoConn() *Conn {
+	conn := acquireConn(newLoopConn())
+	conn.server = true
+	return conn
+}
+
+// closeFastEchoConn closes conn unblocking the readLoop,
+// which waits for the frames queued to be read.
+func closeFastEchoConn(conn *Conn) {
+	go func() {
+		for fr := range conn.framer {
+			ReleaseFrame(fr)
+		}
+	}()
+	conn.mustClose(false)
+}
+
+// The echo hot path (read a message and write it back) must not allocate.
+func TestEchoAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+
+	conn := newFastEchoConn()
+	defer closeFastEchoConn(conn)
+
+	var b []byte
+	n := testing.AllocsPerRun(10000, func() {
+		var (
+			mode Mode
+			err  error
+		)
+		mode, b, err = conn.ReadMessage(b[:0])
+		if err == nil {
+			_, err = conn.WriteMessage(mode, b)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if n != 0 {
+		t.Fatalf("Unexpected allocations echoing messages: %v", n)
+	}
+
+	n = testing.AllocsPerRun(10000, func() {
+		fr, err := conn.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr.Unmask()
+		_, err = conn.WriteFrame(fr)
+		ReleaseFrame(fr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if n != 0 {
+		t.Fatalf("Unexpected allocations echoing frames: %v", n)
+	}
+}
+
+func BenchmarkFastEcho(b *testing.B) {
+	conn := newFastEchoConn()
+	defer closeFastEchoConn(conn)
+
+	b.ReportAllocs()
+	var bf []byte
+	for i := 0; i < b.N; i++ {
+		mode, msg, err := conn.ReadMessage(bf[:0])
+		if err != nil {
+			b.Fatal(err)
+		}
+		bf = msg
+		conn.WriteMessage(mode, msg)
+	}
+}
+
+func BenchmarkGorillaEcho(b *testing.B) {
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", string(makeRandKey(nil)))
+
+	c, err := upgrader.Upgrade(&hijackWriter{c: newLoopConn()}, req, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		mt, msg, err := c.ReadMessage()
+		if err != nil {
+			b.Fatal(err)
+		}
+		c.WriteMessage(mt, msg)
+	}
+}
+
+func BenchmarkGobwasEcho(b *testing.B) {
+	c := newLoopConn()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg, op, err := wsutil.ReadClientData(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		wsutil.WriteServerMessage(c, op, msg)
+	}
+}
@@ -0,0 +1,170 @@
+package fastws
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultSessionTTL is the default time a session token is valid.
+const DefaultSessionTTL = time.Hour * 24
+
+// The user value keys holding the session minted when upgrading.
+const (
+	// SessionKey is the user value key of the *Session.
+	SessionKey = "fastws.session"
+	// SessionTokenKey is the user value key of the session token (string).
+	SessionTokenKey = "fastws.session_token"
+)
+
+// IdentityKey is the user value key of the client identity (string)
+// of the connections upgraded (see Upgrader.Identity).
+const IdentityKey = "fastws.identity"
+
+var (
+	// ErrInvalidSessionToken is returned when verifying a malformed
+	// session token or a token not signed using the issuer key.
+	ErrInvalidSessionToken = errors.New("invalid session token")
+	// ErrSessionExpired is returned when verifying an expired session token.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrNoSessionKey is returned when issuing or verifying tokens
+	// using a SessionIssuer without Key, as anyone could sign them.
+	ErrNoSessionKey = errors.New("session issuer has no key")
+)
+
+var tokenEncoding = b64.RawURLEncoding
+
+// Session is the session identified by a session token.
+type Session struct {
+	// ID identifies the connection the session was minted for.
+	ID string
+	// Identity is the identity of the client (user, device...). Can be empty.
+	Identity string
+	// Expires is the time the session expires.
+	Expires time.Time
+}
+
+// SessionIssuer mints signed session tokens when upgrading connections,
+// as the foundation for resuming sessions and addressing connections.
+//
+// The tokens are signed using HMAC-SHA256, so they can be verified
+// by any server sharing the key without storing them.
+type SessionIssuer struct {
+	// Key is the key signing the tokens. It can't be empty.
+	Key []byte
+
+	// TTL is the time the tokens are valid.
+	//
+	// By default TTL is DefaultSessionTTL.
+	TTL time.Duration
+
+	// Identity returns the identity of the client upgrading the connection.
+	// The UpgradeHandler is called before, so it can be taken from the user values.
+	//
+	// If nil the identity is empty.
+	Identity func(ctx *fasthttp.RequestCtx) string
+
+	// Header, if not empty, is the response header the token is returned in.
+	Header string
+
+	// SendMessage sends the token as the first text message of the connection.
+	SendMessage bool
+}
+
+// Issue mints a token for a new session of identity.
+func (si *SessionIssuer) Issue(identity string) (string, *Session, error) {
+	if len(si.Key) == 0 {
+		return "", nil, ErrNoSessionKey
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	ttl := si.TTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	s := &Session{
+		ID:       hex.EncodeToString(id),
+		Identity: identity,
+		Expires:  time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	// payload: expiry (8 bytes) + id (16 bytes) + identity
+	payload := make([]byte, 8, 8+len(id)+len(identity))
+	binary.BigEndian.PutUint64(payload, uint64(s.Expires.Unix()))
+	payload = append(payload, id...)
+	payload = append(payload, identity...)
+
+	return tokenEncoding.EncodeToString(payload) + "." +
+		tokenEncoding.EncodeToString(si.sign(payload)), s, nil
+}
+
+// Verify returns the session identified by token.
+//
+// The error is ErrInvalidSessionToken if token is malformed or
+// not signed using Key, ErrSessionExpired if token has expired
+// and ErrNoSessionKey if Key is empty.
+func (si *SessionIssuer) Verify(token string) (*Session, error) {
+	if len(si.Key) == 0 {
+		return nil, ErrNoSessionKey
+	}
+
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrInvalidSessionToken
+	}
+
+	payload, err := tokenEncoding.DecodeString(token[:i])
+	if err != nil || len(payload) < 24 {
+		return nil, ErrInvalidSessionToken
+	}
+	sig, err := tokenEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, si.sign(payload)) {
+		return nil, ErrInvalidSessionToken
+	}
+
+	s := &Session{
+		ID:       hex.EncodeToString(payload[8:24]),
+		Identity: string(payload[24:]),
+		Expires:  time.Unix(int64(binary.BigEndian.Uint64(payload)), 0),
+	}
+	if time.Now().After(s.Expires) {
+		return s, ErrSessionExpired
+	}
+
+	return s, nil
+}
+
+func (si *SessionIssuer) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, si.Key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// issue mints the session of the connection being upgraded by ctx,
+// setting the response header if needed.
+func (si *SessionIssuer) issue(ctx *fasthttp.RequestCtx) (string, *Session, error) {
+	var identity string
+	if si.Identity != nil {
+		identity = si.Identity(ctx)
+	}
+
+	token, s, err := si.Issue(identity)
+	if err == nil && si.Header != "" {
+		ctx.Response.Header.Set(si.Header, token)
+	}
+
+	return token, s, err
+}
@@ -0,0 +1,119 @@
+package fastws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestSessionIssuer(t *testing.T) {
+	si := &SessionIssuer{
+		Key: []byte("secret"),
+	}
+
+	token, s, err := si.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ID) != 32 || s.Identity != "alice" || time.Until(s.Expires) < DefaultSessionTTL-time.Minute {
+		t.Fatalf("Unexpected session: %+v", s)
+	}
+
+	vs, err := si.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *vs != *s {
+		t.Fatalf("Unexpected session: %+v <> %+v", vs, s)
+	}
+
+	other := &SessionIssuer{
+		Key: []byte("other"),
+	}
+	for _, token := range []string{"", "abc", token[:len(token)-2], token + "A"} {
+		if _, err := si.Verify(token); err != ErrInvalidSessionToken {
+			t.Fatalf("Unexpected error verifying %q: %v", token, err)
+		}
+	}
+	if _, err := other.Verify(token); err != ErrInvalidSessionToken {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrInvalidSessionToken)
+	}
+
+	si.TTL = time.Nanosecond
+	token, _, _ = si.Issue("")
+	if _, err := si.Verify(token); err != ErrSessionExpired {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrSessionExpired)
+	}
+}
+
+func TestSessionIssuerNoKey(t *testing.T) {
+	si := &SessionIssuer{
+		Key: []byte("secret"),
+	}
+	token, _, err := si.Issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si.Key = nil
+	if _, _, err := si.Issue("alice"); err != ErrNoSessionKey {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrNoSessionKey)
+	}
+	if _, err := si.Verify(token); err != ErrNoSessionKey {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrNoSessionKey)
+	}
+}
+
+func TestUpgraderSessions(t *testing.T) {
+	si := &SessionIssuer{
+		Key: []byte("secret"),
+		Identity: func(ctx *fasthttp.RequestCtx) string {
+			return ctx.UserValue("user").(string)
+		},
+		Header:      "X-Session-Token",
+		SendMessage: true,
+	}
+	sessions := make(chan *Session, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		UpgradeHandler: func(ctx *fasthttp.RequestCtx) bool {
+			ctx.SetUserValue("user", "bob")
+			return true
+		},
+		Handler: func(conn *Conn) {
+			s, _ := si.Verify(conn.UserValue(SessionTokenKey).(string))
+			if s == nil || *s != *conn.UserValue(SessionKey).(*Session) {
+				s = nil
+			}
+			sessions <- s
+			conn.ReadMessage(nil)
+		},
+		Sessions: si,
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := si.Verify(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Identity != "bob" {
+		t.Fatalf("Unexpected identity: %s <> bob", session.Identity)
+	}
+	if s := <-sessions; s == nil || *s != *session {
+		t.Fatalf("Unexpected session: %+v <> %+v", s, session)
+	}
+}
@@ -0,0 +1,268 @@
+package fastws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ShutdownReport represents how the connections were closed by Upgrader.Shutdown.
+type ShutdownReport struct {
+	// Conns is the number of connections open when shutting down.
+	Conns int
+	// Graceful is the number of connections closed with a close handshake,
+	// or closed by their handlers meanwhile.
+	Graceful int
+	// Forced is the number of connections closed without the peer replying
+	// to the close frame, because the peer didn't reply in time
+	// or the context was done first.
+	Forced int
+	// Errors counts the errors closing the connections by type (as printed by %T).
+	Errors map[string]int
+	// Duration is the time spent shutting down.
+	Duration time.Duration
+}
+
+// upgradedConn is a connection tracked by the Upgrader.
+type upgradedConn struct {
+	conn *Conn
+	// closing is set when Shutdown (or a duplicate connection) starts
+	// closing the connection, and done is closed when it's finished.
+	closing bool
+	done    chan struct{}
+}
+
+// Shutdown closes the connections upgraded by upgr, sending a close frame
+// (StatusGoAway) to every peer and waiting for the replies.
+// The upgrades requested after calling Shutdown are refused
+// with 503 Service Unavailable, so upgr must not be reused.
+//
+// When ctx is done the remaining connections are closed without waiting
+// for the close handshake and ctx.Err() is returned with the report.
+//
+// The handlers keep running until they notice the connection is closed,
+// so they should read from the connection (see Conn.ReadMessage).
+func (upgr *Upgrader) Shutdown(ctx context.Context) (*ShutdownReport, error) {
+	start := time.Now()
+
+	upgr.lck.Lock()
+	upgr.shutdown = true
+	ucs := make([]*upgradedConn, 0, len(upgr.conns))
+	for _, uc := range upgr.conns {
+		if uc.closing {
+			// already closed as a duplicate.
+			continue
+		}
+		uc.closing = true
+		ucs = append(ucs, uc)
+	}
+	upgr.lck.Unlock()
+
+	report := &ShutdownReport{
+		Conns:  len(ucs),
+		Errors: make(map[string]int),
+	}
+
+	var (
+		lck   sync.Mutex
+		wg    sync.WaitGroup
+		abort = make(chan struct{})
+	)
+	for _, uc := range ucs {
+		wg.Add(1)
+		go func(uc *upgradedConn) {
+			defer wg.Done()
+			defer close(uc.done)
+
+			replied, errs := closeGoingAway(uc.conn, abort)
+
+			lck.Lock()
+			if replied {
+				report.Graceful++
+			} else {
+				report.Forced++
+			}
+			for _, err := range errs {
+				report.Errors[errorType(err)]++
+			}
+			lck.Unlock()
+		}(uc)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		close(abort)
+		<-done
+	}
+	report.Duration = time.Since(start)
+
+	return report, err
+}
+
+// closeGoingAway closes conn with StatusGoAway, waiting for the peer to reply
+// until abort is closed. It returns whether the connection was closed
+// gracefully and the errors found.
+func closeGoingAway(conn *Conn, abort <-chan struct{}) (replied bool, errs []error) {
+	if conn.isClosed() {
+		return true, nil
+	}
+
+	if err := conn.sendClose(StatusGoAway, nil); err != nil {
+		errs = append(errs, err)
+	}
+
+	replied, err := conn.closeWait(true, abort)
+	switch {
+	case errors.Is(err, ErrConnectionClosed):
+		// closed by the handler meanwhile.
+		replied = true
+	case err != nil:
+		errs = append(errs, err)
+	}
+	if !replied {
+		var cerr *CloseError
+		replied = errors.As(conn.readError(), &cerr)
+	}
+
+	return replied, errs
+}
+
+// errorType returns the type of the innermost error wrapped by err.
+func errorType(err error) string {
+	for {
+		werr := errors.Unwrap(err)
+		if werr == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = werr
+	}
+}
+
+// track starts tracking conn of identity, adding it to the Registry
+// and taking the slot reserved by rs (if not expired).
+// It returns nil when shutting down, when MaxConns has been reached
+// or when the connection is rejected by the DuplicatePolicy.
+func (upgr *Upgrader) track(conn *Conn, identity string, rs *reservation) *upgradedConn {
+	upgr.lck.Lock()
+
+	reserved := rs != nil && !rs.released
+	rs.releaseLocked()
+	if upgr.shutdown || (!reserved && upgr.isFullLocked()) {
+		upgr.lck.Unlock()
+		return nil
+	}
+	uc := &upgradedConn{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	ok, evicted := upgr.register(uc, identity)
+	if !ok {
+		upgr.lck.Unlock()
+		return nil
+	}
+	if upgr.conns == nil {
+		upgr.conns = make(map[*Conn]*upgradedConn)
+	}
+	upgr.conns[conn] = uc
+	upgr.lck.Unlock()
+
+	for _, old := range evicted {
+		go closeDuplicate(old)
+	}
+
+	return uc
+}
+
+// untrack stops tracking the connection, waiting for Shutdown
+// to finish closing it, so it can be released.
+func (upgr *Upgrader) untrack(uc *upgradedConn) {
+	upgr.lck.Lock()
+	delete(upgr.conns, uc.conn)
+	closing := uc.closing
+	upgr.lck.Unlock()
+
+	if closing {
+		<-uc.done
+	}
+}
+
+func (upgr *Upgrader) isShutdown() bool {
+	upgr.lck.Lock()
+	defer upgr.lck.Unlock()
+
+	return upgr.shutdown
+}
+
+// isFullLocked returns whether MaxConns connections are open or reserved.
+// upgr.lck must be held.
+func (upgr *Upgrader) isFullLocked() bool {
+	return upgr.MaxConns > 0 && len(upgr.conns)+upgr.reserved >= upgr.MaxConns
+}
+
+// reservationTimeout is the time a slot is reserved
+// if the connection is not hijacked (i.e.: writing the response failed).
+const reservationTimeout = time.Second * 10
+
+// reservation is a slot of MaxConns reserved before responding the upgrade,
+// so the upgrades exceeding MaxConns are refused with 503 instead of
+// being closed once upgraded.
+type reservation struct {
+	upgr  *Upgrader
+	timer *time.Timer
+	// released is guarded by Upgrader.lck.
+	released bool
+}
+
+// reserve reserves a slot of MaxConns, returning false if full.
+// The reservation is nil if the connections are not limited.
+func (upgr *Upgrader) reserve() (*reservation, bool) {
+	if upgr.MaxConns <= 0 {
+		return nil, true
+	}
+
+	upgr.lck.Lock()
+	defer upgr.lck.Unlock()
+
+	if upgr.isFullLocked() {
+		return nil, false
+	}
+	upgr.reserved++
+
+	rs := &reservation{
+		upgr: upgr,
+	}
+	rs.timer = time.AfterFunc(reservationTimeout, rs.release)
+	return rs, true
+}
+
+// release releases the slot if not taken by the connection.
+func (rs *reservation) release() {
+	if rs == nil {
+		return
+	}
+
+	rs.upgr.lck.Lock()
+	rs.releaseLocked()
+	rs.upgr.lck.Unlock()
+}
+
+// releaseLocked releases the slot. Upgrader.lck must be held.
+func (rs *reservation) releaseLocked() {
+	if rs == nil || rs.released {
+		return
+	}
+	rs.released = true
+	rs.upgr.reserved--
+	rs.timer.Stop()
+}
@@ -0,0 +1,91 @@
+package fastws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestUpgraderShutdown(t *testing.T) {
+	connected := make(chan struct{}, 2)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		OnConnect: func(conn *Conn) {
+			connected <- struct{}{}
+		},
+		Handler: func(conn *Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(nil); err != nil {
+					return
+				}
+			}
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+		// the in-memory connections ignore the deadlines set while reading,
+		// so the forced connections are unblocked by closing them.
+		KeepHijackedConns: true,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	graceful := openConn(t, ln)
+	go func() {
+		// replying to the close frame.
+		for {
+			if _, _, err := graceful.ReadMessage(nil); err != nil {
+				return
+			}
+		}
+	}()
+	// never reads, so the close frame isn't replied.
+	stuck := openConn(t, ln)
+	defer stuck.Close()
+
+	<-connected
+	<-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	report, err := upgr.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v <> %v", err, context.DeadlineExceeded)
+	}
+	if report.Conns != 2 || report.Graceful != 1 || report.Forced != 1 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if report.Duration < time.Millisecond*200 {
+		t.Fatalf("Unexpected duration: %s", report.Duration)
+	}
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = Client(c, "http://localhost/")
+	var uerr *UpgradeError
+	if !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUpgraderShutdownIdle(t *testing.T) {
+	var upgr Upgrader
+
+	report, err := upgr.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Conns != 0 || len(report.Errors) != 0 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+}
@@ -0,0 +1,80 @@
+package fastws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// connStats holds the counters updated atomically by Conn.
+type connStats struct {
+	writeLocks         uint64
+	writeLockContended uint64
+	writeLockWait      int64
+	messagesRead       uint64
+	bytesRead          uint64
+	messagesWritten    uint64
+	bytesWritten       uint64
+	// lastRead is the time (in Unix nanoseconds) the last frame was received.
+	lastRead int64
+
+	// buffer high-water marks (see BufferStats).
+	maxReadBuffered uint64
+	maxFrameQueue   uint64
+	maxSendQueue    uint64
+	maxFrameRead    uint64
+	maxFrameWritten uint64
+	maxMessageRead  uint64
+}
+
+// ConnStats represents the statistics of a connection.
+type ConnStats struct {
+	// WriteLocks is the number of times the write lock has been acquired.
+	WriteLocks uint64
+	// WriteLocksContended is the number of times a writer had to wait
+	// for another writer to release the write lock.
+	WriteLocksContended uint64
+	// WriteLockWait is the total time spent waiting for the write lock.
+	WriteLockWait time.Duration
+
+	// MessagesRead and BytesRead are the number of messages read
+	// and the size of their payloads, as reported to the Metrics.
+	MessagesRead uint64
+	BytesRead    uint64
+	// MessagesWritten and BytesWritten are the number of messages written
+	// and the size of their payloads, as reported to the Metrics.
+	MessagesWritten uint64
+	BytesWritten    uint64
+}
+
+// Stats returns the connection statistics.
+func (conn *Conn) Stats() ConnStats {
+	return ConnStats{
+		WriteLocks:          atomic.LoadUint64(&conn.stats.writeLocks),
+		WriteLocksContended: atomic.LoadUint64(&conn.stats.writeLockContended),
+		WriteLockWait:       time.Duration(atomic.LoadInt64(&conn.stats.writeLockWait)),
+		MessagesRead:        atomic.LoadUint64(&conn.stats.messagesRead),
+		BytesRead:           atomic.LoadUint64(&conn.stats.bytesRead),
+		MessagesWritten:     atomic.LoadUint64(&conn.stats.messagesWritten),
+		BytesWritten:        atomic.LoadUint64(&conn.stats.bytesWritten),
+	}
+}
+
+// SetSingleWriter sets whether the data messages of conn are written
+// by a single goroutine.
+//
+// When enabled the data frames skip the message lock and the write lock
+// ordering and accounting (see Stats), as the write lock is only contended
+// by the control frames (i.e.: the PINGs of the keepalive or the close frames
+// of a Closer) written by other goroutines, which are still serialized.
+// The data messages must not be written by other goroutines (i.e.: a Hub).
+func (conn *Conn) SetSingleWriter(single bool) {
+	var v uint32
+	if single {
+		v = 1
+	}
+	atomic.StoreUint32(&conn.singleWriter, v)
+}
+
+func (conn *Conn) isSingleWriter() bool {
+	return atomic.LoadUint32(&conn.singleWriter) == 1
+}
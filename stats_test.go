@@ -0,0 +1,69 @@
+package fastws
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestConnStatsWriteLocks(t *testing.T) {
+	c1, c2 := net.Pipe()
+	go io.Copy(ioutil.Discard, c2)
+	defer c2.Close()
+
+	conn := acquireConn(c1)
+	conn.server = true
+	defer conn.mustClose(false)
+
+	for i := 0; i < 10; i++ {
+		if _, err := conn.WriteString("Hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.Stats().WriteLocks; n != 10 {
+		t.Fatalf("Unexpected write locks: %d <> 10", n)
+	}
+
+	conn.SetSingleWriter(true)
+	for i := 0; i < 10; i++ {
+		if _, err := conn.WriteString("Hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.Stats().WriteLocks; n != 10 {
+		t.Fatalf("Unexpected write locks in single writer mode: %d <> 10", n)
+	}
+}
+
+func TestSingleWriterControlFrames(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	server.SetSingleWriter(true)
+
+	const n = 100
+	go func() {
+		// the PINGs are written by other goroutine meanwhile.
+		for i := 0; i < n; i++ {
+			server.SendCode(CodePing, 0, []byte("ping"))
+		}
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			server.WriteString("Hello")
+		}
+	}()
+
+	client.Strict = true
+	for i := 0; i < n; i++ {
+		_, b, err := client.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "Hello" {
+			t.Fatalf("Unexpected message: %q <> Hello", b)
+		}
+	}
+}
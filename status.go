@@ -0,0 +1,64 @@
+package fastws
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInvalidStatusCode is returned when registering a status code
+// out of the range reserved for the applications.
+var ErrInvalidStatusCode = errors.New("invalid status code")
+
+const (
+	// MinApplicationStatus is the first status code reserved for the applications.
+	MinApplicationStatus StatusCode = 4000
+	// MaxApplicationStatus is the last status code reserved for the applications.
+	MaxApplicationStatus StatusCode = 4999
+)
+
+var (
+	statusLck   sync.RWMutex
+	statusNames = make(map[StatusCode]string)
+)
+
+// RegisterStatusCode registers the application-defined status code,
+// so StatusCode.String returns name.
+//
+// status must be between MinApplicationStatus and MaxApplicationStatus.
+// Registering a code again replaces its name.
+func RegisterStatusCode(status StatusCode, name string) error {
+	if status < MinApplicationStatus || status > MaxApplicationStatus {
+		return ErrInvalidStatusCode
+	}
+
+	statusLck.Lock()
+	statusNames[status] = name
+	statusLck.Unlock()
+
+	return nil
+}
+
+func statusName(status StatusCode) string {
+	statusLck.RLock()
+	name := statusNames[status]
+	statusLck.RUnlock()
+
+	return name
+}
+
+// IsValidReceivedCloseCode returns whether status can be received in a close frame.
+//
+// The codes reserved for reporting (1005, 1006 and 1015) and the ones
+// not defined by the RFC nor registered by IANA are invalid.
+// The codes from 3000 to 4999 are always valid.
+//
+// https://tools.ietf.org/html/rfc6455#section-7.4
+func (status StatusCode) IsValidReceivedCloseCode() bool {
+	switch {
+	case status >= StatusNone && status <= StatusNotAcceptable,
+		status >= StatusNotConsistent && status <= StatusBadGateway,
+		status >= 3000 && status <= MaxApplicationStatus:
+		return true
+	}
+	return false
+}
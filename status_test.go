@@ -0,0 +1,53 @@
+package fastws
+
+import (
+	"testing"
+)
+
+func TestStatusCodeString(t *testing.T) {
+	for _, tc := range []struct {
+		status StatusCode
+		s      string
+	}{
+		{StatusNone, "None"},
+		{StatusAbnormalClosure, "AbnormalClosure"},
+		{StatusTryAgainLater, "TryAgainLater"},
+		{4321, "4321"},
+	} {
+		if s := tc.status.String(); s != tc.s {
+			t.Fatalf("Unexpected string: %s <> %s", s, tc.s)
+		}
+	}
+}
+
+func TestRegisterStatusCode(t *testing.T) {
+	if err := RegisterStatusCode(4001, "Banned"); err != nil {
+		t.Fatal(err)
+	}
+	if s := StatusCode(4001).String(); s != "Banned" {
+		t.Fatalf("Unexpected string: %s <> Banned", s)
+	}
+
+	for _, status := range []StatusCode{StatusNone, 3999, 5000} {
+		if err := RegisterStatusCode(status, "Invalid"); err != ErrInvalidStatusCode {
+			t.Fatalf("Unexpected error registering %d: %v", status, err)
+		}
+	}
+}
+
+func TestIsValidReceivedCloseCode(t *testing.T) {
+	for _, status := range []StatusCode{
+		1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 1012, 1013, 1014, 3000, 3999, 4000, 4999,
+	} {
+		if !status.IsValidReceivedCloseCode() {
+			t.Fatalf("%d must be valid", status)
+		}
+	}
+	for _, status := range []StatusCode{
+		0, 999, 1004, 1005, 1006, 1015, 1016, 1100, 2000, 2999, 5000,
+	} {
+		if status.IsValidReceivedCloseCode() {
+			t.Fatalf("%d must be invalid", status)
+		}
+	}
+}
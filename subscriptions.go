@@ -0,0 +1,111 @@
+package fastws
+
+import (
+	"sync"
+)
+
+// Subscriptions records the subscriptions made over a ReconnectingConn
+// to replay them after every reconnect.
+//
+// The subscribe and unsubscribe messages are built by the user-defined
+// Subscribe and Unsubscribe functions, so any protocol can be used.
+// Replay must be set as (or called by) the OnConnect hook of the connection:
+//
+//	subs := &fastws.Subscriptions{
+//		Conn:      rc,
+//		Subscribe: subscribeMessage,
+//	}
+//	rc.OnConnect = subs.Replay
+//
+// A subscription made while reconnecting can be sent twice.
+type Subscriptions struct {
+	// Conn is the connection the subscriptions are made over.
+	Conn *ReconnectingConn
+
+	// Subscribe returns the message subscribing to topic.
+	Subscribe func(topic string) (Mode, []byte, error)
+
+	// Unsubscribe returns the message unsubscribing from topic.
+	//
+	// If nil no message is sent when unsubscribing.
+	Unsubscribe func(topic string) (Mode, []byte, error)
+
+	lck    sync.Mutex
+	topics []string
+}
+
+// Add subscribes to topic, recording it to be replayed after reconnecting.
+//
+// The message is sent if connected, otherwise it's sent by Replay.
+// The topic stays recorded if sending fails, so it's sent after reconnecting.
+// Adding a topic already subscribed does nothing.
+func (s *Subscriptions) Add(topic string) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	if s.index(topic) >= 0 {
+		return nil
+	}
+	s.topics = append(s.topics, topic)
+
+	return s.send(s.Conn.current(), s.Subscribe, topic)
+}
+
+// Remove unsubscribes from topic, which won't be replayed anymore.
+func (s *Subscriptions) Remove(topic string) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	i := s.index(topic)
+	if i < 0 {
+		return nil
+	}
+	s.topics = append(s.topics[:i], s.topics[i+1:]...)
+
+	if s.Unsubscribe == nil {
+		return nil
+	}
+	return s.send(s.Conn.current(), s.Unsubscribe, topic)
+}
+
+// Topics returns the topics subscribed in the order they were added.
+func (s *Subscriptions) Topics() []string {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	return append([]string(nil), s.topics...)
+}
+
+// Replay sends the subscribe messages of all the topics over conn.
+func (s *Subscriptions) Replay(conn *Conn) error {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	for _, topic := range s.topics {
+		if err := s.send(conn, s.Subscribe, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the message built by fn for topic over conn, if not nil.
+func (s *Subscriptions) send(conn *Conn, fn func(topic string) (Mode, []byte, error), topic string) error {
+	if conn == nil {
+		return nil
+	}
+	mode, b, err := fn(topic)
+	if err == nil {
+		_, err = conn.WriteMessage(mode, b)
+	}
+	return err
+}
+
+func (s *Subscriptions) index(topic string) int {
+	for i := range s.topics {
+		if s.topics[i] == topic {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,51 @@
+package fastws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptions(t *testing.T) {
+	ln := configureEchoServer()
+	defer ln.Close()
+
+	rc := &ReconnectingConn{
+		Dial:       dialInmemory(ln),
+		MinBackoff: time.Millisecond,
+	}
+	defer rc.Close()
+
+	subs := &Subscriptions{
+		Conn: rc,
+		Subscribe: func(topic string) (Mode, []byte, error) {
+			return ModeText, []byte("sub " + topic), nil
+		},
+		Unsubscribe: func(topic string) (Mode, []byte, error) {
+			return ModeText, []byte("unsub " + topic), nil
+		},
+	}
+	rc.OnConnect = subs.Replay
+
+	// not connected yet, so the subscriptions are sent when connecting.
+	subs.Add("a")
+	subs.Add("b")
+	subs.Add("a")
+
+	expectString(t, rc, "ack:sub a")
+	expectString(t, rc, "ack:sub b")
+
+	subs.Add("c")
+	expectString(t, rc, "ack:sub c")
+	subs.Remove("b")
+	expectString(t, rc, "ack:unsub b")
+
+	if topics := subs.Topics(); len(topics) != 2 || topics[0] != "a" || topics[1] != "c" {
+		t.Fatalf("Unexpected topics: %v", topics)
+	}
+
+	// the subscriptions are replayed after reconnecting.
+	rc.WriteMessage(ModeText, []byte("drop"))
+
+	expectString(t, rc, "ack:sub a")
+	expectString(t, rc, "ack:sub c")
+}
@@ -0,0 +1,180 @@
+package fastws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Direction is the direction of the messages mirrored by Conn.Tap.
+type Direction uint32
+
+const (
+	// DirectionIn selects the messages received.
+	DirectionIn Direction = 1 << iota
+	// DirectionOut selects the messages sent.
+	DirectionOut
+	// DirectionBoth selects the messages received and sent.
+	DirectionBoth = DirectionIn | DirectionOut
+)
+
+func (dir Direction) String() string {
+	switch dir {
+	case DirectionIn:
+		return "in"
+	case DirectionOut:
+		return "out"
+	case DirectionBoth:
+		return "both"
+	}
+	return strconv.Itoa(int(dir))
+}
+
+var errInvalidTapRecord = errors.New("invalid tap record")
+
+// TapRecord is a message mirrored by Conn.Tap.
+//
+// The records are written as a header line holding the time (RFC 3339),
+// the direction, the mode and the payload length, followed by the payload
+// and a new line:
+//
+//	2006-01-02T15:04:05.999999999Z07:00 in text 5
+//	Hello
+type TapRecord struct {
+	Time      time.Time
+	Direction Direction
+	Mode      Mode
+	Payload   []byte
+}
+
+// WriteTo writes the record to w.
+func (r *TapRecord) WriteTo(w io.Writer) (int64, error) {
+	mode := "text"
+	if r.Mode == ModeBinary {
+		mode = "binary"
+	}
+
+	b := bytePool.Get().([]byte)
+	b = r.Time.AppendFormat(b[:0], time.RFC3339Nano)
+	b = append(b, ' ')
+	b = append(b, r.Direction.String()...)
+	b = append(b, ' ')
+	b = append(b, mode...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(len(r.Payload)), 10)
+	b = append(b, '\n')
+	b = append(b, r.Payload...)
+	b = append(b, '\n')
+
+	n, err := w.Write(b)
+	bytePool.Put(b)
+
+	return int64(n), err
+}
+
+// ReadTapRecord reads a record written by Conn.Tap from br.
+func ReadTapRecord(br *bufio.Reader) (*TapRecord, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	var (
+		r         TapRecord
+		ts        string
+		dir, mode string
+		size      int
+	)
+	if _, err = fmt.Sscanf(line, "%s %s %s %d\n", &ts, &dir, &mode, &size); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidTapRecord, err)
+	}
+	if r.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidTapRecord, err)
+	}
+
+	switch dir {
+	case "in":
+		r.Direction = DirectionIn
+	case "out":
+		r.Direction = DirectionOut
+	default:
+		return nil, fmt.Errorf("%w: direction %q", errInvalidTapRecord, dir)
+	}
+	switch mode {
+	case "text":
+		r.Mode = ModeText
+	case "binary":
+		r.Mode = ModeBinary
+	default:
+		return nil, fmt.Errorf("%w: mode %q", errInvalidTapRecord, mode)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("%w: size %d", errInvalidTapRecord, size)
+	}
+
+	r.Payload = make([]byte, size+1)
+	if _, err = io.ReadFull(br, r.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.Payload[size] != '\n' {
+		return nil, errInvalidTapRecord
+	}
+	r.Payload = r.Payload[:size]
+
+	return &r, nil
+}
+
+// Tap mirrors the payload of the messages in dir to w as TapRecords,
+// so the messages can be captured without modifying the handlers.
+//
+// The messages read using ReadMessage, ReadMessageContext, ReadFull or Read
+// and written using WriteMessage, WriteMessageContext, Write or WriteBatch are mirrored.
+// The messages read or written as a stream (NextReader, NextWriter)
+// or frame by frame are not.
+//
+// The records are written before returning the message read or writing
+// the message sent, and the errors writing to w are ignored.
+// Calling Tap again replaces the previous tap and a nil w removes it.
+func (conn *Conn) Tap(dir Direction, w io.Writer) {
+	conn.tapLck.Lock()
+	conn.tapW = w
+	if w == nil {
+		dir = 0
+	}
+	atomic.StoreUint32(&conn.tapDir, uint32(dir))
+	conn.tapLck.Unlock()
+}
+
+// tap mirrors the message b in dir if tapped,
+// reporting the messages read (see reportRead).
+func (conn *Conn) tap(dir Direction, mode Mode, b []byte) {
+	if dir == DirectionIn {
+		conn.reportRead(len(b))
+	}
+
+	if Direction(atomic.LoadUint32(&conn.tapDir))&dir == 0 {
+		return
+	}
+
+	conn.tapLck.Lock()
+	if conn.tapW != nil {
+		r := TapRecord{
+			Time:      conn.now(),
+			Direction: dir,
+			Mode:      mode,
+			Payload:   b,
+		}
+		r.WriteTo(conn.tapW)
+	}
+	conn.tapLck.Unlock()
+}
@@ -0,0 +1,59 @@
+package fastws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestConnTap(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	var bf bytes.Buffer
+	server.Tap(DirectionBoth, &bf)
+
+	go func() {
+		client.WriteString("Hello")
+		writeFragments(client, "frag", "mented")
+		client.ReadMessage(nil)
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := server.ReadMessage(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := server.WriteMessage(ModeBinary, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Tap(DirectionIn, nil)
+	if _, err := server.WriteString("untapped"); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(&bf)
+	for _, expected := range []TapRecord{
+		{Direction: DirectionIn, Mode: ModeText, Payload: []byte("Hello")},
+		{Direction: DirectionIn, Mode: ModeText, Payload: []byte("fragmented")},
+		{Direction: DirectionOut, Mode: ModeBinary, Payload: []byte{1, 2}},
+	} {
+		r, err := ReadTapRecord(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Direction != expected.Direction || r.Mode != expected.Mode || !bytes.Equal(r.Payload, expected.Payload) {
+			t.Fatalf("Unexpected record: %v %v %q <> %v %v %q",
+				r.Direction, r.Mode, r.Payload, expected.Direction, expected.Mode, expected.Payload)
+		}
+		if r.Time.IsZero() {
+			t.Fatal("Record without time")
+		}
+	}
+	if _, err := ReadTapRecord(br); err != io.EOF {
+		t.Fatalf("Unexpected error: %v <> %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,104 @@
+package fastws
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var errTLSTransport = errors.New("the connection transport is not a net.Conn")
+
+// bufferedConn is a net.Conn reading from br, so the bytes
+// already buffered by br are not lost.
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
+// StartTLS upgrades conn to a TLS connection, STARTTLS-like.
+//
+// StartTLS pauses the reading, writes ack (if not nil) as a message
+// using conn.Mode and performs the TLS handshake (as a server if conn has been
+// accepted by the server, as a client otherwise) using config.
+// The frames read before the upgrading can still be read after StartTLS returns.
+//
+// StartTLS must be called when the peer is not sending frames, i.e.:
+// the client sends a negotiation message and waits for the ack of the server.
+// The server calls StartTLS passing the ack and the client, after receiving
+// the ack, calls StartTLS as well.
+//
+// If the handshake fails the connection is closed.
+func (conn *Conn) StartTLS(config *tls.Config, ack []byte) error {
+	c, ok := conn.c.(net.Conn)
+	if !ok {
+		return errTLSTransport
+	}
+	if conn.isClosed() {
+		return errClosed
+	}
+
+	// pausing the readLoop unblocking the current read
+	atomic.StoreUint32(&conn.pausing, 1)
+	conn.c.SetReadDeadline(time.Now())
+	select {
+	case <-conn.paused:
+	case <-conn.readDone:
+		return errClosed
+	}
+	conn.c.SetReadDeadline(zeroTime)
+
+	var err error
+	if ack != nil {
+		_, err = conn.WriteMessage(conn.Mode, ack)
+	}
+
+	if err == nil {
+		conn.lockWrite()
+		err = conn.handshakeTLS(c, config)
+		conn.unlockWrite()
+	}
+
+	atomic.StoreUint32(&conn.pausing, 0)
+	conn.resume <- struct{}{}
+
+	if err != nil {
+		conn.mustClose(false)
+	}
+
+	return err
+}
+
+// handshakeTLS performs the TLS handshake over c replacing the connection transport.
+func (conn *Conn) handshakeTLS(c net.Conn, config *tls.Config) error {
+	bc := &bufferedConn{
+		Conn: c,
+		br:   conn.bf.Reader,
+	}
+
+	var tc *tls.Conn
+	if conn.server {
+		tc = tls.Server(bc, config)
+	} else {
+		tc = tls.Client(bc, config)
+	}
+
+	if conn.ReadTimeout > 0 {
+		tc.SetDeadline(time.Now().Add(conn.ReadTimeout))
+	}
+	err := tc.Handshake()
+	tc.SetDeadline(zeroTime)
+
+	conn.cLck.Lock()
+	conn.c = tc
+	conn.bf = bufio.NewReadWriter(bufio.NewReader(tc), bufio.NewWriter(tc))
+	conn.cLck.Unlock()
+
+	return err
+}
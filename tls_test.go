@@ -0,0 +1,112 @@
+package fastws
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	cert := selfSignedCert(t)
+
+	// the transport is used while StartTLS replaces it.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				server.RemoteAddr()
+				server.NetConn()
+			}
+		}
+	}()
+
+	ch := make(chan error, 1)
+	go func() {
+		_, b, err := server.ReadMessage(nil)
+		if err == nil && string(b) != "STARTTLS" {
+			t.Errorf("Unexpected message: %s <> STARTTLS", b)
+		}
+		if err == nil {
+			err = server.StartTLS(&tls.Config{
+				Certificates: []tls.Certificate{cert},
+			}, []byte("OK"))
+		}
+		if err == nil {
+			_, b, err = server.ReadMessage(b[:0])
+			if err == nil {
+				_, err = server.Write(b)
+			}
+		}
+		ch <- err
+	}()
+
+	_, err := client.WriteString("STARTTLS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "OK" {
+		t.Fatalf("Unexpected message: %s <> OK", b)
+	}
+
+	err = client.StartTLS(&tls.Config{
+		InsecureSkipVerify: true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.c.(*tls.Conn); !ok {
+		t.Fatalf("Unexpected transport: %T", client.c)
+	}
+
+	client.WriteString("Hello")
+	_, b, err = client.ReadMessage(b[:0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s <> Hello", b)
+	}
+	if err = <-ch; err != nil {
+		t.Fatal(err)
+	}
+}
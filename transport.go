@@ -0,0 +1,25 @@
+package fastws
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// transport is the stream Conn reads frames from and writes frames to.
+//
+// net.Conn satisfies transport, but in-memory pipes or multiplexed
+// sub-channels can be used as well, reusing the framing, timeout
+// and close handshake logic of Conn.
+type transport interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// addresser is implemented by the transports having network addresses.
+type addresser interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
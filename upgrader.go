@@ -6,7 +6,9 @@ import (
 	b64 "encoding/base64"
 	"hash"
 	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -34,12 +36,104 @@ type Upgrader struct {
 	// Protocols are the supported protocols.
 	Protocols []string
 
+	// ProtocolHandlers are the handlers of the connections by subprotocol,
+	// so one endpoint can serve many subprotocols. Their subprotocols are
+	// supported as the Protocols are.
+	//
+	// The connections whose subprotocol has no handler are handled by Handler.
+	ProtocolHandlers map[string]RequestHandler
+
 	// Origin is used to limit the clients coming from the defined origin
 	Origin string
 
+	// CheckOrigin, if not nil, decides whether the Origin header of ctx
+	// is allowed, taking precedence over Origin. The connections
+	// not allowed are answered with 403 (Forbidden).
+	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
+
 	// Compress defines whether using compression or not.
 	// TODO
 	Compress bool
+
+	// Strict enables the RFC checks on the upgraded connections (see Conn.Strict).
+	Strict bool
+
+	// Config holds the settings of the upgraded connections,
+	// applied before reading from them.
+	Config Config
+
+	// Sessions, if not nil, mints a session token for every connection upgraded.
+	// The session and the token are stored in the user values
+	// (see SessionKey and SessionTokenKey).
+	Sessions *SessionIssuer
+
+	// Identity returns the identity of the client (user, device...)
+	// upgrading ctx, which is checked against the DuplicatePolicy.
+	// It's called after UpgradeHandler, so it can be taken from the user values.
+	// The identity is stored in the user values (see IdentityKey).
+	//
+	// If nil, Sessions.Identity is used. The empty identities are not checked.
+	Identity func(ctx *fasthttp.RequestCtx) string
+
+	// DuplicatePolicy decides what to do when the client identity
+	// already has active connections in the Registry
+	// (or in the connections of the Upgrader if Registry is nil).
+	// By default the duplicates are allowed.
+	DuplicatePolicy DuplicatePolicy
+
+	// MaxIdentityConns is the number of connections an identity
+	// can have open, as enforced by DuplicatePolicy. By default 1.
+	MaxIdentityConns int
+
+	// MaxConns is the max number of connections open at once. The upgrades
+	// requested when reached are refused with 503 (Service Unavailable).
+	//
+	// By default (0) the connections are not limited.
+	MaxConns int
+
+	// RetryAfter, if not zero, is sent in the Retry-After header (in seconds)
+	// when refusing the upgrades because of MaxConns.
+	RetryAfter time.Duration
+
+	// ErrorHandler, if not nil, writes the response of the failed upgrades
+	// (method not allowed, origin not allowed, version not supported...)
+	// given the status code and the reason of the failure.
+	//
+	// By default the reason is sent as a plain text body using ctx.Error.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, status int, reason string)
+
+	// Metrics, if not nil, receives the events of the upgrades
+	// and of the upgraded connections.
+	Metrics Metrics
+
+	// Logger, if not nil, logs the failed upgrades
+	// and the events of the upgraded connections (see Conn.SetLogger).
+	Logger Logger
+
+	// Registry, if not nil, tracks the live connections
+	// while their handler is running.
+	Registry *Registry
+
+	// Reaper, if not nil, closes the connections idle for too long
+	// while their handler is running.
+	Reaper *Reaper
+
+	// OnConnect is called before Handler when a connection is upgraded.
+	OnConnect func(conn *Conn)
+
+	// OnDisconnect is called when the connection has been closed after Handler returns.
+	//
+	// err is the error which closed the connection while reading, as returned
+	// by the read functions (a *CloseError if the peer closed it).
+	// err is nil if the connection was closed by the server.
+	OnDisconnect func(conn *Conn, err error)
+
+	lck        sync.Mutex
+	conns      map[*Conn]*upgradedConn
+	identities Registry
+	shutdown   bool
+	// reserved is the number of slots of MaxConns reserved (see reserve).
+	reserved int
 }
 
 func prepareOrigin(b []byte, uri *fasthttp.URI) []byte {
@@ -48,6 +142,49 @@ func prepareOrigin(b []byte, uri *fasthttp.URI) []byte {
 	return append(b, uri.Host()...)
 }
 
+// PendingConn is a connection accepted by Upgrader.Accept
+// whose handler hasn't been started yet.
+//
+// The frames sent by the peer are not read until Start is called.
+// Either Start or Close must be called, otherwise the connection is leaked.
+type PendingConn struct {
+	userValues  map[string]interface{}
+	subprotocol string
+	start       chan RequestHandler
+	once        sync.Once
+}
+
+// UserValue returns the key associated value.
+//
+// UserValue must not be called after Start.
+func (pc *PendingConn) UserValue(key string) interface{} {
+	return pc.userValues[key]
+}
+
+// SetUserValue assigns a key to the given value, which is available
+// from the connection after Start.
+//
+// SetUserValue must not be called after Start.
+func (pc *PendingConn) SetUserValue(key string, value interface{}) {
+	pc.userValues[key] = value
+}
+
+// Start starts reading from the connection and executes handler
+// as Upgrader.Handler is executed.
+//
+// Start doesn't wait for handler to return.
+// Calling Start or Close again does nothing.
+func (pc *PendingConn) Start(handler RequestHandler) {
+	pc.once.Do(func() {
+		pc.start <- handler
+	})
+}
+
+// Close closes the connection without starting it.
+func (pc *PendingConn) Close() {
+	pc.Start(nil)
+}
+
 // Upgrade upgrades HTTP to websocket connection if possible.
 //
 // If client does not request any websocket connection this function
@@ -55,14 +192,139 @@ func prepareOrigin(b []byte, uri *fasthttp.URI) []byte {
 //
 // When connection is successfully stablished the function calls s.Handler.
 func (upgr *Upgrader) Upgrade(ctx *fasthttp.RequestCtx) {
-	if !ctx.IsGet() {
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+	if pc := upgr.accept(ctx); pc != nil {
+		pc.Start(upgr.handler(pc.subprotocol))
+	}
+}
+
+// handler returns the handler of the connections using subprotocol.
+func (upgr *Upgrader) handler(subprotocol string) RequestHandler {
+	if h, ok := upgr.ProtocolHandlers[subprotocol]; ok && subprotocol != "" {
+		return h
+	}
+	return upgr.Handler
+}
+
+// selectProtocol selects the subprotocol among the protocols requested.
+func (upgr *Upgrader) selectProtocol(protos [][]byte) string {
+	for _, proto := range protos {
+		if _, ok := upgr.ProtocolHandlers[b2s(bytes.TrimSpace(proto))]; ok {
+			return string(bytes.TrimSpace(proto))
+		}
+	}
+	return selectProtocol(protos, upgr.Protocols)
+}
+
+// Accept upgrades ctx as Upgrade does, without starting the connection
+// until PendingConn.Start is called. So the slow setup of the connection
+// (database lookups, remote authorization) can be done after the handshake,
+// without reading the frames sent by the peer meanwhile.
+//
+// The connection is hijacked after the request handler returns,
+// so Start can be called from any goroutine.
+//
+// If ctx can't be upgraded ErrCannotUpgrade is returned and
+// the response of ctx is set accordingly.
+func (upgr *Upgrader) Accept(ctx *fasthttp.RequestCtx) (*PendingConn, error) {
+	pc := upgr.accept(ctx)
+	if pc == nil {
+		return nil, ErrCannotUpgrade
+	}
+	return pc, nil
+}
+
+// ServeConn upgrades the connection c accepted by a custom listener,
+// without a fasthttp.Server. The HTTP request is read from c.
+//
+// ServeConn returns once c is closed: after the handler returns
+// if c was upgraded, or after responding if the upgrade failed.
+func (upgr *Upgrader) ServeConn(c net.Conn) error {
+	nc := &notifyCloseConn{
+		Conn:   c,
+		closed: make(chan struct{}),
+	}
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			upgr.Upgrade(ctx)
+			if ctx.Response.StatusCode() != fasthttp.StatusSwitchingProtocols {
+				// only upgrades are served.
+				ctx.SetConnectionClose()
+			}
+		},
+		NoDefaultServerHeader: true,
+	}
+
+	err := s.ServeConn(nc)
+	<-nc.closed
+
+	return err
+}
+
+// notifyCloseConn is a net.Conn closing closed when closed,
+// either by the fasthttp.Server or after the hijack handler returns.
+type notifyCloseConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (nc *notifyCloseConn) Close() error {
+	nc.once.Do(func() {
+		close(nc.closed)
+	})
+	return nc.Conn.Close()
+}
+
+// error responds to a failed upgrade.
+func (upgr *Upgrader) error(ctx *fasthttp.RequestCtx, status int, reason string) {
+	if upgr.Metrics != nil {
+		upgr.Metrics.OnHandshakeError(status, reason)
+	}
+	if upgr.Logger != nil && upgr.Logger.Enabled(LogInfo) {
+		upgr.Logger.Logf(LogInfo, "upgrade from %s failed with %d: %s", ctx.RemoteAddr(), status, reason)
+	}
+	if upgr.ErrorHandler != nil {
+		upgr.ErrorHandler(ctx, status, reason)
 		return
 	}
+	ctx.Error(reason, status)
+}
+
+// accept upgrades ctx returning nil if it's not possible.
+func (upgr *Upgrader) accept(ctx *fasthttp.RequestCtx) (pc *PendingConn) {
+	if !ctx.IsGet() {
+		upgr.error(ctx, fasthttp.StatusBadRequest, "Method must be GET")
+		return nil
+	}
+	if upgr.isShutdown() {
+		upgr.error(ctx, fasthttp.StatusServiceUnavailable, "Shutting down")
+		return nil
+	}
+	// the slot is reserved before responding, so the concurrent upgrades
+	// exceeding MaxConns are refused here.
+	rs, ok := upgr.reserve()
+	if !ok {
+		upgr.error(ctx, fasthttp.StatusServiceUnavailable, "Too many connections")
+		if upgr.RetryAfter > 0 {
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(int((upgr.RetryAfter+time.Second-1)/time.Second)))
+		}
+		return nil
+	}
+	defer func() {
+		// the slot is taken by the connection once hijacked.
+		if pc == nil {
+			rs.release()
+		}
+	}()
 
 	// Checking Origin header if needed
 	origin := ctx.Request.Header.Peek("Origin")
-	if upgr.Origin != "" {
+	if upgr.CheckOrigin != nil {
+		if !upgr.CheckOrigin(ctx) {
+			upgr.error(ctx, fasthttp.StatusForbidden, "Origin not allowed")
+			return nil
+		}
+	} else if upgr.Origin != "" {
 		uri := fasthttp.AcquireURI()
 		uri.Update(upgr.Origin)
 
@@ -71,9 +333,9 @@ func (upgr *Upgrader) Upgrade(ctx *fasthttp.RequestCtx) {
 		fasthttp.ReleaseURI(uri)
 
 		if !equalsFold(b, origin) {
-			ctx.SetStatusCode(fasthttp.StatusForbidden)
+			upgr.error(ctx, fasthttp.StatusForbidden, "Origin not allowed")
 			bytePool.Put(b)
-			return
+			return nil
 		}
 		bytePool.Put(b)
 	}
@@ -95,60 +357,152 @@ func (upgr *Upgrader) Upgrade(ctx *fasthttp.RequestCtx) {
 			hprotos := bytes.Split( // TODO: Reduce allocations. Do not split. Use IndexByte
 				ctx.Request.Header.PeekBytes(wsHeaderProtocol), commaString,
 			)
-			supported := false
-			// Checking versions
-			for i := range supportedVersions {
-				if bytes.Contains(supportedVersions[i], hversion) {
-					supported = true
-					break
-				}
-			}
-			if !supported {
-				ctx.Error("Versions not supported", fasthttp.StatusBadRequest)
-				return
+			if !isSupportedVersion(hversion) {
+				// advertising the supported versions (RFC 6455 section 4.4)
+				upgr.error(ctx, fasthttp.StatusUpgradeRequired, "Versions not supported")
+				ctx.Response.Header.SetBytesK(wsHeaderVersion, supportedVersionsHeader)
+				return nil
 			}
 
 			if upgr.UpgradeHandler != nil {
 				if !upgr.UpgradeHandler(ctx) {
-					return
+					return nil
 				}
 			}
+			identity := upgr.identity(ctx)
+			if upgr.isDuplicate(identity) {
+				upgr.error(ctx, fasthttp.StatusConflict, "Too many connections")
+				return nil
+			}
+
 			// TODO: compression
 			//compress := mustCompress(exts)
 			compress := false
 
+			var (
+				token   string
+				session *Session
+			)
+			if upgr.Sessions != nil {
+				var err error
+				token, session, err = upgr.Sessions.issue(ctx)
+				if err != nil {
+					upgr.error(ctx, fasthttp.StatusInternalServerError, err.Error())
+					return nil
+				}
+			}
+
 			// Setting response headers
 			ctx.Response.SetStatusCode(fasthttp.StatusSwitchingProtocols)
 			ctx.Response.Header.AddBytesKV(connectionString, upgradeString)
 			ctx.Response.Header.AddBytesKV(upgradeString, websocketString)
 			ctx.Response.Header.AddBytesKV(wsHeaderAccept, makeKey(hkey, hkey))
-			// TODO: implement bad websocket version
-			// https://tools.ietf.org/html/rfc6455#section-4.4
-			if proto := selectProtocol(hprotos, upgr.Protocols); proto != "" {
+			proto := upgr.selectProtocol(hprotos)
+			if proto != "" {
 				ctx.Response.Header.AddBytesK(wsHeaderProtocol, proto)
 			}
 
+			hints := parseClientHints(ctx.Request.Header.PeekBytes)
+			req := newRequest(ctx)
+			req.Subprotocol = proto
+
 			userValues := make(map[string]interface{})
 			ctx.VisitUserValues(func(k []byte, v interface{}) {
 				userValues[string(k)] = v
 			})
+			if session != nil {
+				userValues[SessionKey] = session
+				userValues[SessionTokenKey] = token
+			}
+			if identity != "" {
+				userValues[IdentityKey] = identity
+			}
+
+			pc = &PendingConn{
+				userValues:  userValues,
+				subprotocol: proto,
+				start:       make(chan RequestHandler, 1),
+			}
 
 			ctx.Hijack(func(c net.Conn) {
-				conn := acquireConn(c)
+				handler := <-pc.start
+
+				conn := acquireConnConfig(c, nil, &upgr.Config)
 				// stablishing default options
 				conn.server = true
 				conn.compress = compress
+				conn.Strict = upgr.Strict
 				conn.userValues = userValues
+				conn.hints = hints
+				conn.req = req
+
+				var uc *upgradedConn
+				if handler != nil {
+					uc = upgr.track(conn, identity, rs)
+				} else {
+					rs.release()
+				}
+				if uc == nil {
+					conn.Close()
+					releaseConn(conn)
+					return
+				}
+
+				if upgr.Logger != nil {
+					conn.SetLogger(upgr.Logger)
+				}
+				if upgr.Metrics != nil {
+					conn.SetMetrics(upgr.Metrics)
+					upgr.Metrics.OnUpgrade(conn)
+				}
+
+				if session != nil && upgr.Sessions.SendMessage {
+					conn.WriteMessage(ModeText, s2b(token))
+				}
+
+				if upgr.OnConnect != nil {
+					upgr.OnConnect(conn)
+				}
+				if upgr.Reaper != nil {
+					upgr.Reaper.Add(conn)
+				}
 
 				// executing handler
-				upgr.Handler(conn)
+				handler(conn)
 
 				// closes and release the connection
 				conn.Close()
+				if r := upgr.registry(); r != nil {
+					r.Remove(conn)
+				}
+				if upgr.Reaper != nil {
+					upgr.Reaper.Remove(conn)
+				}
+				if upgr.OnDisconnect != nil {
+					upgr.OnDisconnect(conn, conn.readError())
+				}
+				upgr.untrack(uc)
 				releaseConn(conn)
 			})
+
+			return pc
+		}
+	}
+
+	return nil
+}
+
+// supportedVersionsHeader is the Sec-WebSocket-Version header value
+// sent when the version requested is not supported.
+var supportedVersionsHeader = string(bytes.Join(supportedVersions, []byte(", ")))
+
+func isSupportedVersion(version []byte) bool {
+	for i := range supportedVersions {
+		if bytes.Equal(supportedVersions[i], version) {
+			return true
 		}
 	}
+	return false
 }
 
 var shaPool = sync.Pool{
@@ -195,11 +549,12 @@ func selectProtocol(protos [][]byte, accepted []string) string {
 	}
 
 	for _, proto := range protos {
+		proto = bytes.TrimSpace(proto)
 		for _, accept := range accepted {
 			if b2s(proto) == accept {
 				return accept
 			}
 		}
 	}
-	return string(protos[0])
+	return string(bytes.TrimSpace(protos[0]))
 }
@@ -2,9 +2,12 @@ package fastws
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -14,6 +17,16 @@ type (
 	NetUpgradeHandler func(resp http.ResponseWriter, req *http.Request) bool
 )
 
+// ErrHijackNotSupported is the error passed to NetUpgrader.OnError when
+// the http.ResponseWriter doesn't implement http.Hijacker, as the HTTP/2 ones.
+//
+// The HTTP/2 clients must use an extended CONNECT request (RFC 8441),
+// which NetUpgrader upgrades without hijacking. Otherwise HTTP/2 must be
+// disabled for the websocket endpoints, or the middlewares wrapping
+// the http.ResponseWriter must expose http.Hijacker.
+var ErrHijackNotSupported = errors.New("http.ResponseWriter doesn't implement http.Hijacker: " +
+	"use an extended CONNECT request (RFC 8441) over HTTP/2 or disable HTTP/2")
+
 // NetUpgrader upgrades HTTP connection to a websocket connection if it's possible.
 //
 // NetUpgrader executes NetUpgrader.Handler after successful websocket upgrading.
@@ -27,139 +40,379 @@ type NetUpgrader struct {
 	// Handler is the request handler for ws connections.
 	Handler RequestHandler
 
+	// Fallback, if not nil, serves the requests which are not websocket upgrades
+	// (i.e.: the page using the websocket), instead of responding
+	// 426 (Upgrade Required).
+	Fallback http.Handler
+
 	// Protocols are the supported protocols.
 	Protocols []string
 
 	// Origin is used to limit the clients coming from the defined origin
 	Origin string
 
+	// CheckOrigin, if not nil, decides whether the Origin header of req
+	// is allowed, taking precedence over Origin. The connections
+	// not allowed are answered with 403 (Forbidden).
+	CheckOrigin func(req *http.Request) bool
+
 	// Compress defines whether using compression or not.
 	// TODO
 	Compress bool
+
+	// Strict enables the RFC checks on the upgraded connections (see Conn.Strict).
+	Strict bool
+
+	// Config holds the settings of the upgraded connections,
+	// applied before reading from them.
+	Config Config
+
+	// UserValueExtractor, if not nil, returns the user values of the connection
+	// upgraded from req (see Conn.UserValue), so the values set by the middlewares
+	// (i.e.: in the request context) can be passed to Handler.
+	// It's called before upgrading the connection.
+	UserValueExtractor func(req *http.Request) map[string]interface{}
+
+	// Registry, if not nil, tracks the live connections
+	// while their handler is running.
+	Registry *Registry
+
+	// Reaper, if not nil, closes the connections idle for too long
+	// while their handler is running.
+	Reaper *Reaper
+
+	// OnConnect is called before Handler when a connection is upgraded.
+	OnConnect func(conn *Conn)
+
+	// OnDisconnect is called when the connection has been closed after Handler returns.
+	//
+	// err is the error which closed the connection while reading, as returned
+	// by the read functions (a *CloseError if the peer closed it).
+	// err is nil if the connection was closed by the server.
+	OnDisconnect func(conn *Conn, err error)
+
+	// OnError is called when the websocket handshake is valid
+	// but the connection can't be upgraded because of err
+	// (ErrHijackNotSupported or the error hijacking the connection).
+	//
+	// OnError must write the response. By default err is written
+	// using the status 505 (HTTP Version Not Supported) for HTTP/2 requests
+	// and 500 (Internal Server Error) otherwise.
+	OnError func(resp http.ResponseWriter, req *http.Request, err error)
 }
 
 // Upgrade upgrades HTTP to websocket connection if possible.
 //
-// If client does not request any websocket connection this function
-// will execute ctx.NotFound()
+// The requests which are not websocket upgrades are served by Fallback,
+// or answered with 426 (Upgrade Required). The invalid upgrades
+// are answered with 400 (Bad Request).
 //
 // When connection is successfully stablished the function calls s.Handler.
+//
+// The HTTP/2 connections are upgraded using extended CONNECT requests (RFC 8441),
+// executing the handler before returning. Other requests over HTTP/2 can't be
+// upgraded, calling OnError with ErrHijackNotSupported.
 func (upgr *NetUpgrader) Upgrade(resp http.ResponseWriter, req *http.Request) {
 	rs := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(rs)
 
+	if req.Method == http.MethodConnect && req.ProtoMajor == 2 {
+		upgr.upgradeStream(resp, req)
+		return
+	}
+
+	if !hasHeaderToken(req.Header["Connection"], "Upgrade") {
+		upgr.notUpgrade(resp, req)
+		return
+	}
+
 	if req.Method != "GET" {
-		resp.WriteHeader(http.StatusBadRequest)
+		http.Error(resp, "Method must be GET", http.StatusBadRequest)
+		return
+	}
+	if !upgr.checkOrigin(resp, req) {
 		return
 	}
 
-	// Checking Origin header if needed
-	origin := req.Header.Get("Origin")
-	if upgr.Origin != "" {
-		uri := fasthttp.AcquireURI()
-		uri.Update(upgr.Origin)
+	// Normalizing must be disabled because of WebSocket header fields.
+	// (This is not a fasthttp bug).
+	rs.Header.DisableNormalizing()
 
-		b := bytePool.Get().([]byte)
-		b = prepareOrigin(b, uri)
-		fasthttp.ReleaseURI(uri)
+	// Peek upgrade header field.
+	hup := req.Header.Get("Upgrade")
+	// Compare with websocket string defined by the RFC
+	if !equalsFold(s2b(hup), websocketString) {
+		http.Error(resp, "Upgrade must be websocket", http.StatusBadRequest)
+		return
+	}
 
-		if !equalsFold(b, s2b(origin)) {
-			resp.WriteHeader(http.StatusForbidden)
-			bytePool.Put(b)
+	// Peeking websocket key.
+	hkey := req.Header.Get(b2s(wsHeaderKey))
+	hprotos := bytes.Split( // TODO: Reduce allocations. Do not split. Use IndexByte
+		s2b(req.Header.Get(b2s(wsHeaderProtocol))), commaString,
+	)
+	if !checkNetVersion(resp, req) {
+		return
+	}
+
+	if upgr.UpgradeHandler != nil {
+		if !upgr.UpgradeHandler(resp, req) {
 			return
 		}
-		bytePool.Put(b)
 	}
 
-	// Normalizing must be disabled because of WebSocket header fields.
-	// (This is not a fasthttp bug).
-	rs.Header.DisableNormalizing()
+	h, ok := resp.(http.Hijacker)
+	if !ok {
+		upgr.error(resp, req, ErrHijackNotSupported)
+		return
+	}
+
+	hints := parseClientHints(func(key []byte) []byte {
+		return s2b(req.Header.Get(b2s(key)))
+	})
+
+	r := newNetRequest(req)
+	values := upgr.userValues(req)
+
+	c, _, err := h.Hijack()
+	if err != nil {
+		upgr.error(resp, req, err)
+		return
+	}
+
+	// Setting response headers
+	rs.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	rs.Header.AddBytesKV(connectionString, upgradeString)
+	rs.Header.AddBytesKV(upgradeString, websocketString)
+	rs.Header.AddBytesKV(wsHeaderAccept, makeKey(s2b(hkey), s2b(hkey)))
+	if proto := selectProtocol(hprotos, upgr.Protocols); proto != "" {
+		rs.Header.AddBytesK(wsHeaderProtocol, proto)
+		r.Subprotocol = proto
+	}
 
-	hasUpgrade := func() bool {
-		for _, v := range req.Header["Connection"] {
-			if strings.Contains(v, "Upgrade") {
+	_, err = rs.WriteTo(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+
+	go upgr.serve(c, hints, r, values)
+}
+
+// hasHeaderToken returns whether the comma-separated values
+// of a header contain token (case-insensitive).
+func hasHeaderToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
 				return true
 			}
 		}
-		return false
-	}()
-
-	// Connection.Value == Upgrade
-	if hasUpgrade {
-		// Peek upgrade header field.
-		hup := req.Header.Get("Upgrade")
-		// Compare with websocket string defined by the RFC
-		if equalsFold(s2b(hup), websocketString) {
-			// Checking websocket version
-			hversion := req.Header.Get(b2s(wsHeaderVersion))
-			// Peeking websocket key.
-			hkey := req.Header.Get(b2s(wsHeaderKey))
-			hprotos := bytes.Split( // TODO: Reduce allocations. Do not split. Use IndexByte
-				s2b(req.Header.Get(b2s(wsHeaderProtocol))), commaString,
-			)
-			supported := false
-			// Checking versions
-			for i := range supportedVersions {
-				if bytes.Contains(supportedVersions[i], s2b(hversion)) {
-					supported = true
-					break
-				}
-			}
-			if !supported {
-				resp.WriteHeader(http.StatusBadRequest)
-				io.WriteString(resp, "Versions not supported")
-				return
-			}
+	}
+	return false
+}
 
-			if upgr.UpgradeHandler != nil {
-				if !upgr.UpgradeHandler(resp, req) {
-					return
-				}
-			}
-			// TODO: compression
-			//compress := mustCompress(exts)
-			compress := false
-
-			h, ok := resp.(http.Hijacker)
-			if !ok {
-				resp.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+// notUpgrade serves a request which is not a websocket upgrade.
+func (upgr *NetUpgrader) notUpgrade(resp http.ResponseWriter, req *http.Request) {
+	if upgr.Fallback != nil {
+		upgr.Fallback.ServeHTTP(resp, req)
+		return
+	}
+	// the upgrade required must be advertised (RFC 7231 section 6.5.15).
+	resp.Header().Set("Connection", "Upgrade")
+	resp.Header().Set("Upgrade", "websocket")
+	http.Error(resp, "Upgrade Required", http.StatusUpgradeRequired)
+}
 
-			c, _, err := h.Hijack()
-			if err != nil {
-				io.WriteString(resp, err.Error())
-				return
-			}
+// upgradeStream upgrades an extended CONNECT request (RFC 8441),
+// using the HTTP/2 stream as the websocket connection.
+//
+// The handler is executed before returning, as the stream
+// is closed when the http.Handler returns.
+func (upgr *NetUpgrader) upgradeStream(resp http.ResponseWriter, req *http.Request) {
+	if !equalsFold(s2b(req.Header.Get(":protocol")), websocketString) {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !upgr.checkOrigin(resp, req) || !checkNetVersion(resp, req) {
+		return
+	}
+	if upgr.UpgradeHandler != nil {
+		if !upgr.UpgradeHandler(resp, req) {
+			return
+		}
+	}
 
-			// Setting response headers
-			rs.SetStatusCode(fasthttp.StatusSwitchingProtocols)
-			rs.Header.AddBytesKV(connectionString, upgradeString)
-			rs.Header.AddBytesKV(upgradeString, websocketString)
-			rs.Header.AddBytesKV(wsHeaderAccept, makeKey(s2b(hkey), s2b(hkey)))
-			// TODO: implement bad websocket version
-			// https://tools.ietf.org/html/rfc6455#section-4.4
-			if proto := selectProtocol(hprotos, upgr.Protocols); proto != "" {
-				rs.Header.AddBytesK(wsHeaderProtocol, proto)
-			}
+	f, ok := resp.(http.Flusher)
+	if !ok {
+		upgr.error(resp, req, ErrHijackNotSupported)
+		return
+	}
 
-			_, err = rs.WriteTo(c)
-			if err != nil {
-				c.Close()
-				return
-			}
+	hints := parseClientHints(func(key []byte) []byte {
+		return s2b(req.Header.Get(b2s(key)))
+	})
+	r := newNetRequest(req)
+	values := upgr.userValues(req)
+
+	hprotos := bytes.Split(s2b(req.Header.Get(b2s(wsHeaderProtocol))), commaString)
+	if proto := selectProtocol(hprotos, upgr.Protocols); proto != "" {
+		resp.Header().Set(b2s(wsHeaderProtocol), proto)
+		r.Subprotocol = proto
+	}
+	resp.WriteHeader(http.StatusOK)
+	f.Flush()
 
-			go func() {
-				conn := acquireConn(c)
-				// stablishing default options
-				conn.server = true
-				conn.compress = compress
-				// executing handler
-				upgr.Handler(conn)
-				// closes and release the connection
-				conn.Close()
-				releaseConn(conn)
-			}()
+	upgr.serve(&streamTransport{
+		r: req.Body,
+		w: resp,
+		f: f,
+	}, hints, r, values)
+}
+
+// userValues returns the user values extracted from req, if any.
+func (upgr *NetUpgrader) userValues(req *http.Request) map[string]interface{} {
+	if upgr.UserValueExtractor == nil {
+		return nil
+	}
+	return upgr.UserValueExtractor(req)
+}
+
+// serve executes the handler over c, closing and releasing the connection after.
+func (upgr *NetUpgrader) serve(c transport, hints ClientHints, r *Request, values map[string]interface{}) {
+	conn := acquireConnConfig(c, nil, &upgr.Config)
+	for k, v := range values {
+		conn.userValues[k] = v
+	}
+	// stablishing default options
+	conn.server = true
+	// TODO: compression
+	conn.compress = false
+	conn.Strict = upgr.Strict
+	conn.hints = hints
+	conn.req = r
+	if upgr.OnConnect != nil {
+		upgr.OnConnect(conn)
+	}
+	if upgr.Registry != nil {
+		upgr.Registry.Add(conn)
+	}
+	if upgr.Reaper != nil {
+		upgr.Reaper.Add(conn)
+	}
+	// executing handler
+	upgr.Handler(conn)
+	// closes and release the connection
+	conn.Close()
+	if upgr.Registry != nil {
+		upgr.Registry.Remove(conn)
+	}
+	if upgr.Reaper != nil {
+		upgr.Reaper.Remove(conn)
+	}
+	if upgr.OnDisconnect != nil {
+		upgr.OnDisconnect(conn, conn.readError())
+	}
+	releaseConn(conn)
+}
+
+// checkOrigin checks the Origin header if needed, responding 403 (Forbidden) if it's not allowed.
+func (upgr *NetUpgrader) checkOrigin(resp http.ResponseWriter, req *http.Request) bool {
+	if upgr.CheckOrigin != nil {
+		ok := upgr.CheckOrigin(req)
+		if !ok {
+			resp.WriteHeader(http.StatusForbidden)
 		}
+		return ok
 	}
+	if upgr.Origin == "" {
+		return true
+	}
+	origin := req.Header.Get("Origin")
+
+	uri := fasthttp.AcquireURI()
+	uri.Update(upgr.Origin)
+
+	b := bytePool.Get().([]byte)
+	b = prepareOrigin(b, uri)
+	fasthttp.ReleaseURI(uri)
+
+	ok := equalsFold(b, s2b(origin))
+	bytePool.Put(b)
+	if !ok {
+		resp.WriteHeader(http.StatusForbidden)
+	}
+	return ok
+}
+
+// checkNetVersion checks the websocket version, responding 426 (Upgrade Required)
+// with the supported versions if it's not supported.
+func checkNetVersion(resp http.ResponseWriter, req *http.Request) bool {
+	if isSupportedVersion(s2b(req.Header.Get(b2s(wsHeaderVersion)))) {
+		return true
+	}
+	resp.Header().Set(b2s(wsHeaderVersion), supportedVersionsHeader)
+	resp.WriteHeader(http.StatusUpgradeRequired)
+	io.WriteString(resp, "Versions not supported")
+	return false
+}
+
+func (upgr *NetUpgrader) error(resp http.ResponseWriter, req *http.Request, err error) {
+	if upgr.OnError != nil {
+		upgr.OnError(resp, req, err)
+		return
+	}
+	status := http.StatusInternalServerError
+	if req.ProtoMajor == 2 {
+		status = http.StatusHTTPVersionNotSupported
+	}
+	http.Error(resp, err.Error(), status)
+}
+
+// streamTransport is the transport over the request and response bodies
+// of an HTTP/2 stream.
+//
+// The deadlines are not supported, so the writes are only
+// interrupted by closing the transport.
+type streamTransport struct {
+	r io.ReadCloser
+	w io.Writer
+	f http.Flusher
+
+	// wlck protects w, which can't be used after closing the transport.
+	wlck   sync.Mutex
+	closed bool
+}
+
+func (st *streamTransport) Read(b []byte) (int, error) {
+	return st.r.Read(b)
 }
+
+func (st *streamTransport) Write(b []byte) (int, error) {
+	st.wlck.Lock()
+	defer st.wlck.Unlock()
+
+	if st.closed {
+		return 0, EOF
+	}
+	n, err := st.w.Write(b)
+	if err == nil {
+		st.f.Flush()
+	}
+	return n, err
+}
+
+func (st *streamTransport) Close() error {
+	// closing the body first unblocks the readers.
+	err := st.r.Close()
+
+	st.wlck.Lock()
+	st.closed = true
+	st.wlck.Unlock()
+
+	return err
+}
+
+func (st *streamTransport) SetDeadline(t time.Time) error      { return nil }
+func (st *streamTransport) SetReadDeadline(t time.Time) error  { return nil }
+func (st *streamTransport) SetWriteDeadline(t time.Time) error { return nil }
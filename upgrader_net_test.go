@@ -0,0 +1,303 @@
+package fastws
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newNetUpgradeRequest() *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	return req
+}
+
+func TestNetUpgraderNotHijacker(t *testing.T) {
+	upgr := NetUpgrader{
+		Handler: func(conn *Conn) {
+			t.Fatal("Unexpected upgrade")
+		},
+	}
+
+	resp := httptest.NewRecorder()
+	upgr.Upgrade(resp, newNetUpgradeRequest())
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(resp.Body.String(), ErrHijackNotSupported.Error()) {
+		t.Fatalf("Unexpected body: %s", resp.Body)
+	}
+
+	req := newNetUpgradeRequest()
+	req.ProtoMajor = 2
+	resp = httptest.NewRecorder()
+	upgr.Upgrade(resp, req)
+	if resp.Code != http.StatusHTTPVersionNotSupported {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusHTTPVersionNotSupported)
+	}
+
+	var gotErr error
+	upgr.OnError = func(resp http.ResponseWriter, req *http.Request, err error) {
+		gotErr = err
+		resp.WriteHeader(http.StatusTeapot)
+	}
+	resp = httptest.NewRecorder()
+	upgr.Upgrade(resp, newNetUpgradeRequest())
+	if gotErr != ErrHijackNotSupported || resp.Code != http.StatusTeapot {
+		t.Fatalf("Unexpected error: %v (%d)", gotErr, resp.Code)
+	}
+}
+
+func TestNetUpgraderConnectionHeader(t *testing.T) {
+	var upgr NetUpgrader
+
+	for _, v := range []string{"upgrade", "keep-alive, upgrade", "Keep-Alive,Upgrade"} {
+		req := newNetUpgradeRequest()
+		req.Header.Set("Connection", v)
+
+		// the recorder can't be hijacked, so the upgrade is attempted.
+		resp := httptest.NewRecorder()
+		upgr.Upgrade(resp, req)
+		if resp.Code != http.StatusInternalServerError {
+			t.Fatalf("%q: unexpected status: %d <> %d", v, resp.Code, http.StatusInternalServerError)
+		}
+	}
+
+	req := newNetUpgradeRequest()
+	req.Header.Set("Connection", "keep-alive, upgraded")
+	resp := httptest.NewRecorder()
+	upgr.Upgrade(resp, req)
+	if resp.Code != http.StatusUpgradeRequired {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusUpgradeRequired)
+	}
+}
+
+// streamResponse is an http.ResponseWriter writing the body to a pipe, as an HTTP/2 stream.
+type streamResponse struct {
+	header http.Header
+	status chan int
+	w      *io.PipeWriter
+}
+
+func (r *streamResponse) Header() http.Header         { return r.header }
+func (r *streamResponse) Write(b []byte) (int, error) { return r.w.Write(b) }
+func (r *streamResponse) WriteHeader(status int)      { r.status <- status }
+func (r *streamResponse) Flush()                      {}
+
+// pipeStream is the client side of the stream.
+type pipeStream struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (p *pipeStream) SetDeadline(t time.Time) error      { return nil }
+func (p *pipeStream) SetReadDeadline(t time.Time) error  { return nil }
+func (p *pipeStream) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestNetUpgraderExtendedConnect(t *testing.T) {
+	br, bw := io.Pipe()
+	rr, rw := io.Pipe()
+
+	req := httptest.NewRequest("CONNECT", "/chat", br)
+	req.ProtoMajor = 2
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat")
+	resp := &streamResponse{
+		header: make(http.Header),
+		status: make(chan int, 1),
+		w:      rw,
+	}
+
+	upgr := NetUpgrader{
+		Protocols: []string{"chat"},
+		Handler: func(conn *Conn) {
+			if conn.Request().Path != "/chat" {
+				t.Errorf("Unexpected path: %s", conn.Request().Path)
+			}
+			mode, b, err := conn.ReadMessage(nil)
+			if err == nil {
+				_, err = conn.WriteMessage(mode, b)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		upgr.Upgrade(resp, req)
+		rw.Close()
+	}()
+
+	if status := <-resp.status; status != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", status)
+	}
+	if proto := resp.header.Get("Sec-WebSocket-Protocol"); proto != "chat" {
+		t.Fatalf("Unexpected protocol: %s", proto)
+	}
+
+	conn := acquireConn(&pipeStream{rr, bw})
+	defer conn.mustClose(false)
+
+	if _, err := conn.WriteString("Hello"); err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %s <> Hello", b)
+	}
+
+	// replying to the close frame sent when the handler returns.
+	if _, _, err = conn.ReadMessage(nil); err == nil {
+		t.Fatal("Expected the connection to be closed")
+	}
+
+	// Upgrade returns once the handler is done.
+	wg.Wait()
+}
+
+func TestNetUpgraderCheckOrigin(t *testing.T) {
+	upgr := NetUpgrader{
+		// CheckOrigin takes precedence.
+		Origin: "https://example.com",
+		CheckOrigin: func(req *http.Request) bool {
+			return strings.HasSuffix(req.Header.Get("Origin"), ".tenant.example.com")
+		},
+		Handler: func(conn *Conn) {
+			t.Fatal("Unexpected upgrade")
+		},
+	}
+
+	req := newNetUpgradeRequest()
+	req.Header.Set("Origin", "https://example.com")
+	resp := httptest.NewRecorder()
+	upgr.Upgrade(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusForbidden)
+	}
+
+	// allowed, failing later as the recorder can't be hijacked.
+	req = newNetUpgradeRequest()
+	req.Header.Set("Origin", "https://acme.tenant.example.com")
+	resp = httptest.NewRecorder()
+	upgr.Upgrade(resp, req)
+	if resp.Code != http.StatusInternalServerError {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestNetUpgraderVersionNotSupported(t *testing.T) {
+	upgr := NetUpgrader{
+		Handler: func(conn *Conn) {
+			t.Fatal("Unexpected upgrade")
+		},
+	}
+
+	for _, version := range []string{"", "1", "8"} {
+		req := newNetUpgradeRequest()
+		req.Header.Set("Sec-WebSocket-Version", version)
+
+		resp := httptest.NewRecorder()
+		upgr.Upgrade(resp, req)
+		if resp.Code != http.StatusUpgradeRequired {
+			t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusUpgradeRequired)
+		}
+		if v := resp.Header().Get("Sec-WebSocket-Version"); v != "13" {
+			t.Fatalf("Unexpected versions: %q", v)
+		}
+	}
+}
+
+type userKey struct{}
+
+func TestNetUpgraderUserValueExtractor(t *testing.T) {
+	br, bw := io.Pipe()
+	rr, rw := io.Pipe()
+
+	req := httptest.NewRequest("CONNECT", "/", br)
+	req.ProtoMajor = 2
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	// as set by an authentication middleware.
+	req = req.WithContext(context.WithValue(req.Context(), userKey{}, "alice"))
+	resp := &streamResponse{
+		header: make(http.Header),
+		status: make(chan int, 1),
+		w:      rw,
+	}
+
+	user := make(chan interface{}, 1)
+	upgr := NetUpgrader{
+		UserValueExtractor: func(req *http.Request) map[string]interface{} {
+			return map[string]interface{}{
+				"user": req.Context().Value(userKey{}),
+			}
+		},
+		Handler: func(conn *Conn) {
+			user <- conn.UserValue("user")
+		},
+	}
+
+	go func() {
+		upgr.Upgrade(resp, req)
+		rw.Close()
+	}()
+
+	if status := <-resp.status; status != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", status)
+	}
+
+	conn := acquireConn(&pipeStream{rr, bw})
+	defer conn.mustClose(false)
+	// replying to the close frame sent when the handler returns.
+	go conn.ReadMessage(nil)
+
+	if v := <-user; v != "alice" {
+		t.Fatalf("Unexpected user value: %v <> alice", v)
+	}
+}
+
+func TestNetUpgraderNotUpgrade(t *testing.T) {
+	var upgr NetUpgrader
+
+	resp := httptest.NewRecorder()
+	upgr.Upgrade(resp, httptest.NewRequest("GET", "/", nil))
+	if resp.Code != http.StatusUpgradeRequired {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusUpgradeRequired)
+	}
+	if up := resp.Header().Get("Upgrade"); up != "websocket" {
+		t.Fatalf("Unexpected Upgrade header: %q", up)
+	}
+
+	req := newNetUpgradeRequest()
+	req.Header.Set("Upgrade", "h2c")
+	resp = httptest.NewRecorder()
+	upgr.Upgrade(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected status: %d <> %d", resp.Code, http.StatusBadRequest)
+	}
+
+	upgr.Fallback = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, "index")
+	})
+	resp = httptest.NewRecorder()
+	upgr.Upgrade(resp, httptest.NewRequest("POST", "/", nil))
+	if resp.Code != http.StatusOK || resp.Body.String() != "index" {
+		t.Fatalf("Unexpected response: %d %q", resp.Code, resp.Body)
+	}
+}
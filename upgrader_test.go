@@ -1,8 +1,16 @@
 package fastws
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 var (
@@ -55,3 +63,420 @@ func BenchmarkBase64Decoding(b *testing.B) {
 		}
 	}
 }
+
+func TestUpgraderLifecycleHooks(t *testing.T) {
+	events := make(chan string, 3)
+	disconnected := make(chan error, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		OnConnect: func(conn *Conn) {
+			events <- "connect"
+		},
+		Handler: func(conn *Conn) {
+			events <- "handler"
+			conn.ReadMessage(nil)
+		},
+		OnDisconnect: func(conn *Conn, err error) {
+			events <- "disconnect"
+			disconnected <- err
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	conn.CloseWithCode(StatusGoAway, "Bye")
+
+	for _, expected := range []string{"connect", "handler", "disconnect"} {
+		if e := <-events; e != expected {
+			t.Fatalf("Unexpected event: %s <> %s", e, expected)
+		}
+	}
+
+	var cerr *CloseError
+	if err := <-disconnected; !errors.As(err, &cerr) || cerr.Status != StatusGoAway {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUpgraderAccept(t *testing.T) {
+	pending := make(chan *PendingConn, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{}
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			pc, err := upgr.Accept(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pending <- pc
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+
+	pc := <-pending
+	// the message is read once started.
+	conn.WriteString("Hello")
+	time.Sleep(time.Millisecond * 10)
+	pc.SetUserValue("user", "alice")
+
+	received := make(chan string, 1)
+	pc.Start(func(conn *Conn) {
+		_, b, _ := conn.ReadMessage(nil)
+		received <- conn.UserValue("user").(string) + ": " + string(b)
+	})
+
+	if s := <-received; s != "alice: Hello" {
+		t.Fatalf("Unexpected message: %s", s)
+	}
+}
+
+func TestUpgraderAcceptClose(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{}
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			pc, err := upgr.Accept(ctx)
+			if err == nil {
+				go pc.Close()
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+
+	_, _, err := conn.ReadMessage(nil)
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUpgraderAcceptNotUpgrade(t *testing.T) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("GET")
+
+	upgr := Upgrader{}
+	if _, err := upgr.Accept(&ctx); err != ErrCannotUpgrade {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrCannotUpgrade)
+	}
+}
+
+func TestUpgraderCheckOrigin(t *testing.T) {
+	upgr := Upgrader{
+		// CheckOrigin takes precedence.
+		Origin: "https://example.com",
+		CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
+			return bytes.HasSuffix(ctx.Request.Header.Peek("Origin"), []byte(".tenant.example.com"))
+		},
+	}
+
+	for origin, status := range map[string]int{
+		"https://example.com":             fasthttp.StatusForbidden,
+		"https://acme.tenant.example.com": fasthttp.StatusSwitchingProtocols,
+	} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.Header.Set("Connection", "Upgrade")
+		ctx.Request.Header.Set("Upgrade", "websocket")
+		ctx.Request.Header.Set("Sec-WebSocket-Version", "13")
+		ctx.Request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		ctx.Request.Header.Set("Origin", origin)
+
+		upgr.Accept(&ctx)
+		if code := ctx.Response.StatusCode(); code != status {
+			t.Fatalf("Unexpected status for %s: %d <> %d", origin, code, status)
+		}
+	}
+}
+
+func TestUpgraderErrorHandler(t *testing.T) {
+	upgr := Upgrader{
+		ErrorHandler: func(ctx *fasthttp.RequestCtx, status int, reason string) {
+			ctx.SetStatusCode(status)
+			ctx.SetContentType("application/json")
+			ctx.Response.Header.Set("X-Content-Type-Options", "nosniff")
+			fmt.Fprintf(ctx, `{"error":%q}`, reason)
+		},
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod("GET")
+	ctx.Request.Header.Set("Connection", "Upgrade")
+	ctx.Request.Header.Set("Upgrade", "websocket")
+	ctx.Request.Header.Set("Sec-WebSocket-Version", "7")
+
+	if _, err := upgr.Accept(&ctx); err != ErrCannotUpgrade {
+		t.Fatalf("Unexpected error: %v <> %v", err, ErrCannotUpgrade)
+	}
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusUpgradeRequired {
+		t.Fatalf("Unexpected status: %d <> %d", code, fasthttp.StatusUpgradeRequired)
+	}
+	if ct := string(ctx.Response.Header.ContentType()); ct != "application/json" {
+		t.Fatalf("Unexpected content type: %s", ct)
+	}
+	if body := string(ctx.Response.Body()); body != `{"error":"Versions not supported"}` {
+		t.Fatalf("Unexpected body: %s", body)
+	}
+}
+
+func TestUpgraderVersionNotSupported(t *testing.T) {
+	var upgr Upgrader
+
+	for _, version := range []string{"", "1", "8"} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.Header.Set("Connection", "Upgrade")
+		ctx.Request.Header.Set("Upgrade", "websocket")
+		ctx.Request.Header.Set("Sec-WebSocket-Version", version)
+
+		if _, err := upgr.Accept(&ctx); err != ErrCannotUpgrade {
+			t.Fatalf("Unexpected error: %v <> %v", err, ErrCannotUpgrade)
+		}
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusUpgradeRequired {
+			t.Fatalf("Unexpected status: %d <> %d", code, fasthttp.StatusUpgradeRequired)
+		}
+		if v := string(ctx.Response.Header.Peek("Sec-WebSocket-Version")); v != "13" {
+			t.Fatalf("Unexpected versions: %q", v)
+		}
+	}
+}
+
+func TestUpgraderMaxConns(t *testing.T) {
+	connected := make(chan struct{}, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		MaxConns:   1,
+		RetryAfter: time.Second * 2,
+		OnConnect: func(conn *Conn) {
+			connected <- struct{}{}
+		},
+		Handler: func(conn *Conn) {
+			for {
+				if _, _, err := conn.ReadMessage(nil); err != nil {
+					return
+				}
+			}
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+	<-connected
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = Client(c, "http://localhost/")
+	var uerr *UpgradeError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if uerr.StatusCode != fasthttp.StatusServiceUnavailable || uerr.RetryAfter != time.Second*2 {
+		t.Fatalf("Unexpected error: %v", uerr)
+	}
+}
+
+func TestUpgraderMaxConnsPending(t *testing.T) {
+	pending := make(chan *PendingConn, 1)
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		MaxConns:   1,
+		RetryAfter: time.Second,
+	}
+	s := fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if pc, err := upgr.Accept(ctx); err == nil {
+				pending <- pc
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	conn := openConn(t, ln)
+	defer conn.Close()
+	pc := <-pending
+
+	// the slot is reserved by the pending connection.
+	dial := func() error {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		_, err = Client(c, "http://localhost/")
+		return err
+	}
+	var uerr *UpgradeError
+	if err := dial(); !errors.As(err, &uerr) || uerr.StatusCode != fasthttp.StatusServiceUnavailable || uerr.RetryAfter != time.Second {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// closing the pending connection releases the slot.
+	pc.Close()
+	for i := 0; ; i++ {
+		err := dial()
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Slot not released: %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	(<-pending).Close()
+}
+
+func TestUpgraderProtocolHandlers(t *testing.T) {
+	handled := make(chan string, 1)
+	handler := func(name string) RequestHandler {
+		return func(conn *Conn) {
+			handled <- name + ":" + conn.Request().Subprotocol
+		}
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	upgr := Upgrader{
+		Handler: handler("default"),
+		ProtocolHandlers: map[string]RequestHandler{
+			"graphql-transport-ws": handler("graphql"),
+			"custom.v1":            handler("custom"),
+		},
+	}
+	s := fasthttp.Server{
+		Handler: upgr.Upgrade,
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	for _, e := range []struct {
+		protocols string
+		expected  string
+	}{
+		{"unknown, custom.v1", "custom:custom.v1"},
+		{"graphql-transport-ws", "graphql:graphql-transport-ws"},
+		{"", "default:"},
+	} {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := fasthttp.AcquireRequest()
+		if e.protocols != "" {
+			req.Header.Set("Sec-WebSocket-Protocol", e.protocols)
+		}
+		conn, err := ClientWithHeaders(c, "http://localhost/", req)
+		fasthttp.ReleaseRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// replying to the close frame.
+		go conn.ReadMessage(nil)
+
+		if name := <-handled; name != e.expected {
+			t.Fatalf("Unexpected handler: %s <> %s", name, e.expected)
+		}
+	}
+}
+
+func TestUpgraderServeConn(t *testing.T) {
+	upgr := Upgrader{
+		Handler: func(conn *Conn) {
+			_, b, err := conn.ReadMessage(nil)
+			if err == nil {
+				conn.Write(b)
+			}
+		},
+	}
+
+	c1, c2 := net.Pipe()
+	errch := make(chan error, 1)
+	go func() {
+		errch <- upgr.ServeConn(c1)
+	}()
+
+	fmt.Fprintf(c2, "GET / HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	br := bufio.NewReader(c2)
+	var res fasthttp.Response
+	if err := res.Read(br); err != nil {
+		t.Fatal(err)
+	}
+	if code := res.StatusCode(); code != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected status: %d", code)
+	}
+
+	conn := acquireConnReader(c2, br)
+	conn.WriteString("Hello")
+	_, b, err := conn.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %q", b)
+	}
+	conn.Close()
+
+	select {
+	case err := <-errch:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn didn't return")
+	}
+}
+
+func TestUpgraderServeConnNotUpgraded(t *testing.T) {
+	var upgr Upgrader
+
+	c1, c2 := net.Pipe()
+	errch := make(chan error, 1)
+	go func() {
+		errch <- upgr.ServeConn(c1)
+	}()
+
+	fmt.Fprintf(c2, "POST / HTTP/1.1\r\nContent-Length: 0\r\n\r\n")
+
+	var res fasthttp.Response
+	if err := res.Read(bufio.NewReader(c2)); err != nil {
+		t.Fatal(err)
+	}
+	if code := res.StatusCode(); code != fasthttp.StatusBadRequest {
+		t.Fatalf("Unexpected status: %d", code)
+	}
+	if !res.ConnectionClose() {
+		t.Fatal("The connection must be closed")
+	}
+
+	select {
+	case <-errch:
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn didn't return")
+	}
+}
@@ -0,0 +1,164 @@
+package fastws
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultWriterFrameSize is the max payload size of the frames
+// sent by the writer returned by Conn.NextWriter.
+const DefaultWriterFrameSize = 1 << 15
+
+var errWriterClosed = errors.New("message writer already closed")
+
+// NextWriter returns a writer for the next message to send using mode.
+//
+// The written data is fragmented into frames of DefaultWriterFrameSize
+// bytes as it's written, so the whole message doesn't need to be kept in memory.
+// The message is finished (sending the FIN frame) when the writer is closed.
+//
+// NextWriter waits for the message being written by other writer (if any).
+// The data frames written by other goroutines wait until the writer is closed,
+// while control frames (PING, PONG or close) can be sent between the fragments.
+// The writer must be closed by the same goroutine before writing any other
+// data frame, otherwise it will deadlock.
+func (conn *Conn) NextWriter(mode Mode) (io.WriteCloser, error) {
+	if conn.isClosed() {
+		return nil, errClosed
+	}
+	w := &messageWriter{
+		conn:   conn,
+		fr:     AcquireFrame(),
+		locked: !conn.isSingleWriter(),
+	}
+	if w.locked {
+		conn.lockMessage()
+	}
+	if mode == ModeBinary {
+		w.fr.SetBinary()
+	} else {
+		w.fr.SetText()
+	}
+
+	return w, nil
+}
+
+// ReadFrom sends the data read from r until EOF using conn.Mode,
+// implementing io.ReaderFrom.
+//
+// The data returned by every Read (up to DefaultWriterFrameSize bytes)
+// is sent right away as a message, so io.Copy can tunnel interactive
+// streams (i.e. a net.Conn) and the other writers are not blocked
+// while waiting for r. If reading from r fails the error is returned.
+func (conn *Conn) ReadFrom(r io.Reader) (n int64, err error) {
+	b := extendByteSlice(bytePool.Get().([]byte), DefaultWriterFrameSize)
+	defer bytePool.Put(b)
+
+	for {
+		m, rerr := r.Read(b)
+		if m > 0 {
+			if _, err = conn.WriteMessage(conn.Mode, b[:m]); err != nil {
+				return n, err
+			}
+			n += int64(m)
+		}
+		switch {
+		case rerr == io.EOF:
+			return n, nil
+		case rerr != nil:
+			return n, rerr
+		}
+	}
+}
+
+type messageWriter struct {
+	conn   *Conn
+	fr     *Frame
+	locked bool
+}
+
+// Write buffers b sending a frame each time the buffer gets full.
+func (w *messageWriter) Write(b []byte) (int, error) {
+	if w.fr == nil {
+		return 0, errWriterClosed
+	}
+
+	n := 0
+	for len(b) > 0 {
+		room := DefaultWriterFrameSize - w.fr.PayloadLen()
+		if room > len(b) {
+			room = len(b)
+		}
+		w.fr.Write(b[:room])
+		b = b[room:]
+		n += room
+
+		if w.fr.PayloadLen() == DefaultWriterFrameSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// ReadFrom reads r into the frame payload until EOF,
+// sending a frame after every Read returning data.
+func (w *messageWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.fr == nil {
+		return 0, errWriterClosed
+	}
+
+	for {
+		size := w.fr.PayloadLen()
+		w.fr.b = extendByteSlice(w.fr.b, DefaultWriterFrameSize)
+		m, rerr := r.Read(w.fr.b[size:])
+		w.fr.b = w.fr.b[:size+m]
+		n += int64(m)
+
+		if m > 0 {
+			if err = w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		switch {
+		case rerr == io.EOF:
+			return n, nil
+		case rerr != nil:
+			return n, rerr
+		}
+	}
+}
+
+// Close sends the remaining data setting the FIN bit.
+func (w *messageWriter) Close() error {
+	if w.fr == nil {
+		return errWriterClosed
+	}
+	err := w.flush(true)
+
+	ReleaseFrame(w.fr)
+	w.fr = nil
+	if w.locked {
+		w.conn.msgLck.Unlock()
+	}
+
+	return err
+}
+
+func (w *messageWriter) flush(fin bool) error {
+	if fin {
+		w.fr.SetFin()
+	}
+	if !w.conn.server {
+		w.conn.mask(w.fr)
+	}
+	_, err := w.conn.sendFrame(nil, w.fr)
+
+	// the next frames are continuation frames
+	w.fr.Reset()
+	w.fr.SetContinuation()
+
+	return err
+}
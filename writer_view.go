@@ -0,0 +1,61 @@
+package fastws
+
+import (
+	"context"
+	"time"
+)
+
+// WriterView is a handle of a Conn which can only write messages and ping the peer.
+//
+// It's meant to be handed to the producers of the messages (broadcasting code),
+// so they can't read from the connection, racing with the reader,
+// nor close it by accident. The writes are safe for concurrent use,
+// as the Conn writes are.
+type WriterView struct {
+	conn *Conn
+}
+
+// WriterView returns a WriterView of conn.
+func (conn *Conn) WriterView() *WriterView {
+	return &WriterView{conn: conn}
+}
+
+// Write writes b using the Conn mode, as Conn.Write does.
+func (w *WriterView) Write(b []byte) (int, error) {
+	return w.conn.Write(b)
+}
+
+// WriteString writes b using the Conn mode, as Conn.WriteString does.
+func (w *WriterView) WriteString(b string) (int, error) {
+	return w.conn.WriteString(b)
+}
+
+// WriteMessage writes b using mode, as Conn.WriteMessage does.
+func (w *WriterView) WriteMessage(mode Mode, b []byte) (int, error) {
+	return w.conn.WriteMessage(mode, b)
+}
+
+// WriteMessageContext writes b using mode, as Conn.WriteMessageContext does.
+func (w *WriterView) WriteMessageContext(ctx context.Context, mode Mode, b []byte) (int, error) {
+	return w.conn.WriteMessageContext(ctx, mode, b)
+}
+
+// WriteJSON writes v as a JSON text message, as Conn.WriteJSON does.
+func (w *WriterView) WriteJSON(v interface{}) error {
+	return w.conn.WriteJSON(v)
+}
+
+// WriteBatch writes msgs, as Conn.WriteBatch does.
+func (w *WriterView) WriteBatch(msgs []Message) (int, error) {
+	return w.conn.WriteBatch(msgs)
+}
+
+// Ping sends a PING and waits for the PONG, as Conn.Ping does.
+func (w *WriterView) Ping(ctx context.Context, payload []byte) (time.Duration, error) {
+	return w.conn.Ping(ctx, payload)
+}
+
+// IsClosed returns whether the connection has been closed, as Conn.IsClosed does.
+func (w *WriterView) IsClosed() bool {
+	return w.conn.IsClosed()
+}
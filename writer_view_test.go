@@ -0,0 +1,35 @@
+package fastws
+
+import (
+	"io"
+	"testing"
+)
+
+func TestWriterView(t *testing.T) {
+	server, client := pipeConns()
+	defer client.mustClose(false)
+	defer server.mustClose(false)
+
+	w := server.WriterView()
+	// only the writes are exposed.
+	var _ io.Writer = w
+	if _, ok := interface{}(w).(io.Reader); ok {
+		t.Fatal("Unexpected io.Reader")
+	}
+	if _, ok := interface{}(w).(io.Closer); ok {
+		t.Fatal("Unexpected io.Closer")
+	}
+
+	go w.WriteMessage(ModeBinary, []byte("Hello"))
+
+	mode, b, err := client.ReadMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != ModeBinary || string(b) != "Hello" {
+		t.Fatalf("Unexpected message: %v %q", mode, b)
+	}
+	if w.IsClosed() {
+		t.Fatal("Unexpected closed connection")
+	}
+}